@@ -2,70 +2,493 @@ package main
 
 import (
 	"context"
-	"log"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"syscall"
 	"task-api/internal/api"
+	"task-api/internal/auth"
 	"task-api/internal/config"
+	"task-api/internal/events"
+	"task-api/internal/grpcapi"
+	"task-api/internal/logging"
+	"task-api/internal/metrics"
 	"task-api/internal/queue"
+	"task-api/internal/service"
 	"task-api/internal/storage"
+	"task-api/internal/tracing"
+	"task-api/internal/webhook"
 	"time"
 
 	"github.com/gorilla/mux"
+	"google.golang.org/grpc"
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "migrate":
+			migrate(os.Args[2:])
+			return
+		case "keys":
+			keys(os.Args[2:])
+			return
+		case "namespaces":
+			namespaces(os.Args[2:])
+			return
+		case "workers":
+			workers(os.Args[2:])
+			return
+		}
+	}
+
 	cfg, err := config.Load()
 	if err != nil {
-		log.Fatalf("Failed to load config: %v", err)
+		fatal("Failed to load config", err)
 	}
 
+	if err := logging.Setup(os.Stdout, cfg.LogLevel); err != nil {
+		fatal("Failed to set up logging", err)
+	}
+	if !cfg.AuthRequired {
+		slog.Warn("Authentication is off, requests without credentials act as admin of the default namespace")
+	}
+
+	// Init Tracing
+	shutdownTracing, err := tracing.Setup(cfg.TraceExporter, cfg.TraceFile)
+	if err != nil {
+		fatal("Failed to set up tracing", err)
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdownTracing(ctx); err != nil {
+			slog.Error("Failed to flush traces", "error", err)
+		}
+	}()
+
 	// Init Storage
 	store, err := storage.New(cfg.PostgresURL)
 	if err != nil {
-		log.Fatalf("Failed to connect to database: %v", err)
+		fatal("Failed to connect to database", err)
 	}
 
-	// Init RabbitMQ
-	q, err := queue.New(cfg.RabbitMQURL)
+	if cfg.AutoMigrate {
+		applied, err := store.MigrateUp()
+		if err != nil {
+			fatal("Failed to migrate database", err)
+		}
+		for _, m := range applied {
+			slog.Info("Applied migration", "version", m.Version, "name", m.Name)
+		}
+	}
+
+	// Init Broker
+	q, err := newQueue(cfg, store)
 	if err != nil {
-		log.Fatalf("Failed to connect to RabbitMQ: %v", err)
+		fatal("Failed to connect to broker", err, "broker", cfg.Broker)
 	}
 	defer q.Close()
 
+	var tokens *auth.TokenVerifier
+	if cfg.JWKS != "" {
+		tokens, err = auth.NewTokenVerifier(cfg.JWKS, auth.TokenOptions{
+			Issuer:         cfg.JWTIssuer,
+			Audience:       cfg.JWTAudience,
+			WorkerClaim:    cfg.JWTWorkerClaim,
+			NamespaceClaim: cfg.JWTNamespaceClaim,
+		})
+		if err != nil {
+			fatal("Failed to load JWKS", err, "jwks", cfg.JWKS)
+		}
+	}
+
+	hub, err := events.NewHub(cfg.PostgresURL)
+	if err != nil {
+		fatal("Failed to listen for task events", err)
+	}
+
 	// Init Handlers
-	handler := api.NewHandler(store, q)
+	svc := service.New(store, q, service.Options{
+		Lease:                 cfg.ClaimLease,
+		ForbidCompletedParent: cfg.ForbidCompletedParent,
+		AuthRequired:          cfg.AuthRequired,
+		AdminKey:              cfg.AdminKey,
+		Tokens:                tokens,
+		MaxPending:            cfg.MaxPending,
+		WorkerRate:            cfg.RateLimitWorker,
+		ClientRate:            cfg.RateLimitClient,
+		MaxPendingPerWorker:   cfg.MaxPendingPerWorker,
+		MaxTreeDepth:          cfg.MaxTreeDepth,
+		MaxTreeSize:           cfg.MaxTreeSize,
+		Webhooks:              cfg.WebhookSecret != "",
+		Events:                hub,
+	})
+	handler := api.NewHandler(store, q, svc)
 	r := mux.NewRouter()
 	handler.RegisterRoutes(r)
 
-	log.Printf("Starting server on port %s", cfg.Port)
+	bgCtx, stopBackground := context.WithCancel(context.Background())
+	defer stopBackground()
+	go svc.RunLeaseReaper(bgCtx, 10*time.Second)
+	go metrics.RunRefresher(bgCtx, store, 15*time.Second)
+	go hub.Run(bgCtx)
+	if cfg.WebhookSecret != "" {
+		dispatcher := webhook.NewDispatcher(store, webhook.Options{
+			Secret:        cfg.WebhookSecret,
+			AllowNetworks: cfg.WebhookAllowNetworks,
+			MaxAttempts:   cfg.WebhookMaxAttempts,
+			Timeout:       cfg.WebhookTimeout,
+		})
+		go dispatcher.Run(bgCtx, time.Second)
+	} else {
+		slog.Warn("WEBHOOK_SECRET is not set, webhooks are off and callback_url is refused")
+	}
+
+	slog.Info("Starting server", "port", cfg.Port)
 
 	srv := &http.Server{
 		Addr:    ":" + cfg.Port,
 		Handler: r,
 	}
+	srv.RegisterOnShutdown(handler.CloseWorkerSockets)
 
 	go func() {
 		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Fatalf("Server failed: %v", err)
+			fatal("Server failed", err)
 		}
 	}()
 
+	var grpcSrv *grpc.Server
+	if cfg.GRPCPort != "" {
+		lis, err := net.Listen("tcp", ":"+cfg.GRPCPort)
+		if err != nil {
+			fatal("Failed to listen for gRPC", err, "port", cfg.GRPCPort)
+		}
+		slog.Info("Starting gRPC server", "port", cfg.GRPCPort)
+		grpcSrv = grpcapi.NewServer(svc)
+		go func() {
+			if err := grpcSrv.Serve(lis); err != nil {
+				fatal("gRPC server failed", err)
+			}
+		}()
+	}
+
 	// Wait for interrupt signal to gracefully shutdown the server with
 	// a timeout of 5 seconds.
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
-	log.Println("Shutting down server...")
+	slog.Info("Shutting down server")
+	// Also ends open event streams, which never go idle by themselves
+	stopBackground()
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
+	if grpcSrv != nil {
+		// Watches end with stopBackground, so this does not wait long
+		go func() {
+			<-ctx.Done()
+			grpcSrv.Stop()
+		}()
+		grpcSrv.GracefulStop()
+	}
 	if err := srv.Shutdown(ctx); err != nil {
-		log.Fatal("Server forced to shutdown:", err)
+		fatal("Server forced to shutdown", err)
+	}
+
+	slog.Info("Server exiting")
+}
+
+func newQueue(cfg *config.Config, store *storage.Storage) (queue.Queue, error) {
+	switch cfg.Broker {
+	case config.BrokerNATS:
+		return queue.NewNATS(cfg.NATSURL, cfg.NATSSubject)
+	case config.BrokerRedis:
+		return queue.NewRedis(cfg.RedisURL, cfg.RedisPrefix, cfg.RedisGroup, cfg.RedisMaxLen)
+	case config.BrokerPostgres:
+		return queue.NewPostgres(store, cfg.ClaimLease), nil
+	default:
+		return queue.NewRabbitMQ(cfg.RabbitMQURL)
+	}
+}
+
+// migrate implements "api migrate up|down [steps]|status".
+func migrate(args []string) {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "Usage: api migrate up|down [steps]|status")
+		os.Exit(2)
+	}
+
+	store := openStore()
+
+	switch args[0] {
+	case "up":
+		applied, err := store.MigrateUp()
+		if err != nil {
+			fatal("Failed to migrate database", err)
+		}
+		for _, m := range applied {
+			fmt.Printf("Applied %d_%s\n", m.Version, m.Name)
+		}
+		if len(applied) == 0 {
+			fmt.Println("Database is up to date")
+		}
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			n, err := strconv.Atoi(args[1])
+			if err != nil || n < 1 {
+				fmt.Fprintf(os.Stderr, "Invalid number of steps %q\n", args[1])
+				os.Exit(2)
+			}
+			steps = n
+		}
+		reverted, err := store.MigrateDown(steps)
+		if err != nil {
+			fatal("Failed to migrate database", err)
+		}
+		for _, m := range reverted {
+			fmt.Printf("Reverted %d_%s\n", m.Version, m.Name)
+		}
+	case "status":
+		status, err := store.MigrationStatus()
+		if err != nil {
+			fatal("Failed to read migration status", err)
+		}
+		for _, st := range status {
+			applied := "pending"
+			if st.AppliedAt != nil {
+				applied = st.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Printf("%04d  %-30s  %s\n", st.Version, st.Name, applied)
+		}
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown migrate command %q\n", args[0])
+		os.Exit(2)
+	}
+}
+
+// keys implements "api keys create|list|revoke".
+func keys(args []string) {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "Usage: api keys create [--namespace NS] --worker NAME [--delegate a,b] | create [--namespace NS] --admin | list | revoke ID")
+		os.Exit(2)
+	}
+
+	store := openStore()
+
+	switch args[0] {
+	case "create":
+		fs := flag.NewFlagSet("keys create", flag.ExitOnError)
+		namespace := fs.String("namespace", storage.DefaultNamespace, "namespace of the key; admin keys without one are global")
+		worker := fs.String("worker", "", "worker the key acts for")
+		delegate := fs.String("delegate", "", "comma-separated workers the key may also create tasks for")
+		admin := fs.Bool("admin", false, "create an admin key")
+		fs.Parse(args[1:])
+
+		if *admin == (*worker != "") {
+			fmt.Fprintln(os.Stderr, "Pass exactly one of --worker and --admin")
+			os.Exit(2)
+		}
+		k := &storage.APIKey{Admin: *admin}
+		if *worker != "" {
+			k.Worker = worker
+		}
+		// Admin keys are global unless --namespace is given
+		nsSet := false
+		fs.Visit(func(f *flag.Flag) { nsSet = nsSet || f.Name == "namespace" })
+		if !*admin || nsSet {
+			k.Namespace = namespace
+		}
+		if *delegate != "" {
+			k.Delegates = strings.Split(*delegate, ",")
+		}
+
+		key, err := auth.GenerateKey()
+		if err != nil {
+			fatal("Failed to generate key", err)
+		}
+		id, err := store.CreateAPIKey(k, auth.HashKey(key))
+		if err == storage.ErrWorkerNotFound {
+			fmt.Fprintf(os.Stderr, "Worker %q does not exist in namespace %q\n", *worker, *namespace)
+			os.Exit(1)
+		}
+		if err == storage.ErrNamespaceNotFound {
+			fmt.Fprintf(os.Stderr, "Namespace %q does not exist\n", *namespace)
+			os.Exit(1)
+		}
+		if err != nil {
+			fatal("Failed to create key", err)
+		}
+		fmt.Printf("Created key %s\n", id)
+		fmt.Println(key)
+		fmt.Fprintln(os.Stderr, "The key is shown only once, store it now.")
+	case "list":
+		list, err := store.ListAPIKeys()
+		if err != nil {
+			fatal("Failed to list keys", err)
+		}
+		for _, k := range list {
+			owner := "admin"
+			if k.Worker != nil {
+				owner = *k.Worker
+			}
+			if k.Namespace != nil {
+				owner = *k.Namespace + "/" + owner
+			}
+			state := "active"
+			if k.RevokedAt != nil {
+				state = "revoked " + k.RevokedAt.Format(time.RFC3339)
+			}
+			fmt.Printf("%s  %-20s  %-30s  %s\n", k.ID, owner, strings.Join(k.Delegates, ","), state)
+		}
+	case "revoke":
+		if len(args) != 2 {
+			fmt.Fprintln(os.Stderr, "Usage: api keys revoke ID")
+			os.Exit(2)
+		}
+		err := store.RevokeAPIKey(args[1])
+		if err == storage.ErrAPIKeyNotFound {
+			fmt.Fprintf(os.Stderr, "Key %s does not exist\n", args[1])
+			os.Exit(1)
+		}
+		if err != nil {
+			fatal("Failed to revoke key", err)
+		}
+		fmt.Printf("Revoked key %s\n", args[1])
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown keys command %q\n", args[0])
+		os.Exit(2)
+	}
+}
+
+// namespaces implements "api namespaces create|list|quota".
+func namespaces(args []string) {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "Usage: api namespaces create NAME [--max-pending N] | list | quota NAME N|default")
+		os.Exit(2)
+	}
+
+	store := openStore()
+
+	switch args[0] {
+	case "create":
+		if len(args) < 2 {
+			fmt.Fprintln(os.Stderr, "Usage: api namespaces create NAME [--max-pending N]")
+			os.Exit(2)
+		}
+		fs := flag.NewFlagSet("namespaces create", flag.ExitOnError)
+		maxPending := fs.Int("max-pending", -1, "pending task quota, 0 for none; the server default if unset")
+		fs.Parse(args[2:])
+
+		ns := &storage.Namespace{Name: args[1]}
+		if !storage.ValidNamespace(ns.Name) {
+			fmt.Fprintf(os.Stderr, "Invalid namespace %q: use up to 63 lowercase letters, digits, '-' and '_'\n", ns.Name)
+			os.Exit(2)
+		}
+		if *maxPending >= 0 {
+			ns.MaxPending = maxPending
+		}
+		err := store.CreateNamespace(ns)
+		if err == storage.ErrNamespaceExists {
+			fmt.Fprintf(os.Stderr, "Namespace %q already exists\n", ns.Name)
+			os.Exit(1)
+		}
+		if err != nil {
+			fatal("Failed to create namespace", err)
+		}
+		fmt.Printf("Created namespace %s\n", ns.Name)
+	case "list":
+		list, err := store.ListNamespaces()
+		if err != nil {
+			fatal("Failed to list namespaces", err)
+		}
+		for _, ns := range list {
+			quota := "default"
+			if ns.MaxPending != nil {
+				quota = strconv.Itoa(*ns.MaxPending)
+			}
+			fmt.Printf("%-30s  max_pending=%s\n", ns.Name, quota)
+		}
+	case "quota":
+		if len(args) != 3 {
+			fmt.Fprintln(os.Stderr, "Usage: api namespaces quota NAME N|default")
+			os.Exit(2)
+		}
+		var maxPending *int
+		if args[2] != "default" {
+			n, err := strconv.Atoi(args[2])
+			if err != nil || n < 0 {
+				fmt.Fprintf(os.Stderr, "Invalid quota %q\n", args[2])
+				os.Exit(2)
+			}
+			maxPending = &n
+		}
+		err := store.SetNamespaceQuota(args[1], maxPending)
+		if err == storage.ErrNamespaceNotFound {
+			fmt.Fprintf(os.Stderr, "Namespace %q does not exist\n", args[1])
+			os.Exit(1)
+		}
+		if err != nil {
+			fatal("Failed to set quota", err)
+		}
+		fmt.Printf("Set quota of %s to %s\n", args[1], args[2])
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown namespaces command %q\n", args[0])
+		os.Exit(2)
+	}
+}
+
+// workers implements "api workers create".
+func workers(args []string) {
+	if len(args) < 2 || args[0] != "create" {
+		fmt.Fprintln(os.Stderr, "Usage: api workers create NAME [--namespace NS]")
+		os.Exit(2)
 	}
+	fs := flag.NewFlagSet("workers create", flag.ExitOnError)
+	namespace := fs.String("namespace", storage.DefaultNamespace, "namespace of the worker")
+	fs.Parse(args[2:])
+
+	if !storage.ValidWorker(args[1]) {
+		fmt.Fprintln(os.Stderr, "Worker names must be 1 to 255 characters without dots")
+		os.Exit(2)
+	}
+
+	store := openStore()
+	err := store.CreateWorker(*namespace, args[1])
+	if err == storage.ErrNamespaceNotFound {
+		fmt.Fprintf(os.Stderr, "Namespace %q does not exist\n", *namespace)
+		os.Exit(1)
+	}
+	if err != nil {
+		fatal("Failed to create worker", err)
+	}
+	fmt.Printf("Registered worker %s in namespace %s\n", args[1], *namespace)
+}
+
+// openStore connects to the database for the management subcommands.
+func openStore() *storage.Storage {
+	pgURL, err := config.LoadPostgresURL()
+	if err != nil {
+		fatal("Failed to load config", err)
+	}
+	store, err := storage.New(pgURL)
+	if err != nil {
+		fatal("Failed to connect to database", err)
+	}
+	return store
+}
 
-	log.Println("Server exiting")
+// fatal logs the error and exits.
+func fatal(msg string, err error, args ...any) {
+	slog.Error(msg, append(args, "error", err)...)
+	os.Exit(1)
 }
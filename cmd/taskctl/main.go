@@ -0,0 +1,382 @@
+// Command taskctl operates a Task API from the command line: it lists and
+// inspects tasks, cancels and retries them, re-drives failed ones and
+// manages workers. It talks to the API like any other client, through
+// pkg/client.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"task-api/pkg/client"
+)
+
+const usage = `Usage: taskctl [-api URL] [-key KEY] [-namespace NS] [-o table|json] COMMAND
+
+Commands:
+  list [--worker W] [--status S] [--parent ID] [--root ID] [--newest] [--limit N]
+  show ID
+  tree ID [--format dot|mermaid] [--durations]
+  cancel ID [--reason R]
+  retry ID
+  redrive [--worker W] [--limit N] [--dry-run]
+  workers list
+  workers register NAME
+  tail ID [--subtree]
+
+Flags:`
+
+var (
+	api *client.Client
+	ctx context.Context
+	// asJSON is set by -o json
+	asJSON bool
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, usage)
+		flag.PrintDefaults()
+	}
+	apiURL := flag.String("api", envOr("API_URL", "http://127.0.0.1:8080"), "base URL of the API, or $API_URL")
+	key := flag.String("key", os.Getenv("API_KEY"), "API key or token, or $API_KEY")
+	namespace := flag.String("namespace", "", "namespace, for credentials that are not tied to one")
+	output := flag.String("o", "table", "output format, table or json")
+	flag.Parse()
+
+	switch *output {
+	case "table":
+	case "json":
+		asJSON = true
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown output format %q\n", *output)
+		os.Exit(2)
+	}
+	args := flag.Args()
+	if len(args) == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	api = client.New(*apiURL, client.Options{APIKey: *key, Namespace: *namespace})
+	var stop context.CancelFunc
+	ctx, stop = signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	switch args[0] {
+	case "list":
+		list(args[1:])
+	case "show":
+		show(args[1:])
+	case "tree":
+		tree(args[1:])
+	case "cancel":
+		cancel(args[1:])
+	case "retry":
+		retry(args[1:])
+	case "redrive":
+		redrive(args[1:])
+	case "workers":
+		workers(args[1:])
+	case "tail":
+		tail(args[1:])
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown command %q\n", args[0])
+		os.Exit(2)
+	}
+}
+
+// list implements "taskctl list", paging until --limit tasks are listed.
+func list(args []string) {
+	fs := flag.NewFlagSet("list", flag.ExitOnError)
+	req := client.ListTasksRequest{}
+	fs.StringVar(&req.Worker, "worker", "", "only tasks of the worker")
+	fs.StringVar(&req.Status, "status", "", "only tasks with the status: pending, completed, failed or cancelled")
+	fs.StringVar(&req.ParentID, "parent", "", "only children of the task")
+	fs.StringVar(&req.RootID, "root", "", "only descendants of the root task")
+	fs.BoolVar(&req.NewestFirst, "newest", false, "list the newest tasks first")
+	limit := fs.Int("limit", 50, "most tasks to list")
+	fs.Parse(args)
+
+	var tasks []client.Task
+	for len(tasks) < *limit {
+		req.PageSize = min(*limit-len(tasks), 100)
+		page, err := api.ListTasks(ctx, req)
+		if err != nil {
+			fail("Failed to list tasks", err)
+		}
+		tasks = append(tasks, page.Tasks...)
+		if page.NextPageToken == "" {
+			break
+		}
+		req.PageToken = page.NextPageToken
+	}
+
+	if asJSON {
+		printJSON(map[string][]client.Task{"tasks": tasks})
+		return
+	}
+	w := table("ID", "WORKER", "STATUS", "PARENT", "CREATED")
+	for _, t := range tasks {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", t.ID, t.Worker, t.Status, orDash(t.ParentID), t.CreatedAt.Local().Format(time.DateTime))
+	}
+	w.Flush()
+}
+
+// show implements "taskctl show".
+func show(args []string) {
+	id := idArg("show", args)
+	t, err := api.GetTask(ctx, id)
+	if err != nil {
+		fail("Failed to get task", err)
+	}
+	if asJSON {
+		printJSON(t)
+		return
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	field := func(name, value string) {
+		if value != "" {
+			fmt.Fprintf(w, "%s:\t%s\n", name, value)
+		}
+	}
+	field("ID", t.ID)
+	field("Namespace", t.Namespace)
+	field("Worker", t.Worker)
+	field("Status", t.Status)
+	field("Parent", t.ParentID)
+	field("Root", t.RootID)
+	field("Depth", fmt.Sprint(t.Depth))
+	field("Created", t.CreatedAt.Local().Format(time.DateTime))
+	if t.CompletedAt != nil {
+		field("Finished", t.CompletedAt.Local().Format(time.DateTime))
+	}
+	field("Payload", string(t.Payload))
+	field("Result", string(t.Result))
+	field("Error", t.Error)
+	field("Progress", string(t.Progress))
+	field("Callback", t.CallbackURL)
+	field("Events", strings.Join(t.CallbackEvents, ", "))
+	w.Flush()
+}
+
+// cancel implements "taskctl cancel".
+func cancel(args []string) {
+	id := idArg("cancel", args)
+	fs := flag.NewFlagSet("cancel", flag.ExitOnError)
+	reason := fs.String("reason", "", "error of the cancelled tasks, by default \"cancelled\"")
+	fs.Parse(args[1:])
+
+	ids, err := api.CancelTask(ctx, id, *reason)
+	if err != nil {
+		fail("Failed to cancel task", err)
+	}
+	if asJSON {
+		printJSON(map[string][]string{"cancelled": ids})
+		return
+	}
+	for _, id := range ids {
+		fmt.Printf("Cancelled %s\n", id)
+	}
+}
+
+// retry implements "taskctl retry".
+func retry(args []string) {
+	id := idArg("retry", args)
+	newID, err := api.RetryTask(ctx, id, "")
+	if err != nil {
+		fail("Failed to retry task", err)
+	}
+	if asJSON {
+		printJSON(map[string]string{"id": newID})
+		return
+	}
+	fmt.Printf("Retried %s as %s\n", id, newID)
+}
+
+// redriven is a failed root task and its retry.
+type redriven struct {
+	ID      string `json:"id"`
+	Worker  string `json:"worker"`
+	Error   string `json:"error,omitempty"`
+	RetryID string `json:"retry_id,omitempty"`
+}
+
+// redrive implements "taskctl redrive": it retries failed root tasks, oldest
+// first. Failed children are left alone, their parents were queued again
+// with the failure. The retry of a task uses a key derived from its id, so
+// running redrive again never retries a task twice.
+func redrive(args []string) {
+	fs := flag.NewFlagSet("redrive", flag.ExitOnError)
+	req := client.ListTasksRequest{Status: client.StatusFailed, PageSize: 100}
+	fs.StringVar(&req.Worker, "worker", "", "only tasks of the worker")
+	limit := fs.Int("limit", 100, "most tasks to retry")
+	dryRun := fs.Bool("dry-run", false, "only list the tasks that would be retried")
+	fs.Parse(args)
+
+	var tasks []redriven
+	for len(tasks) < *limit {
+		page, err := api.ListTasks(ctx, req)
+		if err != nil {
+			fail("Failed to list failed tasks", err)
+		}
+		for _, t := range page.Tasks {
+			if t.ParentID == "" && len(tasks) < *limit {
+				tasks = append(tasks, redriven{ID: t.ID, Worker: t.Worker, Error: t.Error})
+			}
+		}
+		if page.NextPageToken == "" {
+			break
+		}
+		req.PageToken = page.NextPageToken
+	}
+
+	if !*dryRun {
+		for i := range tasks {
+			id, err := api.RetryTask(ctx, tasks[i].ID, "redrive-"+tasks[i].ID)
+			if err != nil {
+				fail("Failed to retry task "+tasks[i].ID, err)
+			}
+			tasks[i].RetryID = id
+		}
+	}
+
+	if asJSON {
+		printJSON(map[string][]redriven{"tasks": tasks})
+		return
+	}
+	w := table("ID", "WORKER", "ERROR", "RETRY")
+	for _, t := range tasks {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", t.ID, t.Worker, truncate(t.Error, 40), orDash(t.RetryID))
+	}
+	w.Flush()
+}
+
+// workers implements "taskctl workers".
+func workers(args []string) {
+	switch {
+	case len(args) == 1 && args[0] == "list":
+		list, err := api.ListWorkers(ctx)
+		if err != nil {
+			fail("Failed to list workers", err)
+		}
+		if asJSON {
+			printJSON(map[string][]client.Worker{"workers": list})
+			return
+		}
+		w := table("NAME", "PENDING")
+		for _, wk := range list {
+			fmt.Fprintf(w, "%s\t%d\n", wk.Name, wk.Pending)
+		}
+		w.Flush()
+	case len(args) == 2 && args[0] == "register":
+		if err := api.RegisterWorker(ctx, args[1]); err != nil {
+			fail("Failed to register worker", err)
+		}
+		if asJSON {
+			printJSON(map[string]string{"name": args[1]})
+			return
+		}
+		fmt.Printf("Registered worker %s\n", args[1])
+	default:
+		fmt.Fprintln(os.Stderr, "Usage: taskctl workers list|register NAME")
+		os.Exit(2)
+	}
+}
+
+// tail implements "taskctl tail": it prints the events of a task until it,
+// or with --subtree its whole subtree, is finished. With -o json the events
+// are printed one JSON object per line.
+func tail(args []string) {
+	id := idArg("tail", args)
+	fs := flag.NewFlagSet("tail", flag.ExitOnError)
+	subtree := fs.Bool("subtree", false, "also follow the descendants of the task")
+	fs.Parse(args[1:])
+
+	stream, err := api.Events(ctx, id, *subtree)
+	if err != nil {
+		fail("Failed to follow task", err)
+	}
+	defer stream.Close()
+	enc := json.NewEncoder(os.Stdout)
+	for {
+		ev, err := stream.Next()
+		if err == io.EOF || ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			fail("Failed to read events", err)
+		}
+		if asJSON {
+			enc.Encode(ev)
+			continue
+		}
+		fmt.Printf("%s  %-9s  %s  %-20s  %-9s  %s\n", ev.At.Local().Format(time.TimeOnly), ev.Type, ev.TaskID, ev.Worker, ev.Status, ev.Progress)
+	}
+}
+
+// idArg returns the task id that the command takes as first argument.
+func idArg(command string, args []string) string {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		fmt.Fprintf(os.Stderr, "Usage: taskctl %s ID\n", command)
+		os.Exit(2)
+	}
+	return args[0]
+}
+
+// table returns a writer for a table with the given columns. Rows are
+// tab-separated; Flush prints them aligned.
+func table(columns ...string) *tabwriter.Writer {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, strings.Join(columns, "\t"))
+	return w
+}
+
+func printJSON(v any) {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// truncate shortens s to n runes, on one line.
+func truncate(s string, n int) string {
+	s = strings.ReplaceAll(s, "\n", " ")
+	if r := []rune(s); len(r) > n {
+		return string(r[:n-1]) + "…"
+	}
+	return s
+}
+
+func envOr(name, fallback string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return fallback
+}
+
+// fail prints the error and exits. API errors are printed with their code.
+func fail(msg string, err error) {
+	var e *client.Error
+	if errors.As(err, &e) && e.Code != "" {
+		fmt.Fprintf(os.Stderr, "%s: %s (%d %s)\n", msg, e.Message, e.Status, e.Code)
+	} else {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", msg, err)
+	}
+	os.Exit(1)
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"task-api/pkg/client"
+)
+
+// node is a task with its children, oldest first.
+type node struct {
+	client.Task
+	Children []*node `json:"children,omitempty"`
+}
+
+// tree implements "taskctl tree": it prints the whole tree of a task, from
+// its root. Listing the descendants of a root needs an admin key. With
+// --format it prints the diagram the API renders of the subtree of the
+// task instead, which only needs the rights to follow the task.
+func tree(args []string) {
+	id := idArg("tree", args)
+	fs := flag.NewFlagSet("tree", flag.ExitOnError)
+	format := fs.String("format", "", "print a diagram of the subtree: dot or mermaid")
+	durations := fs.Bool("durations", false, "add durations to the diagram")
+	fs.Parse(args[1:])
+	if *format != "" {
+		diagram, err := api.TaskTree(ctx, id, *format, *durations)
+		if err != nil {
+			fail("Failed to render tree", err)
+		}
+		fmt.Print(diagram)
+		return
+	}
+
+	t, err := api.GetTask(ctx, id)
+	if err != nil {
+		fail("Failed to get task", err)
+	}
+	root := &node{Task: *t}
+	if t.RootID != "" {
+		if t, err = api.GetTask(ctx, t.RootID); err != nil {
+			fail("Failed to get root task", err)
+		}
+		root = &node{Task: *t}
+	}
+
+	// Descendants are listed oldest first, so parents come before children
+	nodes := map[string]*node{root.ID: root}
+	req := client.ListTasksRequest{RootID: root.ID, PageSize: 100}
+	for {
+		page, err := api.ListTasks(ctx, req)
+		if err != nil {
+			fail("Failed to list descendants", err)
+		}
+		for _, t := range page.Tasks {
+			n := &node{Task: t}
+			nodes[t.ID] = n
+			if parent := nodes[t.ParentID]; parent != nil {
+				parent.Children = append(parent.Children, n)
+			}
+		}
+		if page.NextPageToken == "" {
+			break
+		}
+		req.PageToken = page.NextPageToken
+	}
+
+	if asJSON {
+		printJSON(root)
+		return
+	}
+	fmt.Println(label(root, id))
+	printChildren(root, "", id)
+}
+
+// printChildren prints the subtree below n, each line after prefix.
+func printChildren(n *node, prefix string, marked string) {
+	for i, c := range n.Children {
+		branch, indent := "├── ", "│   "
+		if i == len(n.Children)-1 {
+			branch, indent = "└── ", "    "
+		}
+		fmt.Println(prefix + branch + label(c, marked))
+		printChildren(c, prefix+indent, marked)
+	}
+}
+
+// label describes a task on one line, with an arrow if it is the marked
+// one.
+func label(n *node, marked string) string {
+	s := fmt.Sprintf("%s %s [%s]", n.ID, n.Worker, n.Status)
+	if n.Error != "" {
+		s += " " + truncate(n.Error, 40)
+	}
+	if n.ID == marked {
+		s += "  <-"
+	}
+	return s
+}
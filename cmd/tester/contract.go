@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/getkin/kin-openapi/routers"
+	"github.com/getkin/kin-openapi/routers/gorillamux"
+)
+
+// checkContract loads the OpenAPI document the API serves and, from then
+// on, checks every request the tester sends to the API and every response
+// against it. A mismatch ends the run like a failed test.
+func checkContract(apiURL string) {
+	resp, err := http.Get(apiURL + "/openapi.json")
+	if err != nil {
+		log.Fatalf("Failed to fetch OpenAPI document: %v", err)
+	}
+	defer resp.Body.Close()
+	b, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		log.Fatalf("Expected status 200 for /openapi.json, got %d", resp.StatusCode)
+	}
+
+	loader := openapi3.NewLoader()
+	doc, err := loader.LoadFromData(b)
+	if err != nil {
+		log.Fatalf("Failed to parse OpenAPI document: %v", err)
+	}
+	if err := doc.Validate(loader.Context); err != nil {
+		log.Fatalf("Invalid OpenAPI document: %v", err)
+	}
+	router, err := gorillamux.NewRouter(doc)
+	if err != nil {
+		log.Fatalf("Failed to route OpenAPI document: %v", err)
+	}
+
+	u, err := url.Parse(apiURL)
+	if err != nil {
+		log.Fatal(err)
+	}
+	http.DefaultClient.Transport = &contractTransport{
+		host:   u.Host,
+		router: router,
+		next:   http.DefaultTransport,
+	}
+}
+
+// contractTransport validates the traffic with the API against the
+// OpenAPI document. Other hosts pass through unchecked.
+type contractTransport struct {
+	host   string
+	router routers.Router
+	next   http.RoundTripper
+}
+
+func (t *contractTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Host != t.host {
+		return t.next.RoundTrip(req)
+	}
+
+	// Keep the body, the request is validated after it was sent
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		req = req.Clone(req.Context())
+		req.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	if err := t.check(req, body, resp); err != nil {
+		log.Fatalf("Contract violation: %s %s answered %d: %v", req.Method, req.URL.Path, resp.StatusCode, err)
+	}
+	return resp, nil
+}
+
+// check validates one exchange. Requests are only checked when the API
+// accepted them: the tester sends invalid ones on purpose. Responses are
+// always checked, and must have a documented status.
+func (t *contractTransport) check(req *http.Request, body []byte, resp *http.Response) error {
+	ctx := context.Background()
+	accepted := resp.StatusCode >= 200 && resp.StatusCode < 300
+
+	route, params, err := t.router.FindRoute(req)
+	if err != nil {
+		// Unknown routes are fine as long as the API does not serve them
+		if accepted {
+			return err
+		}
+		return nil
+	}
+
+	input := &openapi3filter.RequestValidationInput{
+		Request:    req.Clone(ctx),
+		PathParams: params,
+		Route:      route,
+		Options: &openapi3filter.Options{
+			AuthenticationFunc: openapi3filter.NoopAuthenticationFunc,
+		},
+	}
+	input.Request.Body = io.NopCloser(bytes.NewReader(body))
+	if accepted {
+		if err := openapi3filter.ValidateRequest(ctx, input); err != nil {
+			return err
+		}
+	}
+
+	opts := &openapi3filter.Options{IncludeResponseStatus: true}
+	output := &openapi3filter.ResponseValidationInput{
+		RequestValidationInput: input,
+		Status:                 resp.StatusCode,
+		Header:                 resp.Header,
+		Options:                opts,
+	}
+	contentType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if contentType == "text/event-stream" {
+		// Event streams are read by the test as they arrive
+		opts.ExcludeResponseBody = true
+		return openapi3filter.ValidateResponse(ctx, output)
+	}
+
+	b, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(b))
+	if len(b) == 0 && contentType == "" {
+		// Completing a task answers 200 without a body, creating one with
+		// ?wait 200 with the result: only the status is checked
+		opts.ExcludeResponseBody = true
+	}
+	output.SetBodyBytes(b)
+	return openapi3filter.ValidateResponse(ctx, output)
+}
@@ -1,26 +1,76 @@
 package main
 
 import (
-	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
 	"database/sql"
+	"encoding/hex"
 	"encoding/json"
+	"encoding/pem"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
+	"net/http/httptest"
 	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"task-api/internal/queue"
+	"task-api/pkg/client"
+	"task-api/pkg/worker"
+	"task-api/proto/taskapi/v1"
 	"time"
 
+	"github.com/alicebob/miniredis/v2"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/gorilla/websocket"
 	"github.com/joho/godotenv"
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
 	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/redis/go-redis/v9"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/structpb"
 )
 
 const (
 	WorkerA = "worker_a"
 	WorkerB = "worker_b"
+	// TeamNS is a second namespace that also has a worker_a
+	TeamNS = "team_x"
+	// WorkerWS is served over the WebSocket gateway only
+	WorkerWS = "worker_ws"
+	// LibParent and LibChild are run with pkg/worker
+	LibParent = "lib_parent"
+	LibChild  = "lib_child"
+	// OpsWorker is registered through the API
+	OpsWorker = "ops_worker"
+	// ClaimWorker has its tasks claimed from the database directly
+	ClaimWorker = "claim_worker"
+	// PullWorker takes its tasks with GET /workers/{name}/next only
+	PullWorker = "pull_worker"
 )
 
+// apiKey is sent as a Bearer token when API_KEY is set. It must be an
+// admin key, the tester acts for several workers.
+var apiKey string
+
+// api calls the API with apiKey. It does not retry, so that every answer
+// of the API is seen by the tests.
+var api *client.Client
+
 type Config struct {
 	PostgresURL string
 	RabbitMQURL string
@@ -39,57 +89,73 @@ func main() {
 		apiURL = "http://127.0.0.1:" + port
 	}
 
+	apiKey = os.Getenv("API_KEY")
+	api = client.New(apiURL, client.Options{APIKey: apiKey, MaxRetries: -1})
+	ctx := context.Background()
+
 	cfg := Config{
 		PostgresURL: os.Getenv("POSTGRES_URL"),
 		RabbitMQURL: os.Getenv("RABBITMQ_URL"),
 		APIUrl:      apiURL,
 	}
 
-	if cfg.PostgresURL == "" || cfg.RabbitMQURL == "" {
+	// Against an API without a broker only claiming is tested
+	claimsOnly := os.Getenv("BROKER") == "postgres"
+	if cfg.PostgresURL == "" || cfg.RabbitMQURL == "" && !claimsOnly {
 		log.Fatal("POSTGRES_URL and RABBITMQ_URL must be set")
 	}
 
 	// 1. Clean DB
 	cleanDB(cfg.PostgresURL)
 
+	if claimsOnly {
+		checkContract(cfg.APIUrl)
+		testClaims(ctx, cfg)
+		log.Println("\nALL TESTS PASSED!")
+		return
+	}
+
 	// 2. Setup RabbitMQ consumer
 	msgsA, closeA := consumeQueue(cfg.RabbitMQURL, WorkerA)
 	defer closeA()
 	msgsB, closeB := consumeQueue(cfg.RabbitMQURL, WorkerB)
 	defer closeB()
 
+	// 3. Check all API traffic against the OpenAPI document
+	checkContract(cfg.APIUrl)
+
 	// Test 1: Simple Task Flow
 	log.Println(">>> Starting Test 1: Simple Task Flow")
-	taskID := createTask(cfg.APIUrl, WorkerA, nil, map[string]interface{}{"msg": "hello"})
+	taskID := createTask(WorkerA, "", map[string]interface{}{"msg": "hello"})
 	log.Printf("Created task %s", taskID)
 
 	// Verify message in Queue A
 	verifyMessage(msgsA, taskID)
 
 	// Complete task
-	completeTask(cfg.APIUrl, taskID, map[string]interface{}{"status": "done"})
+	completeTask(taskID, map[string]interface{}{"status": "done"})
 	log.Println("Task completed. Test 1 Passed.")
 
 	// Test 2: Tree Flow (Parent waiting for children)
 	log.Println("\n>>> Starting Test 2: Tree Flow")
 	// Create Parent (Worker A)
-	parentID := createTask(cfg.APIUrl, WorkerA, nil, map[string]interface{}{"role": "parent"})
+	parentID := createTask(WorkerA, "", map[string]interface{}{"role": "parent"})
 	log.Printf("Created Parent %s", parentID)
 	// Consuming parent creation message to clear queue
 	verifyMessage(msgsA, parentID)
 
 	// Create Child 1 (Worker B)
-	child1ID := createTask(cfg.APIUrl, WorkerB, &parentID, map[string]interface{}{"role": "child1"})
+	child1ID := createTask(WorkerB, parentID, map[string]interface{}{"role": "child1"})
 	log.Printf("Created Child1 %s", child1ID)
 	verifyMessage(msgsB, child1ID)
 
 	// Create Child 2 (Worker B)
-	child2ID := createTask(cfg.APIUrl, WorkerB, &parentID, map[string]interface{}{"role": "child2"})
+	child2ID := createTask(WorkerB, parentID, map[string]interface{}{"role": "child2"})
 	log.Printf("Created Child2 %s", child2ID)
 	verifyMessage(msgsB, child2ID)
 
 	// Complete Child 1
-	completeTask(cfg.APIUrl, child1ID, map[string]interface{}{"res": 1})
+	completeTask(child1ID, map[string]interface{}{"res": 1})
 	log.Println("Completed Child 1. Verifying NO message for Parent yet...")
 
 	// Ensure NO message in Queue A (Test Wait)
@@ -101,7 +167,7 @@ func main() {
 	}
 
 	// Complete Child 2
-	completeTask(cfg.APIUrl, child2ID, map[string]interface{}{"res": 2})
+	completeTask(child2ID, map[string]interface{}{"res": 2})
 	log.Println("Completed Child 2. Expecting Parent message in Queue A...")
 
 	// Verify Parent Message in Queue A with Subtasks
@@ -117,33 +183,35 @@ func main() {
 	log.Println("Parent received with subtasks! Test 2 Passed.")
 
 	// Optional: Complete the Parent task to leave DB in clean state
-	completeTask(cfg.APIUrl, parentID, map[string]interface{}{"status": "parent_done"})
+	completeTask(parentID, map[string]interface{}{"status": "parent_done"})
 	log.Println("Parent task completed.")
 
 	// Test 3: Duplicate Completion
 	log.Println("\n>>> Starting Test 3: Duplicate Completion")
-	err := completeTaskExpectError(cfg.APIUrl, child1ID, map[string]interface{}{"res": 1}, 409)
-	if err != nil {
-		log.Fatalf("Test 3 Failed: %v", err)
-	}
+	err := api.CompleteTask(ctx, child1ID, map[string]interface{}{"res": 1})
+	expectError(err, 409, client.CodeTaskAlreadyCompleted)
 	log.Println("Got expected conflict error. Test 3 Passed.")
 
 	// Test 4: Invalid Parent ID
 	log.Println("\n>>> Starting Test 4: Invalid Parent ID")
 	randomParentID := "00000000-0000-0000-0000-000000000000"
-	createTaskExpectError(cfg.APIUrl, WorkerA, &randomParentID, map[string]interface{}{"msg": "orphan"}, 500)
-	log.Println("Got expected error for missing parent. Test 4 Passed.")
+	_, err = api.CreateTask(ctx, WorkerA, client.CreateTaskRequest{ParentID: randomParentID, Payload: map[string]interface{}{"msg": "orphan"}})
+	expectError(err, 404, client.CodeParentNotFound)
+	malformedParentID := "not-a-uuid"
+	_, err = api.CreateTask(ctx, WorkerA, client.CreateTaskRequest{ParentID: malformedParentID, Payload: map[string]interface{}{"msg": "orphan"}})
+	expectError(err, 400, client.CodeInvalidParentID)
+	log.Println("Got expected errors for missing and malformed parent. Test 4 Passed.")
 
 	// Test 5: Deep Tree (Grandchild -> Child -> Parent)
 	log.Println("\n>>> Starting Test 5: Deep Tree")
 	// Level 1: Parent
-	rootID := createTask(cfg.APIUrl, WorkerA, nil, map[string]interface{}{"level": 1})
+	rootID := createTask(WorkerA, "", map[string]interface{}{"level": 1})
 	verifyMessage(msgsA, rootID)
 	// Level 2: Child
-	midID := createTask(cfg.APIUrl, WorkerB, &rootID, map[string]interface{}{"level": 2})
+	midID := createTask(WorkerB, rootID, map[string]interface{}{"level": 2})
 	verifyMessage(msgsB, midID)
 	// Level 3: Grandchild
-	leafID := createTask(cfg.APIUrl, WorkerB, &midID, map[string]interface{}{"level": 3})
+	leafID := createTask(WorkerB, midID, map[string]interface{}{"level": 3})
 	verifyMessage(msgsB, leafID)
 
 	// Complete Leaf -> Should trigger Mid?
@@ -154,7 +222,7 @@ func main() {
 	// BUT `midID` itself must be incomplete? Yes.
 	// Does trigger mean "Publish midID to Queue"? Yes.
 
-	completeTask(cfg.APIUrl, leafID, map[string]interface{}{"val": "leaf_done"})
+	completeTask(leafID, map[string]interface{}{"val": "leaf_done"})
 
 	// Expect message for midID in Queue B (since mid's worker is WorkerB)
 	// Verification: The message for midID should contain the result from leafID in "subtasks".
@@ -170,7 +238,7 @@ func main() {
 
 	// Now complete Middle Node (it was just triggered)
 	// It's processed by worker... worker sends result.
-	completeTask(cfg.APIUrl, midID, map[string]interface{}{"val": "mid_done"})
+	completeTask(midID, map[string]interface{}{"val": "mid_done"})
 
 	// Expect message for rootID in Queue A
 	msgRoot := verifyMessage(msgsA, rootID)
@@ -185,34 +253,922 @@ func main() {
 	log.Println("Root node triggered by Middle completion. Test 5 Passed.")
 
 	// Optional: Complete Root task
-	completeTask(cfg.APIUrl, rootID, map[string]interface{}{"val": "root_done"})
+	completeTask(rootID, map[string]interface{}{"val": "root_done"})
 	log.Println("Root task completed.")
 
+	// Test 6: Error Codes
+	log.Println("\n>>> Starting Test 6: Error Codes")
+	_, err = api.CreateTask(ctx, "no_such_worker", client.CreateTaskRequest{Payload: map[string]interface{}{"msg": "nobody"}})
+	expectError(err, 400, client.CodeWorkerNotFound)
+	unknownTaskID := "00000000-0000-0000-0000-000000000001"
+	expectError(api.CompleteTask(ctx, unknownTaskID, map[string]interface{}{"res": 1}), 404, client.CodeTaskNotFound)
+	// The client only sends valid JSON
+	req, _ := http.NewRequest(http.MethodPost, cfg.APIUrl+"/task/"+WorkerA, strings.NewReader("{not json"))
+	req.Header.Set("Content-Type", "application/json")
+	if apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+apiKey)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		log.Fatal(err)
+	}
+	b, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest || errorCode(b) != "invalid_body" {
+		log.Fatalf("Test 6 Failed: expected 400 invalid_body, got %d %s", resp.StatusCode, string(b))
+	}
+	log.Println("Got expected error codes. Test 6 Passed.")
+
+	// Test 7: Worker Credentials
+	log.Println("\n>>> Starting Test 7: Worker Credentials")
+	keyB := createWorkerKey(cfg.PostgresURL, WorkerB)
+	taskForA := createTask(WorkerA, "", map[string]interface{}{"msg": "for a"})
+	verifyMessage(msgsA, taskForA)
+	asB := api.WithKey(keyB)
+	_, err = asB.CreateTask(ctx, WorkerA, client.CreateTaskRequest{Payload: map[string]interface{}{}})
+	expectError(err, 403, client.CodeForbidden)
+	expectError(asB.CompleteTask(ctx, taskForA, map[string]interface{}{}), 403, client.CodeForbidden)
+	_, err = api.WithKey("tk_not_a_key").CreateTask(ctx, WorkerB, client.CreateTaskRequest{Payload: map[string]interface{}{}})
+	expectError(err, 401, client.CodeUnauthorized)
+	_, err = api.WithKey("").CreateTask(ctx, WorkerB, client.CreateTaskRequest{Payload: map[string]interface{}{}})
+	expectError(err, 401, client.CodeUnauthorized)
+	ownTask, err := asB.CreateTask(ctx, WorkerB, client.CreateTaskRequest{Payload: map[string]interface{}{"msg": "own queue"}})
+	if err != nil {
+		log.Fatalf("Test 7 Failed: worker key could not create its own task: %v", err)
+	}
+	verifyMessage(msgsB, ownTask)
+	if err := asB.CompleteTask(ctx, ownTask, map[string]interface{}{}); err != nil {
+		log.Fatalf("Test 7 Failed: worker key could not complete its own task: %v", err)
+	}
+	// Metrics name the workers of every namespace
+	for key, want := range map[string]int{"": 401, keyB: 403, apiKey: 200} {
+		if got := getMetrics(cfg.APIUrl, key); got != want {
+			log.Fatalf("Test 7 Failed: expected %d for /metrics, got %d", want, got)
+		}
+	}
+	completeTask(taskForA, map[string]interface{}{"res": "admin"})
+	log.Println("Worker key limited to its own worker. Test 7 Passed.")
+
+	// Test 8: JWT Scopes (only when the API was given the tester's JWKS)
+	if os.Getenv("JWT_JWKS") != "" {
+		log.Println("\n>>> Starting Test 8: JWT Scopes")
+		signKey := loadSigningKey()
+		tokenB := signToken(signKey, jwt.MapClaims{"sub": "tester", "worker": WorkerB, "scope": "tasks:create:" + WorkerB + " tasks:complete"})
+		jwtB := api.WithKey(tokenB)
+		_, err = jwtB.CreateTask(ctx, WorkerA, client.CreateTaskRequest{Payload: map[string]interface{}{}})
+		expectError(err, 403, client.CodeForbidden)
+		jwtTask, err := jwtB.CreateTask(ctx, WorkerB, client.CreateTaskRequest{Payload: map[string]interface{}{"msg": "jwt"}})
+		if err != nil {
+			log.Fatalf("Test 8 Failed: token could not create task: %v", err)
+		}
+		verifyMessage(msgsB, jwtTask)
+
+		createOnly := signToken(signKey, jwt.MapClaims{"sub": "tester", "scope": "tasks:create:" + WorkerB})
+		expectError(api.WithKey(createOnly).CompleteTask(ctx, jwtTask, map[string]interface{}{}), 403, client.CodeForbidden)
+		expired := signToken(signKey, jwt.MapClaims{"sub": "tester", "scope": "admin", "exp": time.Now().Add(-time.Hour).Unix()})
+		expectError(api.WithKey(expired).CompleteTask(ctx, jwtTask, map[string]interface{}{}), 401, client.CodeUnauthorized)
+		otherKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+		forged := signToken(otherKey, jwt.MapClaims{"sub": "tester", "scope": "admin"})
+		expectError(api.WithKey(forged).CompleteTask(ctx, jwtTask, map[string]interface{}{}), 401, client.CodeUnauthorized)
+
+		if err := jwtB.CompleteTask(ctx, jwtTask, map[string]interface{}{}); err != nil {
+			log.Fatalf("Test 8 Failed: token could not complete task: %v", err)
+		}
+		log.Println("Token scopes enforced. Test 8 Passed.")
+	}
+
+	// Test 9: Namespaces
+	log.Println("\n>>> Starting Test 9: Namespaces")
+	createNamespace(cfg.PostgresURL, TeamNS, 1, WorkerA)
+	msgsTeam, closeTeam := consumeQueue(cfg.RabbitMQURL, TeamNS+"."+WorkerA)
+	defer closeTeam()
+	team := api.WithNamespace(TeamNS)
+	teamTask, err := team.CreateTask(ctx, WorkerA, client.CreateTaskRequest{Payload: map[string]interface{}{"msg": "team"}})
+	if err != nil {
+		log.Fatalf("Test 9 Failed: could not create task in namespace: %v", err)
+	}
+	verifyMessage(msgsTeam, teamTask)
+	// Not visible from the default namespace
+	expectError(api.CompleteTask(ctx, teamTask, map[string]interface{}{"res": "wrong tenant"}), 404, client.CodeTaskNotFound)
+	_, err = team.CreateTask(ctx, WorkerA, client.CreateTaskRequest{Payload: map[string]interface{}{"msg": "over quota"}})
+	expectError(err, 429, client.CodeQuotaExceeded)
+	_, err = api.WithNamespace("Not Valid").CreateTask(ctx, WorkerA, client.CreateTaskRequest{Payload: map[string]interface{}{}})
+	expectError(err, 400, client.CodeInvalidNamespace)
+	if err := team.CompleteTask(ctx, teamTask, map[string]interface{}{}); err != nil {
+		log.Fatalf("Test 9 Failed: could not complete task in namespace: %v", err)
+	}
+	log.Println("Namespace isolated and quota enforced. Test 9 Passed.")
+
+	// Test 10: Tree Depth Limit (only when the API was given MAX_TREE_DEPTH)
+	if v := os.Getenv("MAX_TREE_DEPTH"); v != "" {
+		log.Println("\n>>> Starting Test 10: Tree Depth Limit")
+		maxDepth, err := strconv.Atoi(v)
+		if err != nil || maxDepth <= 0 {
+			log.Fatalf("Test 10 Failed: invalid MAX_TREE_DEPTH %q", v)
+		}
+		// chain[i] is at depth i
+		chain := []string{createTask(WorkerA, "", map[string]interface{}{"depth": 0})}
+		verifyMessage(msgsA, chain[0])
+		for d := 1; d <= maxDepth; d++ {
+			id := createTask(WorkerA, chain[d-1], map[string]interface{}{"depth": d})
+			verifyMessage(msgsA, id)
+			chain = append(chain, id)
+		}
+		_, err = api.CreateTask(ctx, WorkerA, client.CreateTaskRequest{ParentID: chain[maxDepth], Payload: map[string]interface{}{"depth": maxDepth + 1}})
+		expectError(err, 409, client.CodeTreeDepthExceeded)
+
+		// Unwind the chain so no message is left on the queue
+		for d := maxDepth; d > 0; d-- {
+			completeTask(chain[d], map[string]interface{}{"depth": d})
+			verifyMessage(msgsA, chain[d-1])
+		}
+		completeTask(chain[0], map[string]interface{}{"depth": 0})
+		log.Println("Tree depth limited. Test 10 Passed.")
+	}
+
+	// Test 11: Webhooks (only when the API was given the tester's secret)
+	if secret := os.Getenv("WEBHOOK_SECRET"); secret != "" {
+		log.Println("\n>>> Starting Test 11: Webhooks")
+		hooks, closeHooks := startWebhookReceiver()
+		defer closeHooks()
+		_, err = api.CreateTask(ctx, WorkerA, client.CreateTaskRequest{Payload: map[string]interface{}{}, CallbackURL: "ftp://example.com"})
+		expectError(err, 400, client.CodeInvalidCallbackURL)
+
+		hookParent := createTaskWith(WorkerA, client.CreateTaskRequest{
+			Payload:        map[string]interface{}{"role": "hooked parent"},
+			CallbackURL:    hooks.URL,
+			CallbackEvents: []string{"completed", "subtree_completed"},
+		})
+		verifyMessage(msgsA, hookParent)
+		hookChild := createTask(WorkerB, hookParent, map[string]interface{}{"role": "child"})
+		verifyMessage(msgsB, hookChild)
+
+		completeTask(hookParent, map[string]interface{}{"status": "waiting_for_children"})
+		expectWebhook(hooks, secret, "completed", hookParent)
+		completeTask(hookChild, map[string]interface{}{"res": "child done"})
+		verifyMessage(msgsA, hookParent)
+		expectWebhook(hooks, secret, "subtree_completed", hookParent)
+
+		// Internal addresses outside WEBHOOK_ALLOW_NETWORKS are refused
+		metadataTask := createTaskWith(WorkerA, client.CreateTaskRequest{
+			Payload:     map[string]interface{}{"role": "metadata"},
+			CallbackURL: "http://169.254.169.254/latest/meta-data/",
+		})
+		verifyMessage(msgsA, metadataTask)
+		completeTask(metadataTask, map[string]interface{}{})
+		expectRefusedWebhook(cfg.PostgresURL, metadataTask)
+		log.Println("Signed webhooks delivered. Test 11 Passed.")
+	}
+
+	// Test 12: Event Stream
+	log.Println("\n>>> Starting Test 12: Event Stream")
+	streamRoot := createTask(WorkerA, "", map[string]interface{}{"role": "streamed parent"})
+	verifyMessage(msgsA, streamRoot)
+	stream := streamEvents(streamRoot)
+	streamChild := createTask(WorkerB, streamRoot, map[string]interface{}{"role": "child"})
+	verifyMessage(msgsB, streamChild)
+	if err := api.ReportProgress(ctx, streamChild, map[string]interface{}{"percent": 50}); err != nil {
+		log.Fatalf("Test 12 Failed: ReportProgress: %v", err)
+	}
+	completeTask(streamChild, map[string]interface{}{"res": "child done"})
+	verifyMessage(msgsA, streamRoot)
+	completeTask(streamRoot, map[string]interface{}{"res": "all done"})
+	expectEvents(stream, []string{
+		"created " + streamChild,
+		"queued " + streamChild,
+		"progress " + streamChild,
+		"completed " + streamChild,
+		"queued " + streamRoot,
+		"completed " + streamRoot,
+	})
+	log.Println("Subtree events streamed. Test 12 Passed.")
+
+	// Test 13: Awaiting Results
+	log.Println("\n>>> Starting Test 13: Awaiting Results")
+	// Act as worker_a: complete the next task as soon as it arrives
+	go func() {
+		msg := <-msgsA
+		var body map[string]interface{}
+		json.Unmarshal(msg.Body, &body)
+		id, _ := body["id"].(string)
+		completeTask(id, map[string]interface{}{"answer": 42})
+	}()
+	awaited, err := api.CreateTaskAndWait(ctx, WorkerA, client.CreateTaskRequest{Payload: map[string]interface{}{"question": "?"}}, 10*time.Second)
+	expectResult(awaited, err, "completed", `{"answer":42}`)
+
+	pendingID := createTask(WorkerA, "", map[string]interface{}{"msg": "slow"})
+	verifyMessage(msgsA, pendingID)
+	pending, err := api.AwaitResult(ctx, pendingID, time.Second)
+	if err != nil || pending.Status != client.StatusPending {
+		log.Fatalf("Test 13 Failed: expected a pending task, got %+v %v", pending, err)
+	}
+	completeTask(pendingID, map[string]interface{}{"answer": "late"})
+	done, err := api.AwaitResult(ctx, pendingID, 0)
+	expectResult(done, err, "completed", `{"answer":"late"}`)
+	log.Println("Results awaited. Test 13 Passed.")
+
+	// Test 14: WebSocket Gateway
+	log.Println("\n>>> Starting Test 14: WebSocket Gateway")
+	// A worker of its own, the other queues are consumed over AMQP
+	registerWorker(cfg.PostgresURL, WorkerWS)
+	ws := dialWorker(WorkerWS)
+	defer ws.Close()
+	wsTask := createTask(WorkerWS, "", map[string]interface{}{"msg": "over websocket"})
+	expectSocket(ws, "task", "", wsTask)
+	sendSocket(ws, map[string]interface{}{"type": "heartbeat", "ref": "hb"})
+	expectSocket(ws, "ack", "hb", "")
+	sendSocket(ws, map[string]interface{}{
+		"type": "create", "ref": "sub", "worker": WorkerB, "parent_id": wsTask,
+		"payload": map[string]interface{}{"role": "child"},
+	})
+	wsChild := expectSocket(ws, "ack", "sub", "")
+	verifyMessage(msgsB, wsChild)
+	sendSocket(ws, map[string]interface{}{"type": "bogus", "ref": "bad"})
+	if e := expectSocket(ws, "error", "bad", ""); e != "invalid_message_type" {
+		log.Fatalf("Test 14 Failed: expected invalid_message_type, got %q", e)
+	}
+	completeTask(wsChild, map[string]interface{}{"res": "child done"})
+	sendSocket(ws, map[string]interface{}{"type": "complete", "ref": "done", "id": wsTask, "result": map[string]interface{}{"answer": "ws"}})
+	expectSocket(ws, "ack", "done", wsTask)
+	wsResult, err := api.AwaitResult(ctx, wsTask, 0)
+	expectResult(wsResult, err, "completed", `{"answer":"ws"}`)
+	// A parent waiting for its subtasks frees its slot and comes back
+	// with them
+	wsParent := createTask(WorkerWS, "", map[string]interface{}{"role": "ws parent"})
+	expectSocket(ws, "task", "", wsParent)
+	sendSocket(ws, map[string]interface{}{"type": "wait", "ref": "early", "id": wsParent})
+	if e := expectSocket(ws, "error", "early", ""); e != "task_has_no_children" {
+		log.Fatalf("Test 14 Failed: expected task_has_no_children, got %q", e)
+	}
+	sendSocket(ws, map[string]interface{}{
+		"type": "create", "ref": "sub2", "worker": WorkerB, "parent_id": wsParent,
+		"payload": map[string]interface{}{"role": "child"},
+	})
+	wsChild = expectSocket(ws, "ack", "sub2", "")
+	verifyMessage(msgsB, wsChild)
+	sendSocket(ws, map[string]interface{}{"type": "wait", "ref": "wait", "id": wsParent})
+	expectSocket(ws, "ack", "wait", wsParent)
+	completeTask(wsChild, map[string]interface{}{"res": "child done"})
+	expectSocket(ws, "task", "", wsParent)
+	sendSocket(ws, map[string]interface{}{"type": "complete", "ref": "done2", "id": wsParent, "result": map[string]interface{}{}})
+	expectSocket(ws, "ack", "done2", wsParent)
+	log.Println("Worker served over WebSocket. Test 14 Passed.")
+
+	// Test 15: Cancellation and gRPC
+	log.Println("\n>>> Starting Test 15: Cancellation and gRPC")
+	cancelRoot := createTask(WorkerA, "", map[string]interface{}{"role": "cancelled parent"})
+	verifyMessage(msgsA, cancelRoot)
+	cancelChild := createTask(WorkerB, cancelRoot, map[string]interface{}{"role": "cancelled child"})
+	verifyMessage(msgsB, cancelChild)
+	cancelLeaf := createTask(WorkerB, cancelRoot, map[string]interface{}{"role": "cancelled leaf"})
+	verifyMessage(msgsB, cancelLeaf)
+
+	// Cancelling one child over HTTP leaves the other pending
+	cancelledLeaf, err := api.CancelTask(ctx, cancelLeaf, "not needed")
+	if err != nil || len(cancelledLeaf) != 1 || cancelledLeaf[0] != cancelLeaf {
+		log.Fatalf("Test 15 Failed: expected only %s cancelled, got %v %v", cancelLeaf, cancelledLeaf, err)
+	}
+	expectError(api.CompleteTask(ctx, cancelLeaf, map[string]interface{}{}), 409, client.CodeTaskAlreadyCompleted)
+
+	tasks, grpcCtx, closeGRPC := dialGRPC()
+	defer closeGRPC()
+	listed, err := tasks.ListTasks(grpcCtx, &taskapiv1.ListTasksRequest{
+		Worker: WorkerB, ParentId: cancelRoot, Status: taskapiv1.TaskStatus_TASK_STATUS_CANCELLED,
+	})
+	if err != nil || len(listed.Tasks) != 1 || listed.Tasks[0].Id != cancelLeaf || listed.Tasks[0].Error != "not needed" {
+		log.Fatalf("Test 15 Failed: expected only the cancelled leaf, got %v %v", listed, err)
+	}
+
+	// Cancelling the root over gRPC takes the pending child along
+	cancelled, err := tasks.CancelTask(grpcCtx, &taskapiv1.CancelTaskRequest{Id: cancelRoot})
+	if err != nil {
+		log.Fatalf("Test 15 Failed: CancelTask: %v", err)
+	}
+	if ids := cancelled.CancelledIds; len(ids) != 2 || ids[0] != cancelChild || ids[1] != cancelRoot {
+		log.Fatalf("Test 15 Failed: expected %s and %s cancelled, got %v", cancelChild, cancelRoot, ids)
+	}
+	child, err := tasks.GetTask(grpcCtx, &taskapiv1.GetTaskRequest{Id: cancelChild})
+	if err != nil || child.Status != taskapiv1.TaskStatus_TASK_STATUS_CANCELLED || child.Error != "cancelled" {
+		log.Fatalf("Test 15 Failed: expected a cancelled child, got %v %v", child, err)
+	}
+	_, err = tasks.CancelTask(grpcCtx, &taskapiv1.CancelTaskRequest{Id: cancelRoot})
+	expectGRPCError(err, codes.FailedPrecondition, "task_already_completed")
+	_, err = tasks.GetTask(grpcCtx, &taskapiv1.GetTaskRequest{Id: "00000000-0000-0000-0000-000000000000"})
+	expectGRPCError(err, codes.NotFound, "task_not_found")
+
+	// Tasks created over gRPC go through the same queues
+	grpcTask, err := tasks.CreateTask(grpcCtx, &taskapiv1.CreateTaskRequest{
+		Worker:  WorkerA,
+		Payload: structpb.NewStructValue(&structpb.Struct{Fields: map[string]*structpb.Value{"msg": structpb.NewStringValue("over grpc")}}),
+	})
+	if err != nil {
+		log.Fatalf("Test 15 Failed: CreateTask: %v", err)
+	}
+	verifyMessage(msgsA, grpcTask.Id)
+	watch, err := tasks.WatchTask(grpcCtx, &taskapiv1.WatchTaskRequest{Id: grpcTask.Id})
+	if err != nil {
+		log.Fatalf("Test 15 Failed: WatchTask: %v", err)
+	}
+	if _, err := tasks.CompleteTask(grpcCtx, &taskapiv1.CompleteTaskRequest{Id: grpcTask.Id, Result: structpb.NewNumberValue(42)}); err != nil {
+		log.Fatalf("Test 15 Failed: CompleteTask: %v", err)
+	}
+	for {
+		ev, err := watch.Recv()
+		if err != nil {
+			log.Fatalf("Test 15 Failed: watch ended before completion: %v", err)
+		}
+		if ev.Type == "completed" && ev.TaskId == grpcTask.Id {
+			break
+		}
+	}
+	grpcResult, err := api.AwaitResult(ctx, grpcTask.Id, 0)
+	expectResult(grpcResult, err, "completed", `42`)
+	log.Println("Tasks cancelled and served over gRPC. Test 15 Passed.")
+
+	// Test 16: Worker Library
+	log.Println("\n>>> Starting Test 16: Worker Library")
+	registerWorker(cfg.PostgresURL, LibParent)
+	registerWorker(cfg.PostgresURL, LibChild)
+	workersCtx, stopWorkers := context.WithCancel(context.Background())
+	parentDone := runWorker(workersCtx, cfg, LibParent, 1, func(ctx context.Context, t worker.Task) (worker.Result, error) {
+		var in struct {
+			Lonely bool `json:"lonely"`
+		}
+		t.Decode(&in)
+		if in.Lonely {
+			// Waits for children it never spawned
+			return worker.Wait(), nil
+		}
+		if !t.Requeued() {
+			for n := 1; n <= 3; n++ {
+				if _, err := worker.Spawn(ctx, LibChild, map[string]int{"n": n}); err != nil {
+					return worker.Result{}, err
+				}
+			}
+			return worker.Wait(), nil
+		}
+		children, err := worker.Children[struct {
+			Double int `json:"double"`
+		}](t)
+		if err != nil {
+			return worker.Result{}, err
+		}
+		sum, failed := 0, 0
+		for _, c := range children {
+			if c.Error != "" {
+				failed++
+				continue
+			}
+			sum += c.Result.Double
+		}
+		return worker.Complete(fmt.Sprintf("sum %d, failed %d", sum, failed)), nil
+	})
+	childDone := runWorker(workersCtx, cfg, LibChild, 3, func(ctx context.Context, t worker.Task) (worker.Result, error) {
+		var in struct {
+			N int `json:"n"`
+		}
+		if err := t.Decode(&in); err != nil {
+			return worker.Result{}, err
+		}
+		switch in.N {
+		case 2:
+			return worker.Result{}, fmt.Errorf("%d is not allowed", in.N)
+		case 3:
+			panic("three")
+		}
+		return worker.Complete(map[string]int{"double": 2 * in.N}), nil
+	})
+	// One child completes, one fails and one panics
+	libResult, err := api.CreateTaskAndWait(ctx, LibParent, client.CreateTaskRequest{Payload: map[string]interface{}{}}, 30*time.Second)
+	expectResult(libResult, err, "completed", `"sum 2, failed 2"`)
+	// Waiting without children would never be queued again, so it fails
+	libResult, err = api.CreateTaskAndWait(ctx, LibParent, client.CreateTaskRequest{Payload: map[string]interface{}{"lonely": true}}, 30*time.Second)
+	if err != nil || libResult.Status != "failed" || !strings.Contains(libResult.Error, "without spawning subtasks") {
+		log.Fatalf("Test 16 Failed: expected Wait without children to fail, got %+v %v", libResult, err)
+	}
+	stopWorkers()
+	<-parentDone
+	<-childDone
+	log.Println("Tree handled by the worker library. Test 16 Passed.")
+
+	// Test 17: Idempotency Keys
+	log.Println("\n>>> Starting Test 17: Idempotency Keys")
+	once := client.CreateTaskRequest{Payload: map[string]interface{}{"msg": "once"}, IdempotencyKey: "tester-once"}
+	firstID := createTaskWith(WorkerA, once)
+	// A retry of the create returns the same task and queues nothing
+	if againID := createTaskWith(WorkerA, once); againID != firstID {
+		log.Fatalf("Test 17 Failed: expected %s for the same key, got %s", firstID, againID)
+	}
+	verifyMessage(msgsA, firstID)
+	select {
+	case msg := <-msgsA:
+		log.Fatalf("Test 17 Failed: task queued twice: %s", msg.Body)
+	case <-time.After(500 * time.Millisecond):
+	}
+	// A retry after a failed publish queues the stored task, once
+	markPublishFailed(cfg.PostgresURL, firstID)
+	if againID := createTaskWith(WorkerA, once); againID != firstID {
+		log.Fatalf("Test 17 Failed: expected %s after a failed publish, got %s", firstID, againID)
+	}
+	verifyMessage(msgsA, firstID)
+	createTaskWith(WorkerA, once)
+	select {
+	case msg := <-msgsA:
+		log.Fatalf("Test 17 Failed: task queued again after it was published: %s", msg.Body)
+	case <-time.After(500 * time.Millisecond):
+	}
+	_, err = api.CreateTask(ctx, WorkerB, once)
+	expectError(err, 409, client.CodeIdempotencyKeyReused)
+	_, err = api.CreateTask(ctx, WorkerA, client.CreateTaskRequest{Payload: map[string]interface{}{"msg": "other"}, IdempotencyKey: once.IdempotencyKey})
+	expectError(err, 409, client.CodeIdempotencyKeyReused)
+	_, err = api.CreateTask(ctx, WorkerA, client.CreateTaskRequest{Payload: map[string]interface{}{}, IdempotencyKey: strings.Repeat("k", 256)})
+	expectError(err, 400, client.CodeInvalidIdempotencyKey)
+	completeTask(firstID, map[string]interface{}{"msg": "done once"})
+	log.Println("Repeated creates deduplicated. Test 17 Passed.")
+
+	// Test 18: Operator Endpoints
+	log.Println("\n>>> Starting Test 18: Operator Endpoints")
+	if err := api.RegisterWorker(ctx, OpsWorker); err != nil {
+		log.Fatalf("Test 18 Failed: RegisterWorker: %v", err)
+	}
+	// Registering again is not an error
+	if err := api.RegisterWorker(ctx, OpsWorker); err != nil {
+		log.Fatalf("Test 18 Failed: RegisterWorker again: %v", err)
+	}
+	workerList, err := api.ListWorkers(ctx)
+	if err != nil {
+		log.Fatalf("Test 18 Failed: ListWorkers: %v", err)
+	}
+	if !slices.Contains(workerList, client.Worker{Name: OpsWorker, Pending: 0}) {
+		log.Fatalf("Test 18 Failed: %s missing from %+v", OpsWorker, workerList)
+	}
+	expectError(api.RegisterWorker(ctx, strings.Repeat("w", 256)), 400, client.CodeInvalidWorkerName)
+	expectError(api.RegisterWorker(ctx, "team_x."+OpsWorker), 400, client.CodeInvalidWorkerName)
+
+	opsRoot := createTask(WorkerA, "", map[string]interface{}{"role": "ops root"})
+	verifyMessage(msgsA, opsRoot)
+	opsChild1 := createTask(WorkerB, opsRoot, map[string]interface{}{"role": "ops child1"})
+	verifyMessage(msgsB, opsChild1)
+	opsChild2 := createTask(WorkerB, opsRoot, map[string]interface{}{"role": "ops child2"})
+	verifyMessage(msgsB, opsChild2)
+	descendants, err := api.ListTasks(ctx, client.ListTasksRequest{RootID: opsRoot})
+	if err != nil || len(descendants.Tasks) != 2 || descendants.Tasks[0].ID != opsChild1 || descendants.Tasks[1].ID != opsChild2 {
+		log.Fatalf("Test 18 Failed: expected the two children under root_id, got %+v, %v", descendants, err)
+	}
+	_, err = api.ListTasks(ctx, client.ListTasksRequest{RootID: "not-a-uuid"})
+	expectError(err, 400, client.CodeInvalidRootID)
+
+	// Only failed or cancelled tasks are retried
+	_, err = api.RetryTask(ctx, opsChild1, "")
+	expectError(err, 409, client.CodeTaskNotRetryable)
+	if err := api.FailTask(ctx, opsChild1, "flaky"); err != nil {
+		log.Fatalf("Test 18 Failed: FailTask: %v", err)
+	}
+	// A key that may only create tasks for the worker may not cancel or
+	// retry them
+	delegating := api.WithKey(createWorkerKey(cfg.PostgresURL, WorkerA, WorkerB))
+	_, err = delegating.RetryTask(ctx, opsChild1, "")
+	expectError(err, 403, client.CodeForbidden)
+	_, err = delegating.CancelTask(ctx, opsChild2, "")
+	expectError(err, 403, client.CodeForbidden)
+	retryID, err := api.RetryTask(ctx, opsChild1, "tester-retry")
+	if err != nil {
+		log.Fatalf("Test 18 Failed: RetryTask: %v", err)
+	}
+	if againID, err := api.RetryTask(ctx, opsChild1, "tester-retry"); err != nil || againID != retryID {
+		log.Fatalf("Test 18 Failed: expected %s for the same key, got %s, %v", retryID, againID, err)
+	}
+	retryMsg := verifyMessage(msgsB, retryID)
+	var retryBody map[string]interface{}
+	json.Unmarshal(retryMsg.Body, &retryBody)
+	if payload, _ := retryBody["payload"].(map[string]interface{}); payload["role"] != "ops child1" {
+		log.Fatalf("Test 18 Failed: retry has payload %v", retryBody["payload"])
+	}
+	retried, err := api.GetTask(ctx, retryID)
+	if err != nil || retried.ParentID != opsRoot || retried.Worker != WorkerB {
+		log.Fatalf("Test 18 Failed: retry is not a child of %s for %s: %+v, %v", opsRoot, WorkerB, retried, err)
+	}
+	completeTask(retryID, map[string]interface{}{"res": "retried"})
+	completeTask(opsChild2, map[string]interface{}{"res": 2})
+	// The root is queued once all three children are finished
+	verifyMessage(msgsA, opsRoot)
+
+	// Once the parent is finished, only the root can be retried
+	if err := api.FailTask(ctx, opsRoot, "gave up"); err != nil {
+		log.Fatalf("Test 18 Failed: FailTask: %v", err)
+	}
+	_, err = api.RetryTask(ctx, opsChild1, "")
+	expectError(err, 409, client.CodeTaskNotRetryable)
+	newRoot, err := api.RetryTask(ctx, opsRoot, "")
+	if err != nil {
+		log.Fatalf("Test 18 Failed: RetryTask of the root: %v", err)
+	}
+	verifyMessage(msgsA, newRoot)
+	completeTask(newRoot, map[string]interface{}{"res": "redriven"})
+	log.Println("Tasks retried and workers managed. Test 18 Passed.")
+
+	// Test 19: Dashboard
+	log.Println("\n>>> Starting Test 19: Dashboard")
+	// The dashboard is public, its data is fetched with the key entered into it
+	for path, want := range map[string]string{"/ui/": `src="app.js"`, "/ui/app.js": `"/tasks?"`, "/ui/style.css": ".status.failed"} {
+		resp, err := http.Get(cfg.APIUrl + path)
+		if err != nil {
+			log.Fatalf("Test 19 Failed: GET %s: %v", path, err)
+		}
+		b, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK || !strings.Contains(string(b), want) {
+			log.Fatalf("Test 19 Failed: GET %s answered %d without %s", path, resp.StatusCode, want)
+		}
+	}
+	// It lists recent tasks newest first
+	olderID := createTask(WorkerA, "", map[string]interface{}{"msg": "older"})
+	verifyMessage(msgsA, olderID)
+	newerID := createTask(WorkerA, "", map[string]interface{}{"msg": "newer"})
+	verifyMessage(msgsA, newerID)
+	recent, err := api.ListTasks(ctx, client.ListTasksRequest{Worker: WorkerA, NewestFirst: true, PageSize: 1})
+	if err != nil || len(recent.Tasks) != 1 || recent.Tasks[0].ID != newerID {
+		log.Fatalf("Test 19 Failed: expected %s first, got %+v, %v", newerID, recent, err)
+	}
+	recent, err = api.ListTasks(ctx, client.ListTasksRequest{Worker: WorkerA, NewestFirst: true, PageSize: 1, PageToken: recent.NextPageToken})
+	if err != nil || len(recent.Tasks) != 1 || recent.Tasks[0].ID != olderID {
+		log.Fatalf("Test 19 Failed: expected %s second, got %+v, %v", olderID, recent, err)
+	}
+	// The client only sends valid orders
+	req, _ = http.NewRequest(http.MethodGet, cfg.APIUrl+"/tasks?worker="+WorkerA+"&order=random", nil)
+	if apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+apiKey)
+	}
+	if resp, err = http.DefaultClient.Do(req); err != nil {
+		log.Fatal(err)
+	}
+	b, _ = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest || errorCode(b) != client.CodeInvalidOrder {
+		log.Fatalf("Test 19 Failed: expected 400 %s, got %d %s", client.CodeInvalidOrder, resp.StatusCode, string(b))
+	}
+	completeTask(olderID, map[string]interface{}{})
+	completeTask(newerID, map[string]interface{}{})
+	log.Println("Dashboard served and tasks listed newest first. Test 19 Passed.")
+
+	// Test 20: Tree Diagrams
+	log.Println("\n>>> Starting Test 20: Tree Diagrams")
+	diagRoot := createTask(WorkerA, "", map[string]interface{}{"role": "diagram root"})
+	verifyMessage(msgsA, diagRoot)
+	diagChild := createTask(WorkerB, diagRoot, map[string]interface{}{"role": "diagram child"})
+	verifyMessage(msgsB, diagChild)
+	if err := api.FailTask(ctx, diagChild, "broken"); err != nil {
+		log.Fatalf("Test 20 Failed: FailTask: %v", err)
+	}
+	verifyMessage(msgsA, diagRoot)
+	dot, err := api.TaskTree(ctx, diagRoot, client.DiagramDOT, false)
+	if err != nil {
+		log.Fatalf("Test 20 Failed: DOT: %v", err)
+	}
+	for _, want := range []string{"digraph", fmt.Sprintf("%q -> %q", diagRoot, diagChild), `worker_b\nfailed`, `fillcolor="#fed7d7"`} {
+		if !strings.Contains(dot, want) {
+			log.Fatalf("Test 20 Failed: DOT without %s:\n%s", want, dot)
+		}
+	}
+	mermaid, err := api.TaskTree(ctx, diagRoot, client.DiagramMermaid, true)
+	if err != nil {
+		log.Fatalf("Test 20 Failed: Mermaid: %v", err)
+	}
+	for _, want := range []string{"flowchart TD", "worker_a<br/>pending", ":::failed", "running "} {
+		if !strings.Contains(mermaid, want) {
+			log.Fatalf("Test 20 Failed: Mermaid without %s:\n%s", want, mermaid)
+		}
+	}
+	// The diagram of a child shows its subtree only
+	if dot, err = api.TaskTree(ctx, diagChild, client.DiagramDOT, false); err != nil || strings.Contains(dot, diagRoot) {
+		log.Fatalf("Test 20 Failed: subtree of the child: %v\n%s", err, dot)
+	}
+	_, err = api.TaskTree(ctx, diagRoot, "svg", false)
+	expectError(err, 400, client.CodeInvalidFormat)
+	completeTask(diagRoot, map[string]interface{}{"res": "drawn"})
+	log.Println("Trees rendered as DOT and Mermaid. Test 20 Passed.")
+
+	// Test 21: Claiming Over HTTP
+	log.Println("\n>>> Starting Test 21: Claiming Over HTTP")
+	// Claims need BROKER=postgres, see testClaims
+	registerWorker(cfg.PostgresURL, ClaimWorker)
+	_, err = api.ClaimTasks(ctx, ClaimWorker, 1)
+	expectError(err, 409, client.CodeClaimUnavailable)
+	log.Println("Claiming refused with a broker. Test 21 Passed.")
+
+	// Test 22: Pulling Over HTTP
+	log.Println("\n>>> Starting Test 22: Pulling Over HTTP")
+	registerWorker(cfg.PostgresURL, PullWorker)
+	pullTask := createTask(PullWorker, "", map[string]interface{}{"msg": "pulled"})
+	// Without a wait the queue is still asked once
+	pulled, err := api.NextTask(ctx, PullWorker, 0)
+	if err != nil || pulled == nil || pulled.ID != pullTask {
+		log.Fatalf("Test 22 Failed: expected %s without wait, got %+v %v", pullTask, pulled, err)
+	}
+	completeTask(pullTask, map[string]interface{}{"res": "pulled"})
+	if pulled, err = api.NextTask(ctx, PullWorker, 0); err != nil || pulled != nil {
+		log.Fatalf("Test 22 Failed: expected no task, got %+v %v", pulled, err)
+	}
+	// A pulled parent waits for its children beyond its lease (only when
+	// the API was given the tester's CLAIM_LEASE)
+	if v := os.Getenv("CLAIM_LEASE"); v != "" {
+		lease, err := time.ParseDuration(v)
+		if err != nil {
+			log.Fatalf("Invalid CLAIM_LEASE %q", v)
+		}
+		pullParent := createTask(PullWorker, "", map[string]interface{}{"role": "pulled parent"})
+		if pulled, err = api.NextTask(ctx, PullWorker, 0); err != nil || pulled == nil || pulled.ID != pullParent {
+			log.Fatalf("Test 22 Failed: expected %s, got %+v %v", pullParent, pulled, err)
+		}
+		pullChild := createTask(WorkerB, pullParent, map[string]interface{}{"role": "slow child"})
+		verifyMessage(msgsB, pullChild)
+		// The API checks for expired leases every 10s
+		time.Sleep(lease + 11*time.Second)
+		if pulled, err = api.NextTask(ctx, PullWorker, 0); err != nil || pulled != nil {
+			log.Fatalf("Test 22 Failed: parent waiting for its child queued again: %+v %v", pulled, err)
+		}
+		completeTask(pullChild, map[string]interface{}{"res": "slow child done"})
+		pulled, err = api.NextTask(ctx, PullWorker, 2*time.Second)
+		if err != nil || pulled == nil || pulled.ID != pullParent || !strings.Contains(string(pulled.Payload), "subtasks") {
+			log.Fatalf("Test 22 Failed: expected %s with subtasks, got %+v %v", pullParent, pulled, err)
+		}
+		completeTask(pullParent, map[string]interface{}{"status": "parent_done"})
+	}
+	log.Println("Tasks pulled over HTTP. Test 22 Passed.")
+
+	// Test 23: Embedded NATS
+	log.Println("\n>>> Starting Test 23: Embedded NATS")
+	testEmbeddedNATS()
+	log.Println("NATS backend checked in-process. Test 23 Passed.")
+
+	// Test 24: Embedded Redis
+	log.Println("\n>>> Starting Test 24: Embedded Redis")
+	testEmbeddedRedis()
+	log.Println("Redis backend checked in-process. Test 24 Passed.")
+
 	log.Println("\nALL TESTS PASSED!")
 }
 
-func cleanDB(url string) {
-	db, err := sql.Open("postgres", url)
+// testClaims checks claiming over HTTP against an API started with
+// BROKER=postgres and the tester's CLAIM_LEASE: a claimed parent waiting
+// for its child is not claimed again when its lease runs out, and comes
+// back with the result of the child once that is done.
+func testClaims(ctx context.Context, cfg Config) {
+	log.Println("\n>>> Starting Test 21: Claimed Parent Outliving Its Lease")
+	lease, err := time.ParseDuration(os.Getenv("CLAIM_LEASE"))
+	if err != nil {
+		log.Fatal("CLAIM_LEASE must be set to the lease of the API")
+	}
+	registerWorker(cfg.PostgresURL, ClaimWorker)
+
+	parent := createTask(ClaimWorker, "", map[string]interface{}{"role": "claimed parent"})
+	claimed, err := api.ClaimTasks(ctx, ClaimWorker, 10)
+	if err != nil || len(claimed) != 1 || claimed[0].ID != parent {
+		log.Fatalf("Test 21 Failed: expected to claim %s, got %+v %v", parent, claimed, err)
+	}
+	child := createTask(WorkerB, parent, map[string]interface{}{"role": "slow child"})
+	if claimed, err = api.ClaimTasks(ctx, WorkerB, 10); err != nil || len(claimed) != 1 || claimed[0].ID != child {
+		log.Fatalf("Test 21 Failed: expected to claim %s, got %+v %v", child, claimed, err)
+	}
+
+	// Past the lease and the next check for expired leases, every 10s
+	time.Sleep(lease + 11*time.Second)
+	if claimed, err = api.ClaimTasks(ctx, ClaimWorker, 10); err != nil || len(claimed) != 0 {
+		log.Fatalf("Test 21 Failed: parent waiting for its child claimed again: %+v %v", claimed, err)
+	}
+	// The child's lease ran out as well
+	if claimed, err = api.ClaimTasks(ctx, WorkerB, 10); err != nil || len(claimed) != 1 || claimed[0].ID != child {
+		log.Fatalf("Test 21 Failed: expected to claim %s again, got %+v %v", child, claimed, err)
+	}
+
+	completeTask(child, map[string]interface{}{"res": "slow child done"})
+	claimed, err = api.ClaimTasks(ctx, ClaimWorker, 10)
+	if err != nil || len(claimed) != 1 || claimed[0].ID != parent ||
+		!strings.Contains(string(claimed[0].Payload), `"subtasks"`) || !strings.Contains(string(claimed[0].Payload), "slow child done") {
+		log.Fatalf("Test 21 Failed: expected %s with subtasks, got %+v %v", parent, claimed, err)
+	}
+	if claimed, err = api.ClaimTasks(ctx, ClaimWorker, 10); err != nil || len(claimed) != 0 {
+		log.Fatalf("Test 21 Failed: parent claimed twice: %+v %v", claimed, err)
+	}
+	completeTask(parent, map[string]interface{}{"status": "parent_done"})
+	log.Println("Parent not claimed again while waiting. Test 21 Passed.")
+}
+
+// testEmbeddedNATS checks the NATS backend against a JetStream server
+// started in-process, so it runs whatever broker the API uses.
+func testEmbeddedNATS() {
+	dir, err := os.MkdirTemp("", "tester-nats")
 	if err != nil {
 		log.Fatal(err)
 	}
-	defer db.Close()
-	_, err = db.Exec("TRUNCATE TABLE tasks CASCADE")
+	defer os.RemoveAll(dir)
+
+	srv, err := server.NewServer(&server.Options{
+		Port:      server.RANDOM_PORT,
+		JetStream: true,
+		StoreDir:  dir,
+		NoSigs:    true,
+	})
 	if err != nil {
-		// If table doesn't exist, try applying schema
-		log.Println("Table not found, applying schema...")
-		schema, err := os.ReadFile("migrations/schema.sql")
+		log.Fatalf("Test 23 Failed: NewServer: %v", err)
+	}
+	go srv.Start()
+	defer srv.Shutdown()
+	if !srv.ReadyForConnections(5 * time.Second) {
+		log.Fatal("Test 23 Failed: embedded server not ready")
+	}
+
+	q, err := queue.NewNATS(srv.ClientURL(), "tester", nats.InProcessServer(srv))
+	if err != nil {
+		log.Fatalf("Test 23 Failed: NewNATS: %v", err)
+	}
+	defer q.Close()
+
+	ctx := context.Background()
+	receive := func(queueName string, wait time.Duration) *queue.Message {
+		ctx, cancel := context.WithTimeout(ctx, wait)
+		defer cancel()
+		msg, err := q.Receive(ctx, queueName)
 		if err != nil {
-			log.Fatalf("Failed to read schema: %v", err)
+			log.Fatalf("Test 23 Failed: Receive: %v", err)
 		}
-		if _, err := db.Exec(string(schema)); err != nil {
-			log.Fatalf("Failed to apply schema: %v", err)
+		return msg
+	}
+
+	// The same task published twice is delivered once (Nats-Msg-Id)
+	payload := json.RawMessage(`{"msg":"nats"}`)
+	for range 2 {
+		if err := q.PublishTask(ctx, "tester_worker", "nats-task", payload); err != nil {
+			log.Fatalf("Test 23 Failed: PublishTask: %v", err)
 		}
-	} else {
-		log.Println("Database cleaned.")
+	}
+	if msg := receive("tester_worker", 2*time.Second); msg == nil || msg.ID != "nats-task" || string(msg.Payload) != string(payload) {
+		log.Fatalf("Test 23 Failed: expected nats-task, got %+v", msg)
+	}
+	if msg := receive("tester_worker", 500*time.Millisecond); msg != nil {
+		log.Fatalf("Test 23 Failed: duplicate delivered: %+v", msg)
+	}
+
+	// A parent re-queued with its subtasks is not taken for a duplicate
+	requeued := json.RawMessage(`{"msg":"nats","subtasks":[]}`)
+	if err := q.PublishTask(ctx, "tester_worker", "nats-task", requeued); err != nil {
+		log.Fatalf("Test 23 Failed: PublishTask: %v", err)
+	}
+	if msg := receive("tester_worker", 2*time.Second); msg == nil || msg.ID != "nats-task" || string(msg.Payload) != string(requeued) {
+		log.Fatalf("Test 23 Failed: expected re-queued nats-task, got %+v", msg)
+	}
+
+	// Queue names that differ only in characters streams cannot hold get
+	// streams of their own
+	if q.StreamName("team.a_b") == q.StreamName("team_a.b") {
+		log.Fatalf("Test 23 Failed: team.a_b and team_a.b share stream %s", q.StreamName("team.a_b"))
+	}
+	for _, name := range []string{"team.a_b", "team_a.b"} {
+		if err := q.PublishTask(ctx, name, "nats-"+name, payload); err != nil {
+			log.Fatalf("Test 23 Failed: PublishTask: %v", err)
+		}
+	}
+	for _, name := range []string{"team.a_b", "team_a.b"} {
+		if msg := receive(name, 2*time.Second); msg == nil || msg.ID != "nats-"+name {
+			log.Fatalf("Test 23 Failed: expected nats-%s on %s, got %+v", name, name, msg)
+		}
+		if msg := receive(name, 200*time.Millisecond); msg != nil {
+			log.Fatalf("Test 23 Failed: unexpected message on %s: %+v", name, msg)
+		}
+	}
+}
+
+// getMetrics fetches /metrics with the given key and returns the status.
+func getMetrics(apiURL, key string) int {
+	req, _ := http.NewRequest(http.MethodGet, apiURL+"/metrics", nil)
+	if key != "" {
+		req.Header.Set("Authorization", "Bearer "+key)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		log.Fatalf("Failed to fetch metrics: %v", err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+// testEmbeddedRedis checks the Redis Streams backend against miniredis,
+// an in-memory server, so it runs whatever broker the API uses.
+func testEmbeddedRedis() {
+	srv, err := miniredis.Run()
+	if err != nil {
+		log.Fatalf("Test 24 Failed: miniredis: %v", err)
+	}
+	defer srv.Close()
+
+	const maxLen = 3
+	q, err := queue.NewRedis("redis://"+srv.Addr(), "tester", "workers", maxLen)
+	if err != nil {
+		log.Fatalf("Test 24 Failed: NewRedis: %v", err)
+	}
+	defer q.Close()
+	rdb := redis.NewClient(&redis.Options{Addr: srv.Addr()})
+	defer rdb.Close()
+
+	ctx := context.Background()
+	stream := q.Stream("tester_worker")
+	payload := json.RawMessage(`{"msg":"redis"}`)
+	if err := q.PublishTask(ctx, "tester_worker", "redis-task", payload); err != nil {
+		log.Fatalf("Test 24 Failed: PublishTask: %v", err)
+	}
+	// The message travels in the "body" field, read through the group
+	groups, err := rdb.XInfoGroups(ctx, stream).Result()
+	if err != nil || len(groups) != 1 || groups[0].Name != "workers" {
+		log.Fatalf("Test 24 Failed: expected group workers on %s, got %+v %v", stream, groups, err)
+	}
+	entries, err := rdb.XRange(ctx, stream, "-", "+").Result()
+	if err != nil || len(entries) != 1 {
+		log.Fatalf("Test 24 Failed: expected one entry, got %+v %v", entries, err)
+	}
+	var body queue.Message
+	if s, _ := entries[0].Values["body"].(string); json.Unmarshal([]byte(s), &body) != nil || body.ID != "redis-task" {
+		log.Fatalf("Test 24 Failed: unexpected entry %+v", entries[0].Values)
+	}
+
+	receive := func(wait time.Duration) *queue.Message {
+		ctx, cancel := context.WithTimeout(ctx, wait)
+		defer cancel()
+		msg, err := q.Receive(ctx, "tester_worker")
+		if err != nil {
+			log.Fatalf("Test 24 Failed: Receive: %v", err)
+		}
+		return msg
+	}
+	if msg := receive(2 * time.Second); msg == nil || msg.ID != "redis-task" || string(msg.Payload) != string(payload) {
+		log.Fatalf("Test 24 Failed: expected redis-task, got %+v", msg)
+	}
+	if msg := receive(200 * time.Millisecond); msg != nil {
+		log.Fatalf("Test 24 Failed: delivered twice: %+v", msg)
+	}
+
+	// Streams are trimmed on every XADD
+	for i := range 2 * maxLen {
+		if err := q.PublishTask(ctx, "tester_worker", fmt.Sprintf("redis-task-%d", i), payload); err != nil {
+			log.Fatalf("Test 24 Failed: PublishTask: %v", err)
+		}
+	}
+	if n, err := rdb.XLen(ctx, stream).Result(); err != nil || n > maxLen {
+		log.Fatalf("Test 24 Failed: expected at most %d entries, got %d %v", maxLen, n, err)
+	}
+
+	// /readyz follows the server
+	if q.IsClosed() {
+		log.Fatal("Test 24 Failed: queue reported closed")
+	}
+	srv.Close()
+	if !q.IsClosed() {
+		log.Fatal("Test 24 Failed: queue reported open after the server stopped")
 	}
 }
 
+// runWorker runs a pkg/worker worker until ctx ends. The returned channel
+// is closed once it stopped.
+func runWorker(ctx context.Context, cfg Config, name string, concurrency int, h worker.Handler) <-chan struct{} {
+	w, err := worker.New(worker.Options{
+		Name:        name,
+		AMQPURL:     cfg.RabbitMQURL,
+		APIURL:      cfg.APIUrl,
+		APIKey:      apiKey,
+		Concurrency: concurrency,
+	}, h)
+	if err != nil {
+		log.Fatal(err)
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		w.Run(ctx)
+	}()
+	return done
+}
+
+func cleanDB(url string) {
+	db, err := sql.Open("postgres", url)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+	// The API applies migrations at startup, so the schema is in place
+	_, err = db.Exec("TRUNCATE TABLE tasks, api_keys CASCADE")
+	if err != nil {
+		log.Fatalf("Failed to clean database (was the API started with AUTO_MIGRATE disabled?): %v", err)
+	}
+	_, err = db.Exec("DELETE FROM workers WHERE namespace <> 'default'")
+	if err == nil {
+		_, err = db.Exec("DELETE FROM namespaces WHERE name <> 'default'")
+	}
+	if err != nil {
+		log.Fatalf("Failed to clean namespaces: %v", err)
+	}
+	log.Println("Database cleaned.")
+}
+
 func consumeQueue(url, qName string) (<-chan amqp.Delivery, func()) {
 	conn, err := amqp.Dial(url)
 	if err != nil {
@@ -233,71 +1189,164 @@ func consumeQueue(url, qName string) (<-chan amqp.Delivery, func()) {
 	return msgs, func() { ch.Close(); conn.Close() }
 }
 
-func createTask(url, worker string, parentID *string, payload interface{}) string {
-	body := map[string]interface{}{
-		"payload": payload,
+// createTask creates a task and returns its id. parentID is empty for a
+// root.
+func createTask(worker, parentID string, payload interface{}) string {
+	return createTaskWith(worker, client.CreateTaskRequest{ParentID: parentID, Payload: payload})
+}
+
+// createTaskWith creates a task from a full request and returns its id.
+func createTaskWith(worker string, req client.CreateTaskRequest) string {
+	id, err := api.CreateTask(context.Background(), worker, req)
+	if err != nil {
+		log.Fatalf("CreateTask failed: %v", err)
 	}
-	if parentID != nil {
-		body["parent_id"] = *parentID
+	return id
+}
+
+func completeTask(id string, result interface{}) {
+	if err := api.CompleteTask(context.Background(), id, result); err != nil {
+		log.Fatalf("CompleteTask failed: %v", err)
 	}
-	b, _ := json.Marshal(body)
-	resp, err := http.Post(url+"/task/"+worker, "application/json", bytes.NewBuffer(b))
+}
+
+// expectError fails unless err is an error response with the expected
+// status and code.
+func expectError(err error, expectedStatus int, expectedCode string) {
+	var e *client.Error
+	if !errors.As(err, &e) || e.Status != expectedStatus || e.Code != expectedCode {
+		log.Fatalf("Expected %d %q, got %v", expectedStatus, expectedCode, err)
+	}
+}
+
+// expectResult checks the outcome of a finished task.
+func expectResult(res *client.TaskResult, err error, expectedStatus, expectedResult string) {
+	if err != nil {
+		log.Fatalf("Expected %s %s, got %v", expectedStatus, expectedResult, err)
+	}
+	if res.Status != expectedStatus || string(res.Result) != expectedResult {
+		log.Fatalf("Expected %s %s, got %s %s", expectedStatus, expectedResult, res.Status, string(res.Result))
+	}
+}
+
+// createNamespace adds a namespace with a pending task quota and registers
+// workers in it, directly in the database.
+func createNamespace(url, name string, maxPending int, workers ...string) {
+	db, err := sql.Open("postgres", url)
 	if err != nil {
 		log.Fatal(err)
 	}
-	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusCreated {
-		b, _ := io.ReadAll(resp.Body)
-		log.Fatalf("CreateTask failed: %s %s", resp.Status, string(b))
+	defer db.Close()
+
+	if _, err := db.Exec("INSERT INTO namespaces (name, max_pending) VALUES ($1, $2)", name, maxPending); err != nil {
+		log.Fatalf("Failed to create namespace: %v", err)
+	}
+	for _, w := range workers {
+		if _, err := db.Exec("INSERT INTO workers (namespace, name) VALUES ($1, $2)", name, w); err != nil {
+			log.Fatalf("Failed to create worker: %v", err)
+		}
 	}
-	var res map[string]string
-	json.NewDecoder(resp.Body).Decode(&res)
-	return res["id"]
 }
 
-func createTaskExpectError(url, worker string, parentID *string, payload interface{}, expectedStatus int) {
-	body := map[string]interface{}{
-		"payload": payload,
+// markPublishFailed records, directly in the database, that the task could
+// not be queued when it was created.
+func markPublishFailed(url, id string) {
+	db, err := sql.Open("postgres", url)
+	if err != nil {
+		log.Fatal(err)
 	}
-	if parentID != nil {
-		body["parent_id"] = *parentID
+	defer db.Close()
+
+	if _, err := db.Exec("UPDATE tasks SET publish_failed = TRUE WHERE id = $1", id); err != nil {
+		log.Fatalf("Failed to mark task: %v", err)
 	}
-	b, _ := json.Marshal(body)
-	resp, err := http.Post(url+"/task/"+worker, "application/json", bytes.NewBuffer(b))
+}
+
+// registerWorker adds a worker to the default namespace, directly in the
+// database.
+func registerWorker(url, worker string) {
+	db, err := sql.Open("postgres", url)
 	if err != nil {
 		log.Fatal(err)
 	}
-	defer resp.Body.Close()
-	if resp.StatusCode != expectedStatus {
-		b, _ := io.ReadAll(resp.Body)
-		log.Fatalf("Expected status %d, got %d. Body: %s", expectedStatus, resp.StatusCode, string(b))
+	defer db.Close()
+
+	_, err = db.Exec("INSERT INTO workers (namespace, name) VALUES ('default', $1) ON CONFLICT DO NOTHING", worker)
+	if err != nil {
+		log.Fatalf("Failed to create worker: %v", err)
 	}
 }
 
-func completeTask(url, id string, result interface{}) {
-	body, _ := json.Marshal(map[string]interface{}{"result": result})
-	resp, err := http.Post(url+"/task/"+id, "application/json", bytes.NewBuffer(body))
+// createWorkerKey stores a new API key for the worker, which may also
+// create tasks for the delegates, directly in the database and returns it.
+func createWorkerKey(url, worker string, delegates ...string) string {
+	db, err := sql.Open("postgres", url)
 	if err != nil {
 		log.Fatal(err)
 	}
-	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusOK {
-		b, _ := io.ReadAll(resp.Body)
-		log.Fatalf("CompleteTask failed: %s %s", resp.Status, string(b))
+	defer db.Close()
+
+	b := make([]byte, 32)
+	rand.Read(b)
+	key := "tk_" + hex.EncodeToString(b)
+	sum := sha256.Sum256([]byte(key))
+	if delegates == nil {
+		delegates = []string{}
+	}
+	_, err = db.Exec("INSERT INTO api_keys (key_hash, worker, delegates) VALUES ($1, $2, $3)",
+		hex.EncodeToString(sum[:]), worker, pq.Array(delegates))
+	if err != nil {
+		log.Fatalf("Failed to create API key: %v", err)
 	}
+	return key
 }
 
-func completeTaskExpectError(url, id string, result interface{}, expectedStatus int) error {
-	body, _ := json.Marshal(map[string]interface{}{"result": result})
-	resp, err := http.Post(url+"/task/"+id, "application/json", bytes.NewBuffer(body))
+// loadSigningKey reads the private key matching testdata/jwks.json, or the
+// one named by JWT_TEST_KEY.
+func loadSigningKey() *rsa.PrivateKey {
+	path := os.Getenv("JWT_TEST_KEY")
+	if path == "" {
+		path = "cmd/tester/testdata/jwt_key.pem"
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		log.Fatalf("Failed to read JWT signing key: %v", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		log.Fatalf("No PEM block in %s", path)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
 	if err != nil {
-		return err
+		log.Fatalf("Failed to parse JWT signing key: %v", err)
 	}
-	defer resp.Body.Close()
-	if resp.StatusCode != expectedStatus {
-		return fmt.Errorf("Expected status %d, got %d", expectedStatus, resp.StatusCode)
+	return key.(*rsa.PrivateKey)
+}
+
+// signToken signs an RS256 token with the tester's key id. It expires in
+// a minute unless claims set "exp".
+func signToken(key *rsa.PrivateKey, claims jwt.MapClaims) string {
+	if _, ok := claims["exp"]; !ok {
+		claims["exp"] = time.Now().Add(time.Minute).Unix()
+	}
+	t := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	t.Header["kid"] = "tester"
+	s, err := t.SignedString(key)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return s
+}
+
+// errorCode extracts error.code from a JSON error response body.
+func errorCode(body []byte) string {
+	var res struct {
+		Error struct {
+			Code string `json:"code"`
+		} `json:"error"`
 	}
-	return nil
+	json.Unmarshal(body, &res)
+	return res.Error.Code
 }
 
 func verifyMessage(msgs <-chan amqp.Delivery, expectedID string) amqp.Delivery {
@@ -316,3 +1365,205 @@ func verifyMessage(msgs <-chan amqp.Delivery, expectedID string) amqp.Delivery {
 		return amqp.Delivery{}
 	}
 }
+
+// webhookReceiver collects the webhooks posted to URL.
+type webhookReceiver struct {
+	URL  string
+	reqs chan receivedWebhook
+}
+
+type receivedWebhook struct {
+	header http.Header
+	body   []byte
+}
+
+func startWebhookReceiver() (*webhookReceiver, func()) {
+	reqs := make(chan receivedWebhook, 16)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		reqs <- receivedWebhook{header: r.Header, body: b}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	return &webhookReceiver{URL: srv.URL, reqs: reqs}, srv.Close
+}
+
+// expectRefusedWebhook waits until the first webhook attempt for the task
+// is recorded and fails unless the receiver address was refused.
+func expectRefusedWebhook(url, taskID string) {
+	db, err := sql.Open("postgres", url)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		var attemptErr sql.NullString
+		err := db.QueryRow(`
+			SELECT a.error FROM webhook_attempts a
+			JOIN webhook_deliveries d ON d.id = a.delivery_id
+			WHERE d.task_id = $1
+			ORDER BY a.id LIMIT 1`, taskID).Scan(&attemptErr)
+		if err == sql.ErrNoRows {
+			time.Sleep(200 * time.Millisecond)
+			continue
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		if !strings.Contains(attemptErr.String, "receiver address not allowed") {
+			log.Fatalf("Expected the webhook for %s to be refused, got %q", taskID, attemptErr.String)
+		}
+		return
+	}
+	log.Fatalf("Timeout waiting for webhook attempt for %s", taskID)
+}
+
+// expectWebhook waits for the next webhook and checks its event, task and
+// signature.
+func expectWebhook(hooks *webhookReceiver, secret, event, taskID string) {
+	select {
+	case got := <-hooks.reqs:
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write([]byte(got.header.Get("X-Webhook-Timestamp") + "."))
+		mac.Write(got.body)
+		if want := "sha256=" + hex.EncodeToString(mac.Sum(nil)); got.header.Get("X-Webhook-Signature") != want {
+			log.Fatalf("Webhook signature mismatch: got %q, want %q", got.header.Get("X-Webhook-Signature"), want)
+		}
+		var n struct {
+			Event string `json:"event"`
+			Task  struct {
+				ID string `json:"id"`
+			} `json:"task"`
+		}
+		json.Unmarshal(got.body, &n)
+		if n.Event != event || n.Task.ID != taskID {
+			log.Fatalf("Expected webhook %s for %s, got %s", event, taskID, string(got.body))
+		}
+	case <-time.After(5 * time.Second):
+		log.Fatalf("Timeout waiting for webhook %s for %s", event, taskID)
+	}
+}
+
+// streamEvents follows the events of a task and its subtree and sends
+// "<event> <task_id>" for every event on the returned channel, which is
+// closed when the stream ends. It returns once the stream is open.
+func streamEvents(id string) <-chan string {
+	stream, err := api.Events(context.Background(), id, true)
+	if err != nil {
+		log.Fatalf("Event stream failed: %v", err)
+	}
+
+	events := make(chan string, 64)
+	go func() {
+		defer stream.Close()
+		defer close(events)
+		for {
+			ev, err := stream.Next()
+			if err != nil {
+				return
+			}
+			events <- ev.Type + " " + ev.TaskID
+		}
+	}()
+	return events
+}
+
+// expectEvents checks that the stream delivers exactly the expected events
+// and then ends.
+func expectEvents(events <-chan string, expected []string) {
+	for _, want := range expected {
+		select {
+		case got, ok := <-events:
+			if !ok {
+				log.Fatalf("Event stream ended, expected %q", want)
+			}
+			if got != want {
+				log.Fatalf("Expected event %q, got %q", want, got)
+			}
+		case <-time.After(5 * time.Second):
+			log.Fatalf("Timeout waiting for event %q", want)
+		}
+	}
+	select {
+	case got, ok := <-events:
+		if ok {
+			log.Fatalf("Unexpected event %q", got)
+		}
+	case <-time.After(5 * time.Second):
+		log.Fatalf("Event stream did not end")
+	}
+}
+
+// dialGRPC connects to the gRPC API on GRPC_PORT and returns a client with
+// a context carrying the API key.
+func dialGRPC() (taskapiv1.TaskServiceClient, context.Context, func()) {
+	port := os.Getenv("GRPC_PORT")
+	if port == "" {
+		port = "9090"
+	}
+	conn, err := grpc.NewClient("127.0.0.1:"+port, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		log.Fatal(err)
+	}
+	ctx := context.Background()
+	if apiKey != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+apiKey)
+	}
+	return taskapiv1.NewTaskServiceClient(conn), ctx, func() { conn.Close() }
+}
+
+// expectGRPCError fails unless err is a gRPC error with the given code and
+// API error code.
+func expectGRPCError(err error, code codes.Code, reason string) {
+	st := status.Convert(err)
+	got := ""
+	for _, d := range st.Details() {
+		if info, ok := d.(*errdetails.ErrorInfo); ok {
+			got = info.Reason
+		}
+	}
+	if st.Code() != code || got != reason {
+		log.Fatalf("Expected %s %q, got %s %q: %s", code, reason, st.Code(), got, st.Message())
+	}
+}
+
+// dialWorker connects to the WebSocket gateway as the worker.
+func dialWorker(worker string) *websocket.Conn {
+	conn, err := api.WorkerSocket(context.Background(), worker, 0)
+	if err != nil {
+		log.Fatalf("WebSocket connect failed: %v", err)
+	}
+	return conn
+}
+
+func sendSocket(conn *websocket.Conn, msg map[string]interface{}) {
+	if err := conn.WriteJSON(msg); err != nil {
+		log.Fatalf("WebSocket send failed: %v", err)
+	}
+}
+
+// expectSocket reads the next gateway message and fails unless it has the
+// expected type, ref and, when given, id. It returns the id, or the error
+// code of an error message.
+func expectSocket(conn *websocket.Conn, typ, ref, id string) string {
+	var msg struct {
+		Type  string `json:"type"`
+		Ref   string `json:"ref"`
+		ID    string `json:"id"`
+		Error *struct {
+			Code string `json:"code"`
+		} `json:"error"`
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if err := conn.ReadJSON(&msg); err != nil {
+		log.Fatalf("Expected %s message: %v", typ, err)
+	}
+	if msg.Type != typ || msg.Ref != ref || (id != "" && msg.ID != id) {
+		log.Fatalf("Expected %s %q for %q, got %+v", typ, ref, id, msg)
+	}
+	if msg.Error != nil {
+		return msg.Error.Code
+	}
+	return msg.ID
+}
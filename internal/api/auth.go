@@ -0,0 +1,126 @@
+package api
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"task-api/internal/auth"
+	"task-api/internal/logging"
+	"task-api/internal/service"
+
+	"github.com/gorilla/websocket"
+)
+
+// NamespaceHeader selects the namespace of a request made with credentials
+// that are not tied to one namespace.
+const NamespaceHeader = "X-Namespace"
+
+// publicPaths are served without credentials.
+var publicPaths = map[string]bool{
+	"/healthz":      true,
+	"/readyz":       true,
+	"/openapi.json": true,
+}
+
+// authenticate resolves the API key or JWT of the request to a principal,
+// see service.Authenticate, and the namespace it runs in.
+func (h *Handler) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if publicPaths[r.URL.Path] || isUIPath(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		header := r.Header.Get("Authorization")
+		if header == "" && websocket.IsWebSocketUpgrade(r) {
+			// Browsers cannot set headers on WebSocket handshakes
+			if token := r.URL.Query().Get("access_token"); token != "" {
+				header = "Bearer " + token
+			}
+		}
+		p, e := h.svc.Authenticate(r.Context(), header)
+		if e != nil {
+			if e.Status == http.StatusUnauthorized {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="task-api"`)
+			}
+			writeAPIError(w, e)
+			return
+		}
+
+		requested := r.Header.Get(NamespaceHeader)
+		if requested == "" && websocket.IsWebSocketUpgrade(r) {
+			requested = r.URL.Query().Get("namespace")
+		}
+		ns, e := service.Namespace(p, requested)
+		if e != nil {
+			writeAPIError(w, e)
+			return
+		}
+
+		ctx := auth.NewContext(r.Context(), p)
+		ctx = context.WithValue(ctx, namespaceKey{}, ns)
+		logging.Add(ctx, "namespace", ns)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+type namespaceKey struct{}
+
+// namespaceOf returns the namespace the request was resolved to: the one
+// its credentials are limited to, else the one named by X-Namespace, else
+// the default one.
+func namespaceOf(r *http.Request) string {
+	ns, _ := r.Context().Value(namespaceKey{}).(string)
+	return ns
+}
+
+// clientKey identifies the caller for the client rate limit: the API key,
+// the token subject, or the client address when neither is known.
+func clientKey(r *http.Request) string {
+	p := auth.FromContext(r.Context())
+	if p.KeyID != "" {
+		return "key:" + p.KeyID
+	}
+	if p.Subject != "" {
+		return "sub:" + p.Subject
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+// requireScope only lets callers with the given scope reach next.
+func requireScope(scope string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !auth.FromContext(r.Context()).HasScope(scope) {
+			writeError(w, http.StatusForbidden, service.CodeForbidden, "Missing scope "+scope)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// requireGlobalAdmin lets through admins that are not limited to one
+// namespace. The metrics of every namespace are labelled with its workers,
+// which other tenants must not see.
+func requireGlobalAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if p := auth.FromContext(r.Context()); !p.Admin || p.Namespace != "" {
+			writeError(w, http.StatusForbidden, service.CodeForbidden, "Needs an admin key for all namespaces")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requireWorker checks that the caller may act as the given worker. On
+// failure it writes the error response and returns false.
+func requireWorker(w http.ResponseWriter, r *http.Request, worker string) bool {
+	if e := service.CheckWorker(r.Context(), worker); e != nil {
+		writeAPIError(w, e)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,97 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"task-api/internal/events"
+	"task-api/internal/logging"
+	"task-api/internal/service"
+	"task-api/internal/storage"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// maxWait bounds every "wait" query parameter.
+const maxWait = 60 * time.Second
+
+// parseWait reads the optional "wait" query parameter, 0 when absent. On
+// failure it writes the error response and returns false.
+func parseWait(w http.ResponseWriter, r *http.Request) (time.Duration, bool) {
+	v := r.URL.Query().Get("wait")
+	if v == "" {
+		return 0, true
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 || d > maxWait {
+		writeError(w, http.StatusBadRequest, service.CodeInvalidWait, "wait must be a duration between 0s and 60s")
+		return 0, false
+	}
+	return d, true
+}
+
+// TaskResult is the outcome of a finished task.
+type TaskResult struct {
+	ID          string          `json:"id"`
+	Status      string          `json:"status"`
+	Result      json.RawMessage `json:"result,omitempty"`
+	Error       *string         `json:"error,omitempty"`
+	CompletedAt *time.Time      `json:"completed_at,omitempty"`
+}
+
+// AwaitResult returns the result of a task once it and all its descendants
+// are finished, waiting up to the "wait" query parameter for that. If the
+// wait expires it answers 202 with the task id.
+func (h *Handler) AwaitResult(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+	logging.Add(r.Context(), "task_id", id)
+
+	wait, ok := parseWait(w, r)
+	if !ok {
+		return
+	}
+
+	// Subscribe before reading the state, so nothing falls in between
+	namespace := namespaceOf(r)
+	sub, e := h.svc.Subscribe(namespace)
+	if e != nil {
+		writeAPIError(w, e)
+		return
+	}
+	if _, e := h.svc.GetTask(r.Context(), namespace, id); e != nil {
+		h.svc.Unsubscribe(sub)
+		writeAPIError(w, e)
+		return
+	}
+
+	h.writeAwaited(w, r, sub, namespace, id, wait)
+}
+
+// writeAwaited waits up to wait for the task to finish and answers with its
+// result, or with 202 and the id if it is not finished in time. It takes
+// over sub, which must have been taken before the task could finish.
+func (h *Handler) writeAwaited(w http.ResponseWriter, r *http.Request, sub *events.Subscription, namespace string, id string, wait time.Duration) {
+	ctx, cancel := context.WithTimeout(r.Context(), wait)
+	defer cancel()
+
+	t, err := h.svc.Await(ctx, sub, namespace, id)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error waiting for task", "error", err)
+		writeInternalError(w)
+		return
+	}
+	if t == nil {
+		writeJSON(w, http.StatusAccepted, map[string]string{"id": id, "status": storage.StatusPending})
+		return
+	}
+	writeJSON(w, http.StatusOK, TaskResult{
+		ID:          t.ID,
+		Status:      t.Status,
+		Result:      t.Result,
+		Error:       t.Error,
+		CompletedAt: t.CompletedAt,
+	})
+}
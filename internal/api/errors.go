@@ -0,0 +1,52 @@
+package api
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"strconv"
+	"task-api/internal/service"
+)
+
+// ErrorResponse is the body of every error response.
+type ErrorResponse struct {
+	Error ErrorBody `json:"error"`
+}
+
+type ErrorBody struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// writeAPIError sends e as the error response.
+func writeAPIError(w http.ResponseWriter, e *service.Error) {
+	if e.RetryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(e.RetryAfter.Seconds()))))
+	}
+	writeError(w, e.Status, e.Code, e.Message)
+}
+
+// writeError sends a JSON error body with the given status and code.
+func writeError(w http.ResponseWriter, status int, code string, message string) {
+	writeJSON(w, status, ErrorResponse{Error: ErrorBody{Code: code, Message: message}})
+}
+
+// writeInternalError sends the generic 500 response. Details are logged by
+// the caller, not returned.
+func writeInternalError(w http.ResponseWriter) {
+	writeError(w, http.StatusInternalServerError, service.CodeInternal, "Internal Server Error")
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func notFound(w http.ResponseWriter, r *http.Request) {
+	writeError(w, http.StatusNotFound, service.CodeNotFound, "Not found")
+}
+
+func methodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	writeError(w, http.StatusMethodNotAllowed, service.CodeMethodNotAllowed, "Method not allowed")
+}
@@ -1,49 +1,91 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
-	"log"
+	"io"
+	"log/slog"
 	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"task-api/internal/auth"
+	"task-api/internal/diagram"
+	"task-api/internal/events"
+	"task-api/internal/logging"
+	"task-api/internal/metrics"
 	"task-api/internal/queue"
+	"task-api/internal/service"
 	"task-api/internal/storage"
+	"task-api/internal/tracing"
+	"time"
 
 	"github.com/gorilla/mux"
-	// Added missing import
-	// "github.com/gorilla/mux" // Standard net/http is fine, or we use mux. Mux is better for vars.
-	// Actually user didn't specify framework. I'll stick to standard net/http with simple pattern matching if possible,
-	// or just use common sense. "GET /task/{id}" implies path params.
-	// I'll assume I can use manually parsing or regex, OR slightly better: standard library in Go 1.22 supports wildcards!
-	// But let's check go.mod... it said go 1.something. I'll strictly use a simple mux or just split path.
-	// I'll grab gorilla/mux to be safe and cleaner.
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
-// Add gorilla/mux to deps later if needed, but for now I will implement simple path parsing or just assume standard ServeMux with Go 1.22 features?
-// To result in less friction, I will do a quick check if I can use standard lib.
-// Wait, I will use `http.ServeMux` and manual parsing to avoid external deps if I can, OR just use `gorilla/mux` which is standard.
-// Let's add gorilla/mux to go.mod in a separate step or just use it.
-// I'll use it in imports, I'll run `go get` for it.
-
 type Handler struct {
 	store *storage.Storage
-	queue *queue.Queue
+	queue queue.Queue
+	svc   *service.Service
+
+	// socketsClosing is closed by CloseWorkerSockets
+	socketsClosing chan struct{}
+	closeSockets   sync.Once
 }
 
-func NewHandler(store *storage.Storage, queue *queue.Queue) *Handler {
+func NewHandler(store *storage.Storage, queue queue.Queue, svc *service.Service) *Handler {
 	return &Handler{
 		store: store,
 		queue: queue,
+		svc:   svc,
+
+		socketsClosing: make(chan struct{}),
 	}
 }
 
 func (h *Handler) RegisterRoutes(r *mux.Router) {
+	r.NotFoundHandler = http.HandlerFunc(notFound)
+	r.MethodNotAllowedHandler = http.HandlerFunc(methodNotAllowed)
+	r.Use(logging.Middleware)
+	r.Use(metrics.Middleware)
+	r.Use(h.authenticate)
+
 	// Probes
 	r.HandleFunc("/healthz", h.Healthz).Methods("GET")
 	r.HandleFunc("/readyz", h.Readyz).Methods("GET")
+	r.Handle("/metrics", requireGlobalAdmin(promhttp.Handler())).Methods("GET")
+	r.HandleFunc("/openapi.json", h.OpenAPI).Methods("GET")
+
+	// Dashboard
+	r.Handle(strings.TrimSuffix(UIPath, "/"), http.RedirectHandler(UIPath, http.StatusMovedPermanently)).Methods("GET")
+	r.PathPrefix(UIPath).Handler(uiHandler()).Methods("GET")
 
 	// Match UUID for ID-based routes
-	r.HandleFunc("/task/{id:[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}}", h.CompleteTask).Methods("POST")
+	r.HandleFunc("/task/{id:"+service.UUIDPattern+"}", requireScope(auth.ScopeComplete, h.CompleteTask)).Methods("POST")
+	r.HandleFunc("/task/{id:"+service.UUIDPattern+"}/fail", requireScope(auth.ScopeComplete, h.FailTask)).Methods("POST")
+	r.HandleFunc("/task/{id:"+service.UUIDPattern+"}/progress", requireScope(auth.ScopeComplete, h.ReportProgress)).Methods("POST")
+	r.HandleFunc("/task/{id:"+service.UUIDPattern+"}/wait", requireScope(auth.ScopeComplete, h.WaitForChildren)).Methods("POST")
+	r.HandleFunc("/task/{id:"+service.UUIDPattern+"}/events", h.TaskEvents).Methods("GET")
+	r.HandleFunc("/task/{id:"+service.UUIDPattern+"}/result", h.AwaitResult).Methods("GET")
+	r.HandleFunc("/task/{id:"+service.UUIDPattern+"}/cancel", h.CancelTask).Methods("POST")
+	r.HandleFunc("/task/{id:"+service.UUIDPattern+"}/retry", h.RetryTask).Methods("POST")
+	r.HandleFunc("/task/{id:"+service.UUIDPattern+"}/tree", h.TaskTree).Methods("GET")
+	r.HandleFunc("/task/{id:"+service.UUIDPattern+"}", h.GetTask).Methods("GET")
 	// Match remaining as worker_name
 	r.HandleFunc("/task/{worker_name}", h.CreateTask).Methods("POST")
+	r.HandleFunc("/tasks", h.ListTasks).Methods("GET")
+
+	// Operators
+	r.HandleFunc("/workers", h.ListWorkers).Methods("GET")
+	r.HandleFunc("/workers/{name}", h.RegisterWorker).Methods("PUT")
+
+	// Pull mode (no broker)
+	r.HandleFunc("/workers/{name}/claim", requireScope(auth.ScopeComplete, h.ClaimTasks)).Methods("POST")
+	// Long-poll for workers that cannot consume the broker
+	r.HandleFunc("/workers/{name}/next", requireScope(auth.ScopeComplete, h.NextTask)).Methods("GET")
+	// Gateway for workers that keep one WebSocket connection open
+	r.HandleFunc("/workers/{name}/ws", requireScope(auth.ScopeComplete, h.WorkerSocket)).Methods("GET")
 }
 
 func (h *Handler) Healthz(w http.ResponseWriter, r *http.Request) {
@@ -53,71 +95,78 @@ func (h *Handler) Healthz(w http.ResponseWriter, r *http.Request) {
 
 func (h *Handler) Readyz(w http.ResponseWriter, r *http.Request) {
 	if err := h.store.Ping(); err != nil {
-		http.Error(w, "Database not ready", http.StatusServiceUnavailable)
+		writeError(w, http.StatusServiceUnavailable, service.CodeDatabaseUnavailable, "Database not ready")
 		return
 	}
 	if h.queue.IsClosed() {
-		http.Error(w, "RabbitMQ not ready", http.StatusServiceUnavailable)
+		writeError(w, http.StatusServiceUnavailable, service.CodeQueueUnavailable, "Queue not ready")
 		return
 	}
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte("ok"))
 }
 
+// IdempotencyKeyHeader makes retried creates return the task of the first
+// attempt, see service.NewTask.
+const IdempotencyKeyHeader = "Idempotency-Key"
+
 // CreateTaskRequest
 type CreateTaskRequest struct {
 	ParentID *string         `json:"parent_id,omitempty"`
 	Payload  json.RawMessage `json:"payload"`
+	// CallbackURL receives a webhook for each of CallbackEvents, by default
+	// completed and failed.
+	CallbackURL    *string  `json:"callback_url,omitempty"`
+	CallbackEvents []string `json:"callback_events,omitempty"`
 }
 
 func (h *Handler) CreateTask(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	workerName := vars["worker_name"]
-	if workerName == "" {
-		http.Error(w, "Worker name is required", http.StatusBadRequest)
-		return
-	}
+	namespace := namespaceOf(r)
 
-	exists, err := h.store.ValidateWorker(workerName)
-	if err != nil {
-		log.Printf("Error validating worker: %v", err)
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-		return
-	}
-	if !exists {
-		http.Error(w, "Worker does not exist", http.StatusBadRequest)
+	// With ?wait the request is answered once the task is finished
+	wait, ok := parseWait(w, r)
+	if !ok {
 		return
 	}
 
 	var req CreateTaskRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid body", http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, service.CodeInvalidBody, "Invalid body")
 		return
 	}
 
-	task := &storage.Task{
-		ParentID: req.ParentID,
-		Worker:   workerName,
-		Payload:  req.Payload,
+	// Subscribe before the task exists, a worker may finish it right away
+	var sub *events.Subscription
+	if wait > 0 {
+		var e *service.Error
+		if sub, e = h.svc.Subscribe(namespace); e != nil {
+			writeAPIError(w, e)
+			return
+		}
+		defer h.svc.Unsubscribe(sub)
 	}
 
-	id, err := h.store.CreateTask(task)
-	if err != nil {
-		log.Printf("Error creating task: %v", err)
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	id, e := h.svc.CreateTask(tracing.FromRequest(r.Context(), r), namespace, &service.NewTask{
+		Worker:         workerName,
+		ParentID:       req.ParentID,
+		Payload:        req.Payload,
+		CallbackURL:    req.CallbackURL,
+		CallbackEvents: req.CallbackEvents,
+		Client:         clientKey(r),
+		IdempotencyKey: r.Header.Get(IdempotencyKeyHeader),
+	})
+	if e != nil {
+		writeAPIError(w, e)
 		return
 	}
 
-	if err := h.queue.PublishTask(workerName, id, req.Payload); err != nil {
-		log.Printf("Error publishing to queue: %v", err)
-		// Note: We might want to rollback DB here, but for now keep it simple.
-		// Retrying or eventual consistency handled by separate process usually.
-		http.Error(w, "Task created but failed to queue", http.StatusInternalServerError)
+	if wait > 0 {
+		h.writeAwaited(w, r, sub, namespace, id, wait)
 		return
 	}
-
-	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(map[string]string{"id": id})
+	writeJSON(w, http.StatusCreated, map[string]string{"id": id})
 }
 
 // CompleteTaskRequest
@@ -131,112 +180,330 @@ func (h *Handler) CompleteTask(w http.ResponseWriter, r *http.Request) {
 
 	var req CompleteTaskRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid body", http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, service.CodeInvalidBody, "Invalid body")
 		return
 	}
 
-	// 1. Mark task as completed
-	err := h.store.CompleteTask(id, req.Result)
-	if err != nil {
-		if err == storage.ErrTaskAlreadyCompleted {
-			http.Error(w, "Task already completed", http.StatusConflict) // User requested error on duplicate
-			return
-		}
-		log.Printf("Error completing task: %v", err)
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	if e := h.svc.CompleteTask(tracing.FromRequest(r.Context(), r), namespaceOf(r), id, req.Result); e != nil {
+		writeAPIError(w, e)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// FailTaskRequest
+type FailTaskRequest struct {
+	Error string `json:"error"`
+}
+
+// FailTask marks a task as failed. The parent is re-queued the same way as
+// on completion, with the error in place of the child's result.
+func (h *Handler) FailTask(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	var req FailTaskRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, service.CodeInvalidBody, "Invalid body")
 		return
 	}
 
-	// 2. Check parent logic
-	t, err := h.store.GetTask(id)
-	if err != nil {
-		log.Printf("Error fetching task: %v", err)
-		return // Task completed, just couldn't fetch to check parent
+	if e := h.svc.FailTask(tracing.FromRequest(r.Context(), r), namespaceOf(r), id, req.Error); e != nil {
+		writeAPIError(w, e)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// WaitForChildren hands back a task that created children instead of
+// finishing it. It is queued again with their results once they are done.
+func (h *Handler) WaitForChildren(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	if e := h.svc.WaitForChildren(tracing.FromRequest(r.Context(), r), namespaceOf(r), vars["id"]); e != nil {
+		writeAPIError(w, e)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// CancelTaskRequest
+type CancelTaskRequest struct {
+	// Reason is stored as the error of the cancelled tasks.
+	Reason string `json:"reason,omitempty"`
+}
+
+// CancelTask cancels a task together with its unfinished descendants and
+// lists the cancelled ids. The parent is re-queued as if the task failed.
+func (h *Handler) CancelTask(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	// Body is optional
+	var req CancelTaskRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		writeError(w, http.StatusBadRequest, service.CodeInvalidBody, "Invalid body")
+		return
+	}
+
+	ids, e := h.svc.CancelTask(tracing.FromRequest(r.Context(), r), namespaceOf(r), id, req.Reason)
+	if e != nil {
+		writeAPIError(w, e)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string][]string{"cancelled": ids})
+}
+
+// RetryTask creates a copy of a failed or cancelled task and returns its
+// id. Like creates it honours the Idempotency-Key header.
+func (h *Handler) RetryTask(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	newID, e := h.svc.RetryTask(tracing.FromRequest(r.Context(), r), namespaceOf(r), id, &service.NewTask{
+		Client:         clientKey(r),
+		IdempotencyKey: r.Header.Get(IdempotencyKeyHeader),
+	})
+	if e != nil {
+		writeAPIError(w, e)
+		return
 	}
+	writeJSON(w, http.StatusCreated, map[string]string{"id": newID})
+}
+
+// GetTask returns a task as stored, including its payload and result.
+func (h *Handler) GetTask(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	t, e := h.svc.GetTask(r.Context(), namespaceOf(r), id)
+	if e != nil {
+		writeAPIError(w, e)
+		return
+	}
+	writeJSON(w, http.StatusOK, t)
+}
 
-	if t.ParentID != nil {
-		parentID := *t.ParentID
+// TaskTree renders a task and its descendants as a diagram, in the
+// "format" query parameter, dot by default or mermaid. With
+// "durations=true" nodes show how long their task ran.
+func (h *Handler) TaskTree(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
 
-		// Check if any siblings are pending
-		count, err := h.store.GetIncompleteChildCount(parentID)
-		if err != nil {
-			log.Printf("Error checking siblings: %v", err)
+	query := r.URL.Query()
+	format := query.Get("format")
+	if format == "" {
+		format = diagram.FormatDOT
+	}
+	contentType, ok := diagram.ContentTypes[format]
+	if !ok {
+		writeError(w, http.StatusBadRequest, service.CodeInvalidFormat, "format must be dot or mermaid")
+		return
+	}
+	opts := diagram.Options{Now: time.Now()}
+	if v := query.Get("durations"); v != "" {
+		var err error
+		if opts.Durations, err = strconv.ParseBool(v); err != nil {
+			writeError(w, http.StatusBadRequest, service.CodeInvalidDurations, "durations must be true or false")
 			return
 		}
+	}
+
+	tasks, e := h.svc.TaskTree(r.Context(), namespaceOf(r), id)
+	if e != nil {
+		writeAPIError(w, e)
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(http.StatusOK)
+	diagram.Render(w, format, tasks, opts)
+}
 
-		if count == 0 {
-			// All children done!
-			// 1. Fetch all results
-			results, err := h.store.GetChildrenResults(parentID)
-			if err != nil {
-				log.Printf("Error getting child results: %v", err)
-				return
-			}
-
-			// 2. Fetch Parent to get its queue/worker (Wait, user said: "радетял отправляем в очередь задач")
-			// "радетял" -> "родителя" (parent).
-			// We need to fetch the parent task to know where to send it?
-			// User said: "родителя отправляем в очередь задач, а результаты прикрепляем как subtasks массив json"
-			// Wait, if the parent task was waiting, does it have a worker? Created parent logic usually implies it has a worker.
-			// Let's fetch parent.
-			parent, err := h.store.GetTask(parentID)
-			if err != nil {
-				log.Printf("Error fetching parent: %v", err)
-				return
-			}
-
-			// Construct payload with subtasks results
-			// We might want to MERGE with original payload or just valid results?
-			// User said: "результаты прикрепляем как subtasks массив json"
-			// I'll create a new map for the message body.
-
-			// Warning: We are modifying the payload sent to the worker, NOT the DB payload probably?
-			// Or are we supposed to update the parent execution?
-			// Usually "re-queueing parent" means it is now ready to process.
-
-			// subtasksJson, _ := json.Marshal(results) // Unused
-
-			// We send `{"id": parentID, "subtasks": [...]}` to the worker?
-			// My `PublishTask` wrapper wraps in `{"id":..., "payload":...}`.
-			// So I should probably pass a Combined Payload.
-
-			combinedPayload := map[string]interface{}{}
-			if len(parent.Payload) > 0 {
-				json.Unmarshal(parent.Payload, &combinedPayload)
-			}
-			var resultObj []interface{}
-			for _, child := range results {
-				var rAny interface{}
-				if err := json.Unmarshal(child.Result, &rAny); err != nil {
-					log.Printf("Failed to unmarshal result for task %s: %v", child.ID, err)
-					continue
-				}
-
-				if rMap, ok := rAny.(map[string]interface{}); ok {
-					rMap["id"] = child.ID
-					rMap["worker"] = child.Worker
-					if _, exists := rMap["subtasks"]; !exists {
-						rMap["subtasks"] = []interface{}{}
-					}
-					resultObj = append(resultObj, rMap)
-				} else {
-					resultObj = append(resultObj, map[string]interface{}{
-						"result":   rAny,
-						"id":       child.ID,
-						"worker":   child.Worker,
-						"subtasks": []interface{}{},
-					})
-				}
-			}
-			combinedPayload["subtasks"] = resultObj
-
-			finalPayload, _ := json.Marshal(combinedPayload)
-
-			if err := h.queue.PublishTask(parent.Worker, parent.ID, finalPayload); err != nil {
-				log.Printf("Error publishing parent task: %v", err)
-			}
+// ListTasksResponse is a page of tasks. NextPageToken is empty on the last
+// page.
+type ListTasksResponse struct {
+	Tasks         []*storage.Task `json:"tasks"`
+	NextPageToken string          `json:"next_page_token,omitempty"`
+}
+
+// ListTasks lists the tasks of the namespace oldest first, or newest first
+// with "order=newest", filtered by the "worker", "status", "parent_id" and
+// "root_id" query parameters, a page of "page_size" at a time.
+func (h *Handler) ListTasks(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	q := &service.TaskQuery{
+		Worker:    query.Get("worker"),
+		Status:    query.Get("status"),
+		ParentID:  query.Get("parent_id"),
+		RootID:    query.Get("root_id"),
+		Order:     query.Get("order"),
+		PageToken: query.Get("page_token"),
+	}
+	if v := query.Get("page_size"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			writeError(w, http.StatusBadRequest, service.CodeInvalidLimit, "page_size must be between 1 and 100")
+			return
 		}
+		q.PageSize = n
+	}
+
+	tasks, next, e := h.svc.ListTasks(r.Context(), namespaceOf(r), q)
+	if e != nil {
+		writeAPIError(w, e)
+		return
+	}
+	if tasks == nil {
+		tasks = []*storage.Task{}
+	}
+	writeJSON(w, http.StatusOK, ListTasksResponse{Tasks: tasks, NextPageToken: next})
+}
+
+// ReportProgressRequest
+type ReportProgressRequest struct {
+	Progress json.RawMessage `json:"progress"`
+}
+
+// ReportProgress records how far the worker got with a task. Followers of
+// the task receive it as a progress event.
+func (h *Handler) ReportProgress(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	var req ReportProgressRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || len(req.Progress) == 0 {
+		writeError(w, http.StatusBadRequest, service.CodeInvalidBody, "Invalid body")
+		return
+	}
+	if e := h.svc.ReportProgress(r.Context(), namespaceOf(r), id, req.Progress); e != nil {
+		writeAPIError(w, e)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// ClaimTasksRequest
+type ClaimTasksRequest struct {
+	Limit int `json:"limit,omitempty"`
+}
+
+const maxClaimLimit = 100
+
+func (h *Handler) ClaimTasks(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	workerName := vars["name"]
+	logging.Add(r.Context(), "worker", workerName)
+	if !requireWorker(w, r, workerName) {
+		return
+	}
+
+	claimer, ok := h.queue.(queue.Claimer)
+	if !ok {
+		writeError(w, http.StatusConflict, service.CodeClaimUnavailable, "Claiming is only available when no broker is configured")
+		return
+	}
+
+	namespace := namespaceOf(r)
+	if e := h.svc.CheckWorkerExists(r.Context(), namespace, workerName); e != nil {
+		writeAPIError(w, e)
+		return
+	}
+
+	// Body is optional, an empty one claims a single task
+	req := ClaimTasksRequest{Limit: 1}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		writeError(w, http.StatusBadRequest, service.CodeInvalidBody, "Invalid body")
+		return
+	}
+	if req.Limit <= 0 || req.Limit > maxClaimLimit {
+		writeError(w, http.StatusBadRequest, service.CodeInvalidLimit, "limit must be between 1 and 100")
+		return
+	}
+
+	msgs, err := claimer.Claim(queue.Name(namespace, workerName), req.Limit)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error claiming tasks", "error", err)
+		writeInternalError(w)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"tasks": msgs})
+}
+
+// singleAttempt bounds a NextTask call without "wait": long enough for one
+// round trip to the broker, short enough not to count as waiting.
+const singleAttempt = 100 * time.Millisecond
+
+// NextTask hands the next queued task of a worker to an HTTP client, waiting
+// up to the "wait" query parameter for one to arrive. The task is leased
+// and queued again if it is not completed before the lease expires.
+func (h *Handler) NextTask(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	workerName := vars["name"]
+	logging.Add(r.Context(), "worker", workerName)
+	if !requireWorker(w, r, workerName) {
+		return
+	}
+
+	if _, ok := h.queue.(queue.Receiver); !ok {
+		writeError(w, http.StatusConflict, service.CodePullUnavailable, "Pulling is not supported by the configured broker")
+		return
+	}
+
+	wait, ok := parseWait(w, r)
+	if !ok {
+		return
+	}
+
+	namespace := namespaceOf(r)
+	if e := h.svc.CheckWorkerExists(r.Context(), namespace, workerName); e != nil {
+		writeAPIError(w, e)
+		return
+	}
+
+	// Without a wait the queue is still asked once, which needs a context
+	// that has not expired yet
+	if wait == 0 {
+		wait = singleAttempt
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), wait)
+	defer cancel()
+
+	msg, e := h.svc.NextTask(ctx, namespace, workerName)
+	if e != nil {
+		writeAPIError(w, e)
+		return
 	}
+	if msg == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	writeJSON(w, http.StatusOK, msg)
+}
 
+// ListWorkersResponse lists the workers of the namespace by name.
+type ListWorkersResponse struct {
+	Workers []service.Worker `json:"workers"`
+}
+
+// ListWorkers lists the registered workers of the namespace with the number
+// of their incomplete tasks. Only admins may list them.
+func (h *Handler) ListWorkers(w http.ResponseWriter, r *http.Request) {
+	workers, e := h.svc.ListWorkers(r.Context(), namespaceOf(r))
+	if e != nil {
+		writeAPIError(w, e)
+		return
+	}
+	writeJSON(w, http.StatusOK, ListWorkersResponse{Workers: workers})
+}
+
+// RegisterWorker registers a worker, so that tasks can be created for it.
+// Registering it again changes nothing. Only admins may register workers.
+func (h *Handler) RegisterWorker(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	if e := h.svc.RegisterWorker(r.Context(), namespaceOf(r), vars["name"]); e != nil {
+		writeAPIError(w, e)
+		return
+	}
 	w.WriteHeader(http.StatusOK)
 }
@@ -0,0 +1,86 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"task-api/internal/logging"
+	"task-api/internal/service"
+	"task-api/internal/storage"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// keepaliveInterval is how often an idle event stream sends a comment, so
+// that proxies do not close it.
+const keepaliveInterval = 15 * time.Second
+
+// TaskEvents streams the events of a task as Server-Sent Events, and with
+// ?subtree=true those of all its descendants. The stream ends once the
+// task, or the whole subtree, is finished. It also ends if events may have
+// been lost; clients then reconnect, as EventSource does by itself.
+func (h *Handler) TaskEvents(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+	logging.Add(r.Context(), "task_id", id)
+
+	subtree := false
+	if v := r.URL.Query().Get("subtree"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, service.CodeInvalidSubtree, "subtree must be true or false")
+			return
+		}
+		subtree = b
+	}
+
+	evs, e := h.svc.WatchTask(r.Context(), namespaceOf(r), id, subtree)
+	if e != nil {
+		writeAPIError(w, e)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	rc := http.NewResponseController(w)
+	fmt.Fprint(w, "retry: 3000\n\n")
+	rc.Flush()
+
+	keepalive := time.NewTicker(keepaliveInterval)
+	defer keepalive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepalive.C:
+			fmt.Fprint(w, ": keepalive\n\n")
+			if err := rc.Flush(); err != nil {
+				return
+			}
+		case ev, ok := <-evs:
+			if !ok {
+				return
+			}
+			if err := writeEvent(w, rc, ev); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// writeEvent sends one event in SSE framing and flushes it.
+func writeEvent(w http.ResponseWriter, rc *http.ResponseController, ev *storage.TaskEvent) error {
+	data, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", ev.Type, data); err != nil {
+		return err
+	}
+	return rc.Flush()
+}
@@ -0,0 +1,32 @@
+package api
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+	"strings"
+)
+
+// uiFiles is the web dashboard: static files that call the JSON API from
+// the browser like any other client, with the key entered into the page.
+//
+//go:embed ui
+var uiFiles embed.FS
+
+// UIPath is where the dashboard is served.
+const UIPath = "/ui/"
+
+// uiHandler serves the files of the dashboard below UIPath.
+func uiHandler() http.Handler {
+	files, err := fs.Sub(uiFiles, "ui")
+	if err != nil {
+		panic(err)
+	}
+	return http.StripPrefix(UIPath, http.FileServerFS(files))
+}
+
+// isUIPath reports whether path belongs to the dashboard. Its files are
+// public, the data it shows is fetched with the key entered into it.
+func isUIPath(path string) bool {
+	return path+"/" == UIPath || strings.HasPrefix(path, UIPath)
+}
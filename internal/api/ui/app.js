@@ -0,0 +1,266 @@
+// Dashboard of the Task API. Everything it shows comes from the JSON API,
+// called with the key and namespace entered in the header; both are kept
+// in localStorage.
+"use strict";
+
+const $ = (id) => document.getElementById(id);
+
+const settings = {
+  key: localStorage.getItem("taskApiKey") || "",
+  namespace: localStorage.getItem("taskApiNamespace") || "",
+  worker: localStorage.getItem("taskApiWorker") || "",
+};
+
+// treeRoot is the root task shown in the tree view, selected the task
+// whose details are shown.
+let treeRoot = null;
+let selected = null;
+
+// api calls the JSON API and returns the decoded answer. Error responses
+// are thrown with their code.
+async function api(method, path, headers = {}) {
+  if (settings.key) headers["Authorization"] = "Bearer " + settings.key;
+  if (settings.namespace) headers["X-Namespace"] = settings.namespace;
+  const resp = await fetch(path, { method, headers });
+  if (!resp.ok) {
+    let message = resp.status + " " + resp.statusText;
+    try {
+      const body = await resp.json();
+      message = body.error.code + ": " + body.error.message;
+    } catch (e) {
+      // Not an API error body
+    }
+    throw new Error(message);
+  }
+  const text = await resp.text();
+  return text ? JSON.parse(text) : null;
+}
+
+// el creates an element with the given attributes and children. Text is
+// always set as text, never parsed as HTML.
+function el(tag, attrs = {}, ...children) {
+  const node = document.createElement(tag);
+  for (const [k, v] of Object.entries(attrs)) {
+    if (k.startsWith("on")) {
+      node.addEventListener(k.slice(2), v);
+    } else {
+      node.setAttribute(k, v);
+    }
+  }
+  for (const child of children) {
+    if (child !== null && child !== undefined) {
+      node.append(child instanceof Node ? child : String(child));
+    }
+  }
+  return node;
+}
+
+function statusBadge(status) {
+  return el("span", { class: "status " + status }, status);
+}
+
+function shortID(id) {
+  return el("code", { title: id }, id.slice(0, 8));
+}
+
+function time(at) {
+  return at ? new Date(at).toLocaleString() : "";
+}
+
+// duration is how long a task ran, or has been running if unfinished.
+function duration(task) {
+  const end = task.completed_at ? new Date(task.completed_at) : new Date();
+  let s = Math.max(0, Math.round((end - new Date(task.created_at)) / 1000));
+  if (s < 60) return s + "s";
+  if (s < 3600) return Math.floor(s / 60) + "m " + (s % 60) + "s";
+  return Math.floor(s / 3600) + "h " + Math.floor((s % 3600) / 60) + "m";
+}
+
+function showError(err) {
+  $("error").textContent = err ? err.message : "";
+  $("error").hidden = !err;
+}
+
+function tasksQuery(params) {
+  const q = new URLSearchParams(params);
+  if (settings.worker) q.set("worker", settings.worker);
+  return "/tasks?" + q;
+}
+
+async function loadWorkers() {
+  const body = $("workers");
+  try {
+    const { workers } = await api("GET", "/workers");
+    $("workers-note").hidden = true;
+    const max = Math.max(1, ...workers.map((w) => w.pending));
+    body.replaceChildren(...workers.map((w) =>
+      el("tr", {},
+        el("td", {}, w.name),
+        el("td", {}, w.pending),
+        el("td", { style: "width: 50%" }, el("div", { class: "bar", style: "width: " + (100 * w.pending / max) + "%" })))));
+  } catch (err) {
+    // Only admins may list workers
+    $("workers-note").textContent = err.message;
+    $("workers-note").hidden = false;
+    body.replaceChildren();
+  }
+}
+
+async function loadRecent() {
+  const { tasks } = await api("GET", tasksQuery({ order: "newest", page_size: 20 }));
+  $("recent").replaceChildren(...tasks.map((t) =>
+    el("tr", { "data-id": t.id, onclick: () => showTree(t.id) },
+      el("td", {}, time(t.created_at)),
+      el("td", {}, shortID(t.id)),
+      el("td", {}, t.worker),
+      el("td", {}, statusBadge(t.status)),
+      el("td", {}, duration(t)))));
+}
+
+async function loadFailures() {
+  const { tasks } = await api("GET", tasksQuery({ status: "failed", order: "newest", page_size: 20 }));
+  $("failures").replaceChildren(...tasks.map((t) =>
+    el("tr", { "data-id": t.id, onclick: () => showTree(t.id) },
+      el("td", {}, time(t.completed_at)),
+      el("td", {}, shortID(t.id)),
+      el("td", {}, t.worker),
+      el("td", { class: "error-text", title: t.error || "" }, t.error || ""),
+      el("td", {}, t.parent_id ? null : el("button", { onclick: (e) => { e.stopPropagation(); retry(t.id); } }, "Retry")))));
+}
+
+// retry retries a failed root task. The key is the one taskctl redrive
+// uses, so a task is retried at most once from either.
+async function retry(id) {
+  try {
+    const { id: newID } = await api("POST", "/task/" + id + "/retry", { "Idempotency-Key": "redrive-" + id });
+    await refresh();
+    await showTree(newID);
+  } catch (err) {
+    showError(err);
+  }
+}
+
+// showTree shows the whole tree of a task, from its root, and the details
+// of the task.
+async function showTree(id) {
+  try {
+    const task = await api("GET", "/task/" + id);
+    const root = task.root_id ? await api("GET", "/task/" + task.root_id) : task;
+    const tasks = [];
+    let token = "";
+    do {
+      const q = new URLSearchParams({ root_id: root.id, page_size: 100 });
+      if (token) q.set("page_token", token);
+      const page = await api("GET", "/tasks?" + q);
+      tasks.push(...page.tasks);
+      token = page.next_page_token;
+    } while (token);
+
+    treeRoot = root;
+    selected = task.id;
+    $("tree-id").value = task.id;
+    history.replaceState(null, "", "#" + task.id);
+    renderTree(root, tasks);
+    renderDetails(task);
+    showError(null);
+  } catch (err) {
+    showError(err);
+  }
+}
+
+function renderTree(root, tasks) {
+  // Descendants come oldest first, so parents before children
+  const nodes = new Map([[root.id, { task: root, children: [] }]]);
+  for (const t of tasks) {
+    const node = { task: t, children: [] };
+    nodes.set(t.id, node);
+    const parent = nodes.get(t.parent_id);
+    if (parent) parent.children.push(node);
+  }
+  $("tree").replaceChildren(el("ul", {}, renderNode(nodes.get(root.id))));
+}
+
+function renderNode(node) {
+  const t = node.task;
+  const label = el("span", {
+    class: "node" + (t.id === selected ? " selected" : ""),
+    onclick: (e) => {
+      // Select without folding the subtree
+      e.preventDefault();
+      selected = t.id;
+      document.querySelectorAll("#tree .node.selected").forEach((n) => n.classList.remove("selected"));
+      label.classList.add("selected");
+      history.replaceState(null, "", "#" + t.id);
+      renderDetails(t);
+    },
+  }, statusBadge(t.status), t.worker, shortID(t.id), el("span", { class: "note" }, duration(t)));
+  if (node.children.length === 0) {
+    return el("li", {}, label);
+  }
+  return el("li", {},
+    el("details", { open: "" },
+      el("summary", {}, label),
+      el("ul", {}, ...node.children.map(renderNode))));
+}
+
+function renderDetails(t) {
+  const json = (v) => el("pre", {}, JSON.stringify(v, null, 2));
+  const field = (name, value) => (value ? [el("dt", {}, name), el("dd", {}, value)] : []);
+  $("details").replaceChildren(
+    el("dl", {},
+      ...field("Task", el("code", {}, t.id)),
+      ...field("Worker", t.worker),
+      ...field("Status", statusBadge(t.status)),
+      ...field("Parent", t.parent_id && el("code", {}, t.parent_id)),
+      ...field("Created", time(t.created_at)),
+      ...field("Finished", time(t.completed_at)),
+      ...field("Duration", duration(t)),
+      ...field("Error", t.error)),
+    el("h3", {}, "Payload"), json(t.payload),
+    t.result !== undefined ? el("h3", {}, "Result") : null,
+    t.result !== undefined ? json(t.result) : null,
+    t.progress !== undefined ? el("h3", {}, "Progress") : null,
+    t.progress !== undefined ? json(t.progress) : null);
+}
+
+async function refresh() {
+  try {
+    await Promise.all([loadWorkers(), loadRecent(), loadFailures()]);
+    showError(null);
+  } catch (err) {
+    showError(err);
+  }
+  if (treeRoot) {
+    await showTree(selected || treeRoot.id);
+  }
+}
+
+$("key").value = settings.key;
+$("namespace").value = settings.namespace;
+$("worker").value = settings.worker;
+
+$("settings").addEventListener("submit", (e) => {
+  e.preventDefault();
+  settings.key = $("key").value.trim();
+  settings.namespace = $("namespace").value.trim();
+  settings.worker = $("worker").value.trim();
+  localStorage.setItem("taskApiKey", settings.key);
+  localStorage.setItem("taskApiNamespace", settings.namespace);
+  localStorage.setItem("taskApiWorker", settings.worker);
+  refresh();
+});
+
+$("tree-form").addEventListener("submit", (e) => {
+  e.preventDefault();
+  const id = $("tree-id").value.trim();
+  if (id) showTree(id);
+});
+
+setInterval(() => {
+  if ($("auto").checked && !document.hidden) refresh();
+}, 5000);
+
+refresh();
+if (location.hash.length > 1) {
+  showTree(location.hash.slice(1));
+}
@@ -0,0 +1,413 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"task-api/internal/logging"
+	"task-api/internal/metrics"
+	"task-api/internal/queue"
+	"task-api/internal/service"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+)
+
+// Worker gateway message types.
+const (
+	// Sent by workers
+	MessageHeartbeat = "heartbeat"
+	MessageCreate    = "create"
+	MessageComplete  = "complete"
+	MessageFail      = "fail"
+	MessageProgress  = "progress"
+	MessageWait      = "wait"
+	// Sent by the server
+	MessageTask  = "task"
+	MessageAck   = "ack"
+	MessageError = "error"
+)
+
+const (
+	// socketIdleTimeout closes a connection that sent neither a message nor
+	// a pong for that long.
+	socketIdleTimeout = 60 * time.Second
+	// socketPingInterval is how often the server pings, well within
+	// socketIdleTimeout.
+	socketPingInterval = 25 * time.Second
+	// socketWriteTimeout bounds a single write.
+	socketWriteTimeout = 10 * time.Second
+	// maxSocketMessage bounds a single message from a worker.
+	maxSocketMessage = 4 << 20
+)
+
+var upgrader = websocket.Upgrader{
+	// Credentials come with the handshake, never from cookies, so a page
+	// of another origin gains nothing it could not do with fetch.
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+// WorkerRequest is a message sent by a worker over the gateway. Which
+// fields are used depends on the type.
+type WorkerRequest struct {
+	Type string `json:"type"`
+	// Ref is chosen by the worker and echoed in the ack or error that
+	// answers the message.
+	Ref string `json:"ref,omitempty"`
+	// ID is the task to complete, fail, report progress for or wait with.
+	ID string `json:"id,omitempty"`
+	// Worker, ParentID, Payload, CallbackURL and CallbackEvents describe
+	// a task to create, as in CreateTaskRequest.
+	Worker         string          `json:"worker,omitempty"`
+	ParentID       *string         `json:"parent_id,omitempty"`
+	Payload        json.RawMessage `json:"payload,omitempty"`
+	CallbackURL    *string         `json:"callback_url,omitempty"`
+	CallbackEvents []string        `json:"callback_events,omitempty"`
+	Result         json.RawMessage `json:"result,omitempty"`
+	Error          string          `json:"error,omitempty"`
+	Progress       json.RawMessage `json:"progress,omitempty"`
+}
+
+// WorkerReply is a message sent to a worker over the gateway: a task to
+// work on, or the answer to one of its messages.
+type WorkerReply struct {
+	Type    string          `json:"type"`
+	Ref     string          `json:"ref,omitempty"`
+	ID      string          `json:"id,omitempty"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+	Error   *ErrorBody      `json:"error,omitempty"`
+	// RetryAfter is in seconds, for rate limited messages.
+	RetryAfter int `json:"retry_after,omitempty"`
+}
+
+// WorkerSocket connects a worker over WebSocket. Over the one connection it
+// is sent the tasks of its queue, up to "prefetch" unfinished at a time,
+// and it sends heartbeats, creates tasks, reports results and hands back
+// tasks that wait for their subtasks. Tasks are
+// leased like those handed out by NextTask; heartbeats renew the leases,
+// and they are queued again soon after the connection is lost.
+func (h *Handler) WorkerSocket(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	workerName := vars["name"]
+	logging.Add(r.Context(), "worker", workerName)
+	if !requireWorker(w, r, workerName) {
+		return
+	}
+
+	if _, ok := h.queue.(queue.Receiver); !ok {
+		writeError(w, http.StatusConflict, service.CodePullUnavailable, "Pulling is not supported by the configured broker")
+		return
+	}
+
+	prefetch := 1
+	if v := r.URL.Query().Get("prefetch"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > maxClaimLimit {
+			writeError(w, http.StatusBadRequest, service.CodeInvalidPrefetch, "prefetch must be between 1 and 100")
+			return
+		}
+		prefetch = n
+	}
+
+	namespace := namespaceOf(r)
+	if e := h.svc.CheckWorkerExists(r.Context(), namespace, workerName); e != nil {
+		writeAPIError(w, e)
+		return
+	}
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// The upgrader has answered already
+		slog.InfoContext(r.Context(), "WebSocket upgrade failed", "error", err)
+		return
+	}
+
+	ctx, cancel := context.WithCancel(r.Context())
+	s := &workerSocket{
+		h:         h,
+		conn:      conn,
+		ctx:       ctx,
+		namespace: namespace,
+		worker:    workerName,
+		client:    clientKey(r),
+		slots:     make(chan struct{}, prefetch),
+		held:      map[string]bool{},
+	}
+	metrics.WorkerSockets.Inc()
+	defer metrics.WorkerSockets.Dec()
+	slog.InfoContext(ctx, "Worker connected", "prefetch", prefetch)
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		s.deliver()
+	}()
+	go func() {
+		defer wg.Done()
+		s.ping()
+	}()
+
+	err = s.read()
+	cancel()
+	conn.Close()
+	wg.Wait()
+	s.releaseHeld()
+	slog.InfoContext(r.Context(), "Worker disconnected", "reason", err)
+}
+
+// CloseWorkerSockets asks every worker connected over WebSocket to go
+// away. The server does not track hijacked connections, so it is meant to
+// be registered with http.Server.RegisterOnShutdown.
+func (h *Handler) CloseWorkerSockets() {
+	h.closeSockets.Do(func() { close(h.socketsClosing) })
+}
+
+// workerSocket is the state of one gateway connection.
+type workerSocket struct {
+	h         *Handler
+	conn      *websocket.Conn
+	ctx       context.Context
+	namespace string
+	worker    string
+	client    string
+
+	// slots holds a token for every task handed out and not finished yet
+	slots chan struct{}
+
+	writeMu sync.Mutex
+
+	mu   sync.Mutex
+	held map[string]bool // leased tasks handed out on this connection
+}
+
+// read handles the messages of the worker until the connection fails.
+func (s *workerSocket) read() error {
+	s.conn.SetReadLimit(maxSocketMessage)
+	s.conn.SetReadDeadline(time.Now().Add(socketIdleTimeout))
+	s.conn.SetPongHandler(func(string) error {
+		return s.conn.SetReadDeadline(time.Now().Add(socketIdleTimeout))
+	})
+
+	for {
+		_, data, err := s.conn.ReadMessage()
+		if err != nil {
+			return err
+		}
+		s.conn.SetReadDeadline(time.Now().Add(socketIdleTimeout))
+
+		var req WorkerRequest
+		if err := json.Unmarshal(data, &req); err != nil {
+			s.send(&WorkerReply{Type: MessageError, Error: &ErrorBody{Code: service.CodeInvalidBody, Message: "Invalid message"}})
+			continue
+		}
+		reply := s.handle(&req)
+		reply.Ref = req.Ref
+		if err := s.send(reply); err != nil {
+			return err
+		}
+	}
+}
+
+// handle runs one message of the worker and returns the answer.
+func (s *workerSocket) handle(req *WorkerRequest) *WorkerReply {
+	var id string
+	var e *service.Error
+	switch req.Type {
+	case MessageHeartbeat:
+		e = s.heartbeat()
+	case MessageCreate:
+		id, e = s.create(req)
+	case MessageComplete, MessageFail:
+		id = req.ID
+		if req.Type == MessageComplete {
+			e = s.h.svc.CompleteTask(s.ctx, s.namespace, id, req.Result)
+		} else {
+			e = s.h.svc.FailTask(s.ctx, s.namespace, id, req.Error)
+		}
+		if e == nil || e.Status == http.StatusNotFound || e.Status == http.StatusConflict {
+			// Done with it, one way or another
+			s.release(id)
+		}
+	case MessageProgress:
+		id = req.ID
+		e = s.h.svc.ReportProgress(s.ctx, s.namespace, id, req.Progress)
+	case MessageWait:
+		// The task comes back with its subtasks, as a new delivery
+		id = req.ID
+		e = s.h.svc.WaitForChildren(s.ctx, s.namespace, id)
+		if e == nil || e.Status == http.StatusNotFound || e.Code == service.CodeTaskAlreadyCompleted {
+			s.release(id)
+		}
+	default:
+		e = service.NewError(http.StatusBadRequest, service.CodeInvalidMessageType, "Unknown message type "+strconv.Quote(req.Type))
+	}
+
+	if e != nil {
+		reply := &WorkerReply{Type: MessageError, ID: id, Error: &ErrorBody{Code: e.Code, Message: e.Message}}
+		if e.RetryAfter > 0 {
+			reply.RetryAfter = int(math.Ceil(e.RetryAfter.Seconds()))
+		}
+		return reply
+	}
+	return &WorkerReply{Type: MessageAck, ID: id}
+}
+
+// create creates a task like CreateTask does.
+func (s *workerSocket) create(req *WorkerRequest) (string, *service.Error) {
+	return s.h.svc.CreateTask(s.ctx, s.namespace, &service.NewTask{
+		Worker:         req.Worker,
+		ParentID:       req.ParentID,
+		Payload:        req.Payload,
+		CallbackURL:    req.CallbackURL,
+		CallbackEvents: req.CallbackEvents,
+		Client:         s.client,
+	})
+}
+
+// heartbeat renews the leases of the tasks handed out on this connection.
+// Tasks that are no longer leased, because they were finished elsewhere or
+// the lease ran out, stop counting against the prefetch.
+func (s *workerSocket) heartbeat() *service.Error {
+	s.mu.Lock()
+	ids := make([]string, 0, len(s.held))
+	for id := range s.held {
+		ids = append(ids, id)
+	}
+	s.mu.Unlock()
+	if len(ids) == 0 {
+		return nil
+	}
+
+	held, err := s.h.svc.RenewLeases(ids)
+	if err != nil {
+		slog.ErrorContext(s.ctx, "Error renewing leases", "error", err)
+		return service.ErrInternal
+	}
+	still := make(map[string]bool, len(held))
+	for _, id := range held {
+		still[id] = true
+	}
+	for _, id := range ids {
+		if !still[id] {
+			s.release(id)
+		}
+	}
+	return nil
+}
+
+// deliver hands tasks from the queue to the worker while it has slots
+// free, until the connection ends.
+func (s *workerSocket) deliver() {
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case s.slots <- struct{}{}:
+		}
+
+		for {
+			// Bounded, so that brokers that poll notice the end of ctx
+			rctx, cancel := context.WithTimeout(s.ctx, maxWait)
+			msg, e := s.h.svc.NextTask(rctx, s.namespace, s.worker)
+			cancel()
+			if e != nil {
+				if !sleepCtx(s.ctx, time.Second) {
+					return
+				}
+				continue
+			}
+			if msg == nil {
+				if s.ctx.Err() != nil {
+					return
+				}
+				continue
+			}
+
+			// Held even if the connection just ended, so that the lease
+			// is released with the others
+			s.mu.Lock()
+			s.held[msg.ID] = true
+			s.mu.Unlock()
+			if s.ctx.Err() != nil {
+				return
+			}
+			// Should this fail the connection is gone; the lease is
+			// released once reading stops as well.
+			s.send(&WorkerReply{Type: MessageTask, ID: msg.ID, Payload: msg.Payload})
+			break
+		}
+	}
+}
+
+// release frees the slot of a task handed out on this connection.
+func (s *workerSocket) release(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.held[id] {
+		delete(s.held, id)
+		<-s.slots
+	}
+}
+
+// releaseHeld lets the leases of unfinished tasks run out, so that the
+// lease reaper queues them again without waiting for the full lease.
+func (s *workerSocket) releaseHeld() {
+	ids := make([]string, 0, len(s.held))
+	for id := range s.held {
+		ids = append(ids, id)
+	}
+	if len(ids) == 0 {
+		return
+	}
+	if err := s.h.svc.ReleaseLeases(ids); err != nil {
+		slog.ErrorContext(s.ctx, "Error releasing leases", "error", err)
+	}
+}
+
+// ping keeps the connection alive, and closes it when the server shuts
+// down.
+func (s *workerSocket) ping() {
+	ticker := time.NewTicker(socketPingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-s.h.socketsClosing:
+			msg := websocket.FormatCloseMessage(websocket.CloseGoingAway, "Server shutting down")
+			s.conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(socketWriteTimeout))
+			s.conn.Close()
+			return
+		case <-ticker.C:
+			if err := s.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(socketWriteTimeout)); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// send writes one message to the worker.
+func (s *workerSocket) send(reply *WorkerReply) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	s.conn.SetWriteDeadline(time.Now().Add(socketWriteTimeout))
+	return s.conn.WriteJSON(reply)
+}
+
+// sleepCtx waits for d, or reports false if ctx ends first.
+func sleepCtx(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-t.C:
+		return true
+	}
+}
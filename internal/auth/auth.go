@@ -0,0 +1,134 @@
+// Package auth issues API keys, verifies bearer tokens and describes who a
+// request acts for.
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"slices"
+	"strings"
+)
+
+// Scopes granted to a principal. Worker keys get ScopeComplete and a
+// create scope for their own worker and each delegate; tokens carry them
+// in their scope claim.
+const (
+	ScopeAdmin    = "admin"
+	ScopeComplete = "tasks:complete"
+	// ScopeCreatePrefix is followed by a worker name, or "*" for any.
+	ScopeCreatePrefix = "tasks:create:"
+)
+
+// CreateScope returns the scope needed to create tasks for a worker.
+func CreateScope(worker string) string {
+	return ScopeCreatePrefix + worker
+}
+
+// keyPrefix marks task-api keys, so leaked ones are easy to grep for.
+const keyPrefix = "tk_"
+
+// GenerateKey returns a new random API key.
+func GenerateKey() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return keyPrefix + hex.EncodeToString(b), nil
+}
+
+// HashKey returns the form a key is stored and looked up in. Keys are
+// random, so a plain SHA-256 is enough.
+func HashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// EqualKeys compares two keys in constant time.
+func EqualKeys(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
+
+// BearerToken returns the token of an "Authorization: Bearer" header value.
+func BearerToken(header string) (string, bool) {
+	scheme, token, ok := strings.Cut(header, " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	token = strings.TrimSpace(token)
+	return token, token != ""
+}
+
+// IsJWT reports whether a bearer token has the shape of a JWT rather than
+// an API key.
+func IsJWT(token string) bool {
+	return strings.Count(token, ".") == 2
+}
+
+// Principal is the caller a request is made for.
+type Principal struct {
+	// KeyID is the id of the key used, empty for tokens, the bootstrap
+	// admin key and anonymous access.
+	KeyID string
+	// Subject is the "sub" claim of a token.
+	Subject string
+	// Namespace is the only namespace the principal may use. Empty means
+	// any, chosen per request.
+	Namespace string
+	// Worker limits ScopeComplete to one worker's tasks. Empty means any
+	// worker.
+	Worker string
+	Admin  bool
+	Scopes []string
+}
+
+// WorkerPrincipal returns the principal of a worker key.
+func WorkerPrincipal(keyID, namespace, worker string, delegates []string) *Principal {
+	scopes := []string{ScopeComplete, CreateScope(worker)}
+	for _, d := range delegates {
+		scopes = append(scopes, CreateScope(d))
+	}
+	return &Principal{KeyID: keyID, Namespace: namespace, Worker: worker, Scopes: scopes}
+}
+
+// HasScope reports whether the principal was granted scope. Admins have
+// every scope.
+func (p *Principal) HasScope(scope string) bool {
+	return p.Admin || slices.Contains(p.Scopes, scope)
+}
+
+// CanActAs reports whether the principal may claim and finish tasks of
+// the given worker.
+func (p *Principal) CanActAs(worker string) bool {
+	if p.Admin {
+		return true
+	}
+	return p.HasScope(ScopeComplete) && (p.Worker == "" || p.Worker == worker)
+}
+
+// CanCreateFor reports whether the principal may create tasks for the
+// given worker.
+func (p *Principal) CanCreateFor(worker string) bool {
+	return p.HasScope(CreateScope(worker)) || p.HasScope(CreateScope("*"))
+}
+
+// CanWatch reports whether the principal may follow the tasks of the
+// given worker: whoever may finish them or create them.
+func (p *Principal) CanWatch(worker string) bool {
+	return p.CanActAs(worker) || p.CanCreateFor(worker)
+}
+
+type principalKey struct{}
+
+// NewContext returns ctx carrying p.
+func NewContext(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// FromContext returns the principal of the request, or nil outside one.
+func FromContext(ctx context.Context) *Principal {
+	p, _ := ctx.Value(principalKey{}).(*Principal)
+	return p
+}
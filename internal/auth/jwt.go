@@ -0,0 +1,273 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// jwksRefreshInterval limits how often a JWKS URL is fetched again when a
+// token names an unknown key.
+const jwksRefreshInterval = time.Minute
+
+// TokenOptions configure which tokens a TokenVerifier accepts and how it
+// reads them.
+type TokenOptions struct {
+	// Issuer and Audience are checked when not empty.
+	Issuer   string
+	Audience string
+	// WorkerClaim, if present in a token, limits it to that worker's tasks.
+	WorkerClaim string
+	// NamespaceClaim, if present in a token, limits it to that namespace.
+	NamespaceClaim string
+}
+
+// TokenVerifier checks JWTs against the keys of a JWKS and turns their
+// claims into a Principal.
+type TokenVerifier struct {
+	source string
+	opts   TokenOptions
+
+	mu          sync.RWMutex
+	keys        map[string]any
+	lastRefresh time.Time
+}
+
+// NewTokenVerifier loads the JWKS from a file path or an http(s) URL. URL
+// sources are fetched again when a token is signed with an unknown key.
+func NewTokenVerifier(source string, opts TokenOptions) (*TokenVerifier, error) {
+	v := &TokenVerifier{
+		source: source,
+		opts:   opts,
+	}
+	if err := v.refresh(); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+func (v *TokenVerifier) isURL() bool {
+	return strings.HasPrefix(v.source, "http://") || strings.HasPrefix(v.source, "https://")
+}
+
+func (v *TokenVerifier) refresh() error {
+	var data []byte
+	var err error
+	if v.isURL() {
+		data, err = fetchJWKS(v.source)
+	} else {
+		data, err = os.ReadFile(v.source)
+	}
+	if err != nil {
+		return fmt.Errorf("load JWKS: %w", err)
+	}
+	keys, err := parseJWKS(data)
+	if err != nil {
+		return fmt.Errorf("parse JWKS: %w", err)
+	}
+
+	v.mu.Lock()
+	v.keys = keys
+	v.lastRefresh = time.Now()
+	v.mu.Unlock()
+	return nil
+}
+
+func fetchJWKS(url string) ([]byte, error) {
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+}
+
+// key returns the verification key with the given id, fetching the JWKS
+// again if it is unknown and the source is a URL.
+func (v *TokenVerifier) key(kid string) (any, error) {
+	v.mu.RLock()
+	k, ok := v.keys[kid]
+	stale := time.Since(v.lastRefresh) > jwksRefreshInterval
+	v.mu.RUnlock()
+	if ok {
+		return k, nil
+	}
+
+	if v.isURL() && stale {
+		if err := v.refresh(); err != nil {
+			slog.Warn("Failed to refresh JWKS", "error", err)
+		} else {
+			v.mu.RLock()
+			k, ok = v.keys[kid]
+			v.mu.RUnlock()
+			if ok {
+				return k, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("unknown key id %q", kid)
+}
+
+// Verify checks the token signature and registered claims and returns the
+// principal it describes.
+func (v *TokenVerifier) Verify(token string) (*Principal, error) {
+	opts := []jwt.ParserOption{
+		jwt.WithValidMethods([]string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512"}),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(30 * time.Second),
+	}
+	if v.opts.Issuer != "" {
+		opts = append(opts, jwt.WithIssuer(v.opts.Issuer))
+	}
+	if v.opts.Audience != "" {
+		opts = append(opts, jwt.WithAudience(v.opts.Audience))
+	}
+
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(token, claims, func(t *jwt.Token) (any, error) {
+		kid, _ := t.Header["kid"].(string)
+		return v.key(kid)
+	}, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &Principal{Scopes: scopes(claims)}
+	p.Subject, _ = claims.GetSubject()
+	p.Admin = p.HasScope(ScopeAdmin)
+	if v.opts.WorkerClaim != "" {
+		p.Worker, _ = claims[v.opts.WorkerClaim].(string)
+	}
+	if v.opts.NamespaceClaim != "" {
+		p.Namespace, _ = claims[v.opts.NamespaceClaim].(string)
+	}
+	return p, nil
+}
+
+// scopes reads the space separated "scope" claim, or the "scp" list used
+// by some issuers.
+func scopes(claims jwt.MapClaims) []string {
+	if s, ok := claims["scope"].(string); ok {
+		return strings.Fields(s)
+	}
+	var list []string
+	switch scp := claims["scp"].(type) {
+	case string:
+		list = strings.Fields(scp)
+	case []any:
+		for _, s := range scp {
+			if s, ok := s.(string); ok {
+				list = append(list, s)
+			}
+		}
+	}
+	return list
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// parseJWKS returns the RSA and EC signing keys of a JWKS document by key
+// id. Other keys are skipped.
+func parseJWKS(data []byte) (map[string]any, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, err
+	}
+
+	keys := map[string]any{}
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		var key any
+		var err error
+		switch k.Kty {
+		case "RSA":
+			key, err = k.rsaKey()
+		case "EC":
+			key, err = k.ecKey()
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %w", k.Kid, err)
+		}
+		keys[k.Kid] = key
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("no usable signing keys")
+	}
+	return keys, nil
+}
+
+func (k jwk) rsaKey() (*rsa.PublicKey, error) {
+	n, err := base64.RawURLEncoding.DecodeString(k.N)
+	if err != nil {
+		return nil, fmt.Errorf("invalid n: %w", err)
+	}
+	e, err := base64.RawURLEncoding.DecodeString(k.E)
+	if err != nil {
+		return nil, fmt.Errorf("invalid e: %w", err)
+	}
+	exp := new(big.Int).SetBytes(e)
+	if !exp.IsInt64() || exp.Int64() < 3 || exp.Int64() > 1<<31-1 {
+		return nil, errors.New("invalid exponent")
+	}
+	return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exp.Int64())}, nil
+}
+
+func (k jwk) ecKey() (*ecdsa.PublicKey, error) {
+	var curve elliptic.Curve
+	switch k.Crv {
+	case "P-256":
+		curve = elliptic.P256()
+	case "P-384":
+		curve = elliptic.P384()
+	case "P-521":
+		curve = elliptic.P521()
+	default:
+		return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+	}
+	x, err := base64.RawURLEncoding.DecodeString(k.X)
+	if err != nil {
+		return nil, fmt.Errorf("invalid x: %w", err)
+	}
+	y, err := base64.RawURLEncoding.DecodeString(k.Y)
+	if err != nil {
+		return nil, fmt.Errorf("invalid y: %w", err)
+	}
+	size := (curve.Params().BitSize + 7) / 8
+	if len(x) != size || len(y) != size {
+		return nil, errors.New("invalid point size")
+	}
+	return ecdsa.ParseUncompressedPublicKey(curve, append(append([]byte{4}, x...), y...))
+}
@@ -2,15 +2,91 @@ package config
 
 import (
 	"fmt"
+	"net/netip"
 	"os"
+	"strconv"
+	"strings"
+	"task-api/internal/ratelimit"
+	"time"
 
 	"github.com/joho/godotenv"
 )
 
+const (
+	BrokerRabbitMQ = "rabbitmq"
+	BrokerNATS     = "nats"
+	BrokerRedis    = "redis"
+	// BrokerPostgres runs without a broker: workers claim tasks over HTTP.
+	BrokerPostgres = "postgres"
+)
+
 type Config struct {
 	PostgresURL string
+	Broker      string
 	RabbitMQURL string
-	Port        string
+	NATSURL     string
+	NATSSubject string
+	RedisURL    string
+	RedisPrefix string
+	RedisGroup  string
+	RedisMaxLen int64
+	ClaimLease  time.Duration
+	AutoMigrate bool
+	// ForbidCompletedParent rejects children for already completed parents.
+	ForbidCompletedParent bool
+	// AuthRequired rejects API requests without a key. It is on unless
+	// turned off explicitly. AdminKey is an admin key accepted without
+	// being stored in the database.
+	AuthRequired bool
+	AdminKey     string
+	// JWKS is a file path or URL with the keys JWTs are checked against.
+	// Tokens are not accepted when it is empty.
+	JWKS           string
+	JWTIssuer      string
+	JWTAudience    string
+	JWTWorkerClaim string
+	// JWTNamespaceClaim limits a token to the namespace it names.
+	JWTNamespaceClaim string
+	// MaxPending is the pending task quota of namespaces without their
+	// own, 0 for none.
+	MaxPending int
+	// RateLimitWorker limits task creation per target worker and
+	// RateLimitClient per API key, token subject or client address.
+	RateLimitWorker ratelimit.Rate
+	RateLimitClient ratelimit.Rate
+	// MaxPendingPerWorker caps the incomplete tasks of each worker, 0 for
+	// no cap.
+	MaxPendingPerWorker int
+	// MaxTreeDepth and MaxTreeSize bound the trees built through
+	// parent_id, 0 for no bound.
+	MaxTreeDepth int
+	MaxTreeSize  int
+	// WebhookSecret signs webhooks. Callback URLs are refused without it.
+	WebhookSecret string
+	// WebhookAllowNetworks may receive webhooks although they are
+	// loopback, link-local or private.
+	WebhookAllowNetworks []netip.Prefix
+	WebhookMaxAttempts   int
+	WebhookTimeout       time.Duration
+	// TraceExporter is "", "stdout" or "file"; TraceFile is used by "file".
+	TraceExporter string
+	TraceFile     string
+	LogLevel      string
+	Port          string
+	// GRPCPort serves the gRPC API; it is off when empty.
+	GRPCPort string
+}
+
+// LoadPostgresURL returns only the database URL, for commands that do not
+// need the rest of the configuration.
+func LoadPostgresURL() (string, error) {
+	_ = godotenv.Load()
+
+	pgURL := os.Getenv("POSTGRES_URL")
+	if pgURL == "" {
+		return "", fmt.Errorf("POSTGRES_URL environment variable is not set")
+	}
+	return pgURL, nil
 }
 
 func Load() (*Config, error) {
@@ -30,9 +106,170 @@ func Load() (*Config, error) {
 		return nil, fmt.Errorf("POSTGRES_URL environment variable is not set")
 	}
 
+	broker := os.Getenv("BROKER")
+	if broker == "" {
+		broker = BrokerRabbitMQ
+	}
+
 	rabbitURL := os.Getenv("RABBITMQ_URL")
-	if rabbitURL == "" {
-		return nil, fmt.Errorf("RABBITMQ_URL environment variable is not set")
+	natsURL := os.Getenv("NATS_URL")
+	redisURL := os.Getenv("REDIS_URL")
+	switch broker {
+	case BrokerRabbitMQ:
+		if rabbitURL == "" {
+			return nil, fmt.Errorf("RABBITMQ_URL environment variable is not set")
+		}
+	case BrokerNATS:
+		if natsURL == "" {
+			return nil, fmt.Errorf("NATS_URL environment variable is not set")
+		}
+	case BrokerRedis:
+		if redisURL == "" {
+			return nil, fmt.Errorf("REDIS_URL environment variable is not set")
+		}
+	case BrokerPostgres:
+	default:
+		return nil, fmt.Errorf("unknown BROKER %q", broker)
+	}
+
+	natsSubject := os.Getenv("NATS_SUBJECT_PREFIX")
+	if natsSubject == "" {
+		natsSubject = "tasks"
+	}
+
+	redisPrefix := os.Getenv("REDIS_STREAM_PREFIX")
+	if redisPrefix == "" {
+		redisPrefix = "tasks"
+	}
+
+	redisGroup := os.Getenv("REDIS_GROUP")
+	if redisGroup == "" {
+		redisGroup = "workers"
+	}
+
+	redisMaxLen := int64(10000)
+	if v := os.Getenv("REDIS_STREAM_MAXLEN"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid REDIS_STREAM_MAXLEN %q", v)
+		}
+		redisMaxLen = n
+	}
+
+	claimLease := 5 * time.Minute
+	if v := os.Getenv("CLAIM_LEASE"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid CLAIM_LEASE %q", v)
+		}
+		claimLease = d
+	}
+
+	autoMigrate := true
+	if v := os.Getenv("AUTO_MIGRATE"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid AUTO_MIGRATE %q", v)
+		}
+		autoMigrate = b
+	}
+
+	traceFile := os.Getenv("TRACE_FILE")
+	if traceFile == "" {
+		traceFile = "traces.json"
+	}
+
+	forbidCompletedParent := false
+	if v := os.Getenv("FORBID_COMPLETED_PARENT"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid FORBID_COMPLETED_PARENT %q", v)
+		}
+		forbidCompletedParent = b
+	}
+
+	authRequired := true
+	if v := os.Getenv("AUTH_REQUIRED"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid AUTH_REQUIRED %q", v)
+		}
+		authRequired = b
+	}
+
+	jwtWorkerClaim := os.Getenv("JWT_WORKER_CLAIM")
+	if jwtWorkerClaim == "" {
+		jwtWorkerClaim = "worker"
+	}
+
+	jwtNamespaceClaim := os.Getenv("JWT_NAMESPACE_CLAIM")
+	if jwtNamespaceClaim == "" {
+		jwtNamespaceClaim = "namespace"
+	}
+
+	maxPending := 0
+	if v := os.Getenv("NAMESPACE_MAX_PENDING"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid NAMESPACE_MAX_PENDING %q", v)
+		}
+		maxPending = n
+	}
+
+	rateLimitWorker, err := ratelimit.ParseRate(os.Getenv("RATE_LIMIT_WORKER"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid RATE_LIMIT_WORKER: %w", err)
+	}
+	rateLimitClient, err := ratelimit.ParseRate(os.Getenv("RATE_LIMIT_CLIENT"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid RATE_LIMIT_CLIENT: %w", err)
+	}
+
+	maxPendingPerWorker, err := nonNegativeInt("MAX_PENDING_PER_WORKER")
+	if err != nil {
+		return nil, err
+	}
+	maxTreeDepth, err := nonNegativeInt("MAX_TREE_DEPTH")
+	if err != nil {
+		return nil, err
+	}
+	maxTreeSize, err := nonNegativeInt("MAX_TREE_SIZE")
+	if err != nil {
+		return nil, err
+	}
+
+	webhookMaxAttempts := 10
+	if v := os.Getenv("WEBHOOK_MAX_ATTEMPTS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("invalid WEBHOOK_MAX_ATTEMPTS %q", v)
+		}
+		webhookMaxAttempts = n
+	}
+
+	webhookTimeout := 10 * time.Second
+	if v := os.Getenv("WEBHOOK_TIMEOUT"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid WEBHOOK_TIMEOUT %q", v)
+		}
+		webhookTimeout = d
+	}
+
+	var webhookAllowNetworks []netip.Prefix
+	if v := os.Getenv("WEBHOOK_ALLOW_NETWORKS"); v != "" {
+		for _, s := range strings.Split(v, ",") {
+			p, err := netip.ParsePrefix(strings.TrimSpace(s))
+			if err != nil {
+				return nil, fmt.Errorf("invalid WEBHOOK_ALLOW_NETWORKS %q", v)
+			}
+			webhookAllowNetworks = append(webhookAllowNetworks, p)
+		}
+	}
+
+	logLevel := os.Getenv("LOG_LEVEL")
+	if logLevel == "" {
+		logLevel = "info"
 	}
 
 	port := os.Getenv("PORT")
@@ -40,9 +277,58 @@ func Load() (*Config, error) {
 		port = "8080"
 	}
 
+	grpcPort, ok := os.LookupEnv("GRPC_PORT")
+	if !ok {
+		grpcPort = "9090"
+	}
+
 	return &Config{
-		PostgresURL: pgURL,
-		RabbitMQURL: rabbitURL,
-		Port:        port,
+		PostgresURL:           pgURL,
+		Broker:                broker,
+		RabbitMQURL:           rabbitURL,
+		NATSURL:               natsURL,
+		NATSSubject:           natsSubject,
+		RedisURL:              redisURL,
+		RedisPrefix:           redisPrefix,
+		RedisGroup:            redisGroup,
+		RedisMaxLen:           redisMaxLen,
+		ClaimLease:            claimLease,
+		AutoMigrate:           autoMigrate,
+		ForbidCompletedParent: forbidCompletedParent,
+		AuthRequired:          authRequired,
+		AdminKey:              os.Getenv("ADMIN_API_KEY"),
+		JWKS:                  os.Getenv("JWT_JWKS"),
+		JWTIssuer:             os.Getenv("JWT_ISSUER"),
+		JWTAudience:           os.Getenv("JWT_AUDIENCE"),
+		JWTWorkerClaim:        jwtWorkerClaim,
+		JWTNamespaceClaim:     jwtNamespaceClaim,
+		MaxPending:            maxPending,
+		RateLimitWorker:       rateLimitWorker,
+		RateLimitClient:       rateLimitClient,
+		MaxPendingPerWorker:   maxPendingPerWorker,
+		MaxTreeDepth:          maxTreeDepth,
+		MaxTreeSize:           maxTreeSize,
+		WebhookSecret:         os.Getenv("WEBHOOK_SECRET"),
+		WebhookAllowNetworks:  webhookAllowNetworks,
+		WebhookMaxAttempts:    webhookMaxAttempts,
+		WebhookTimeout:        webhookTimeout,
+		TraceExporter:         os.Getenv("TRACE_EXPORTER"),
+		TraceFile:             traceFile,
+		LogLevel:              logLevel,
+		Port:                  port,
+		GRPCPort:              grpcPort,
 	}, nil
 }
+
+// nonNegativeInt reads an optional integer variable that defaults to 0.
+func nonNegativeInt(name string) (int, error) {
+	v := os.Getenv(name)
+	if v == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid %s %q", name, v)
+	}
+	return n, nil
+}
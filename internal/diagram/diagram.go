@@ -0,0 +1,147 @@
+// Package diagram renders trees of tasks as text diagrams, Graphviz DOT
+// or Mermaid flowcharts, that can be pasted into documents and rendered
+// without the API.
+package diagram
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"task-api/internal/storage"
+	"time"
+)
+
+// Formats of Render.
+const (
+	FormatDOT     = "dot"
+	FormatMermaid = "mermaid"
+)
+
+// ContentTypes maps each format to the Content-Type it is served with.
+var ContentTypes = map[string]string{
+	FormatDOT:     "text/vnd.graphviz; charset=utf-8",
+	FormatMermaid: "text/plain; charset=utf-8",
+}
+
+// colors are the fill and border of the nodes of each status.
+var colors = map[string][2]string{
+	storage.StatusPending:   {"#fefcbf", "#d69e2e"},
+	storage.StatusCompleted: {"#c6f6d5", "#38a169"},
+	storage.StatusFailed:    {"#fed7d7", "#e53e3e"},
+	storage.StatusCancelled: {"#e2e8f0", "#718096"},
+}
+
+// Options tune a diagram.
+type Options struct {
+	// Durations adds how long each task ran to its node. Unfinished tasks
+	// show how long they have been running at Now.
+	Durations bool
+	Now       time.Time
+}
+
+// Render writes the tree of tasks in the format, which must be FormatDOT
+// or FormatMermaid. Tasks are linked to their parent if it is among them,
+// so the first task is drawn as the root even if it has a parent.
+func Render(w io.Writer, format string, tasks []*storage.Task, opts Options) error {
+	switch format {
+	case FormatDOT:
+		return renderDOT(w, tasks, opts)
+	case FormatMermaid:
+		return renderMermaid(w, tasks, opts)
+	}
+	return fmt.Errorf("unknown diagram format %q", format)
+}
+
+func renderDOT(w io.Writer, tasks []*storage.Task, opts Options) error {
+	var b strings.Builder
+	b.WriteString("digraph tasks {\n")
+	b.WriteString("  rankdir=TB;\n")
+	b.WriteString("  node [shape=box, style=\"rounded,filled\", fontname=\"Helvetica\"];\n")
+	for _, t := range tasks {
+		c := colors[t.Status]
+		label := strings.Join(escapeAll(labelLines(t, opts), dotEscaper), `\n`)
+		fmt.Fprintf(&b, "  \"%s\" [label=\"%s\", fillcolor=\"%s\", color=\"%s\"];\n", t.ID, label, c[0], c[1])
+	}
+	for _, e := range edges(tasks) {
+		fmt.Fprintf(&b, "  \"%s\" -> \"%s\";\n", e[0], e[1])
+	}
+	b.WriteString("}\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func renderMermaid(w io.Writer, tasks []*storage.Task, opts Options) error {
+	var b strings.Builder
+	b.WriteString("flowchart TD\n")
+	for _, t := range tasks {
+		label := strings.Join(escapeAll(labelLines(t, opts), mermaidEscaper), "<br/>")
+		fmt.Fprintf(&b, "  %s[\"%s\"]:::%s\n", mermaidID(t.ID), label, t.Status)
+	}
+	for _, e := range edges(tasks) {
+		fmt.Fprintf(&b, "  %s --> %s\n", mermaidID(e[0]), mermaidID(e[1]))
+	}
+	for _, status := range []string{storage.StatusPending, storage.StatusCompleted, storage.StatusFailed, storage.StatusCancelled} {
+		c := colors[status]
+		fmt.Fprintf(&b, "  classDef %s fill:%s,stroke:%s\n", status, c[0], c[1])
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// labelLines are the lines of the label of a task, unescaped: its worker,
+// status, the start of its id and, if asked for, its duration.
+func labelLines(t *storage.Task, opts Options) []string {
+	lines := []string{t.Worker, t.Status, t.ID[:8]}
+	if opts.Durations {
+		if t.CompletedAt != nil {
+			lines = append(lines, formatDuration(t.CompletedAt.Sub(t.CreatedAt)))
+		} else {
+			lines = append(lines, "running "+formatDuration(opts.Now.Sub(t.CreatedAt)))
+		}
+	}
+	return lines
+}
+
+// edges returns the parent and child ids of the tasks whose parent is
+// among tasks.
+func edges(tasks []*storage.Task) [][2]string {
+	ids := make(map[string]bool, len(tasks))
+	for _, t := range tasks {
+		ids[t.ID] = true
+	}
+	var e [][2]string
+	for _, t := range tasks {
+		if t.ParentID != nil && ids[*t.ParentID] {
+			e = append(e, [2]string{*t.ParentID, t.ID})
+		}
+	}
+	return e
+}
+
+// formatDuration rounds d to a readable precision: milliseconds below a
+// second, seconds above.
+func formatDuration(d time.Duration) string {
+	if d < time.Second {
+		return d.Round(time.Millisecond).String()
+	}
+	return d.Round(time.Second).String()
+}
+
+// Escapers of label lines, which may hold any worker name. Line breaks
+// are added by the renderers between lines.
+var (
+	dotEscaper     = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", " ", "\r", " ")
+	mermaidEscaper = strings.NewReplacer("#", "#35;", `"`, "#quot;", "<", "#lt;", ">", "#gt;", "\n", " ", "\r", " ")
+)
+
+func escapeAll(lines []string, r *strings.Replacer) []string {
+	for i, l := range lines {
+		lines[i] = r.Replace(l)
+	}
+	return lines
+}
+
+// mermaidID turns a task id into a Mermaid node id.
+func mermaidID(id string) string {
+	return "t" + strings.ReplaceAll(id, "-", "")
+}
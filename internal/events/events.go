@@ -0,0 +1,147 @@
+// Package events delivers the task events sent by the storage layer with
+// Postgres NOTIFY to subscribers in this process. Every API replica runs
+// its own Hub, so a subscriber sees changes made through any replica.
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"sync"
+	"task-api/internal/storage"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// subscriptionBuffer is how many events a subscriber may fall behind
+// before it is dropped.
+const subscriptionBuffer = 256
+
+// Hub listens on storage.EventsChannel and fans events out to subscribers.
+type Hub struct {
+	listener *pq.Listener
+
+	mu      sync.Mutex
+	subs    map[*Subscription]struct{}
+	stopped bool
+}
+
+// Subscription receives the events of one namespace. Its channel is closed
+// when the subscriber falls behind or the connection to Postgres was lost,
+// since events may have been missed; the subscriber should then reload the
+// state it cares about and subscribe again.
+type Subscription struct {
+	namespace string
+	ch        chan *storage.TaskEvent
+}
+
+// Events returns the channel the events arrive on.
+func (s *Subscription) Events() <-chan *storage.TaskEvent {
+	return s.ch
+}
+
+// NewHub connects to Postgres and starts listening. Events are only
+// delivered once Run is called.
+func NewHub(postgresURL string) (*Hub, error) {
+	listener := pq.NewListener(postgresURL, time.Second, time.Minute, func(ev pq.ListenerEventType, err error) {
+		if err != nil {
+			slog.Error("Task event listener", "event", ev, "error", err)
+		}
+	})
+	if err := listener.Listen(storage.EventsChannel); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return &Hub{listener: listener, subs: map[*Subscription]struct{}{}}, nil
+}
+
+// Run delivers events until ctx is cancelled, then closes the listener.
+func (h *Hub) Run(ctx context.Context) {
+	defer h.listener.Close()
+	// Ping now and then so that a dead connection is noticed
+	ticker := time.NewTicker(90 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			h.mu.Lock()
+			h.stopped = true
+			h.mu.Unlock()
+			h.dropAll()
+			return
+		case <-ticker.C:
+			go h.listener.Ping()
+		case n := <-h.listener.Notify:
+			if n == nil {
+				// Reconnected, anything sent meanwhile is lost
+				h.dropAll()
+				continue
+			}
+			ev := &storage.TaskEvent{}
+			if err := json.Unmarshal([]byte(n.Extra), ev); err != nil {
+				slog.Warn("Malformed task event", "payload", n.Extra, "error", err)
+				continue
+			}
+			h.deliver(ev)
+		}
+	}
+}
+
+// Subscribe returns a subscription to the events of the namespace. Once
+// the hub has stopped, the subscription is closed right away.
+func (h *Hub) Subscribe(namespace string) *Subscription {
+	s := &Subscription{namespace: namespace, ch: make(chan *storage.TaskEvent, subscriptionBuffer)}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.stopped {
+		close(s.ch)
+		return s
+	}
+	h.subs[s] = struct{}{}
+	return s
+}
+
+// Stopped reports whether Run has returned, so no more events will come.
+func (h *Hub) Stopped() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.stopped
+}
+
+// Unsubscribe stops the subscription. It is safe to call more than once.
+func (h *Hub) Unsubscribe(s *Subscription) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.subs[s]; ok {
+		delete(h.subs, s)
+		close(s.ch)
+	}
+}
+
+func (h *Hub) deliver(ev *storage.TaskEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for s := range h.subs {
+		if s.namespace != ev.Namespace {
+			continue
+		}
+		select {
+		case s.ch <- ev:
+		default:
+			slog.Warn("Task event subscriber fell behind, dropping it", "namespace", s.namespace)
+			delete(h.subs, s)
+			close(s.ch)
+		}
+	}
+}
+
+func (h *Hub) dropAll() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for s := range h.subs {
+		delete(h.subs, s)
+		close(s.ch)
+	}
+}
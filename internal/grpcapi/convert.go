@@ -0,0 +1,115 @@
+package grpcapi
+
+import (
+	"encoding/json"
+	"task-api/internal/storage"
+	taskapiv1 "task-api/proto/taskapi/v1"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// toJSON encodes a payload or result for storage, nil when it is unset.
+func toJSON(v *structpb.Value) (json.RawMessage, error) {
+	if v == nil {
+		return nil, nil
+	}
+	b, err := protojson.Marshal(v)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "Invalid value: "+err.Error())
+	}
+	return b, nil
+}
+
+// fromJSON decodes a stored JSON document, nil when it is empty.
+func fromJSON(raw json.RawMessage) *structpb.Value {
+	if len(raw) == 0 {
+		return nil
+	}
+	v := &structpb.Value{}
+	if err := protojson.Unmarshal(raw, v); err != nil {
+		// Stored documents are valid JSON
+		return nil
+	}
+	return v
+}
+
+func timestamp(t *time.Time) *timestamppb.Timestamp {
+	if t == nil {
+		return nil
+	}
+	return timestamppb.New(*t)
+}
+
+// optional turns an unset proto3 string into nil.
+func optional(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
+
+func value(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+var statuses = map[string]taskapiv1.TaskStatus{
+	storage.StatusPending:   taskapiv1.TaskStatus_TASK_STATUS_PENDING,
+	storage.StatusCompleted: taskapiv1.TaskStatus_TASK_STATUS_COMPLETED,
+	storage.StatusFailed:    taskapiv1.TaskStatus_TASK_STATUS_FAILED,
+	storage.StatusCancelled: taskapiv1.TaskStatus_TASK_STATUS_CANCELLED,
+}
+
+// fromStatus returns the stored status for s, "" for unspecified.
+func fromStatus(s taskapiv1.TaskStatus) string {
+	for k, v := range statuses {
+		if v == s {
+			return k
+		}
+	}
+	if s == taskapiv1.TaskStatus_TASK_STATUS_UNSPECIFIED {
+		return ""
+	}
+	// Unknown values are rejected by the service
+	return s.String()
+}
+
+func toTask(t *storage.Task) *taskapiv1.Task {
+	return &taskapiv1.Task{
+		Id:             t.ID,
+		Namespace:      t.Namespace,
+		ParentId:       value(t.ParentID),
+		RootId:         value(t.RootID),
+		Depth:          int32(t.Depth),
+		Worker:         t.Worker,
+		Payload:        fromJSON(t.Payload),
+		Result:         fromJSON(t.Result),
+		Status:         statuses[t.Status],
+		Error:          value(t.Error),
+		CreatedAt:      timestamppb.New(t.CreatedAt),
+		CompletedAt:    timestamp(t.CompletedAt),
+		Progress:       fromJSON(t.Progress),
+		ProgressAt:     timestamp(t.ProgressAt),
+		CallbackUrl:    value(t.CallbackURL),
+		CallbackEvents: t.CallbackEvents,
+	}
+}
+
+func toEvent(ev *storage.TaskEvent) *taskapiv1.TaskEvent {
+	return &taskapiv1.TaskEvent{
+		Type:     ev.Type,
+		TaskId:   ev.TaskID,
+		ParentId: value(ev.ParentID),
+		Worker:   ev.Worker,
+		Status:   statuses[ev.Status],
+		Progress: fromJSON(ev.Progress),
+		At:       timestamppb.New(ev.At),
+	}
+}
@@ -0,0 +1,168 @@
+package grpcapi
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"task-api/internal/auth"
+	"task-api/internal/logging"
+	"task-api/internal/metrics"
+	"task-api/internal/service"
+	"time"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// Metadata read from every call, lower case as gRPC delivers it.
+const (
+	authorizationMetadata = "authorization"
+	namespaceMetadata     = "x-namespace"
+	requestIDMetadata     = "x-request-id"
+)
+
+// errorDomain is the domain of the ErrorInfo attached to errors.
+const errorDomain = "task-api"
+
+func (s *Server) unaryInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	start := time.Now()
+	ctx, err := s.prepare(ctx)
+	var resp any
+	if err == nil {
+		resp, err = handler(ctx, req)
+	}
+	observe(ctx, info.FullMethod, start, err)
+	return resp, err
+}
+
+func (s *Server) streamInterceptor(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	start := time.Now()
+	ctx, err := s.prepare(ss.Context())
+	if err == nil {
+		err = handler(srv, &serverStream{ServerStream: ss, ctx: ctx})
+	}
+	observe(ctx, info.FullMethod, start, err)
+	return err
+}
+
+// serverStream replaces the context of a stream with the prepared one.
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *serverStream) Context() context.Context {
+	return s.ctx
+}
+
+// prepare tags the call for logging, and resolves its credentials to a
+// principal and the namespace it runs in, as the HTTP API does.
+func (s *Server) prepare(ctx context.Context) (context.Context, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	ctx, id := logging.NewContext(ctx, first(md, requestIDMetadata))
+	grpc.SetHeader(ctx, metadata.Pairs(requestIDMetadata, id))
+
+	p, e := s.svc.Authenticate(ctx, first(md, authorizationMetadata))
+	if e != nil {
+		return ctx, statusOf(e)
+	}
+	ns, e := service.Namespace(p, first(md, namespaceMetadata))
+	if e != nil {
+		return ctx, statusOf(e)
+	}
+
+	ctx = auth.NewContext(ctx, p)
+	ctx = context.WithValue(ctx, namespaceKey{}, ns)
+	logging.Add(ctx, "namespace", ns)
+	return ctx, nil
+}
+
+// observe logs one line per call and records its latency.
+func observe(ctx context.Context, method string, start time.Time, err error) {
+	code := status.Code(err)
+	metrics.GRPCDuration.WithLabelValues(method, code.String()).Observe(time.Since(start).Seconds())
+	slog.InfoContext(ctx, "Handled call",
+		"method", method,
+		"code", code.String(),
+		"duration_ms", time.Since(start).Milliseconds(),
+	)
+}
+
+type namespaceKey struct{}
+
+// namespaceFrom returns the namespace the call was resolved to.
+func namespaceFrom(ctx context.Context) string {
+	ns, _ := ctx.Value(namespaceKey{}).(string)
+	return ns
+}
+
+// clientKey identifies the caller for the client rate limit, like the HTTP
+// API does.
+func clientKey(ctx context.Context) string {
+	p := auth.FromContext(ctx)
+	if p.KeyID != "" {
+		return "key:" + p.KeyID
+	}
+	if p.Subject != "" {
+		return "sub:" + p.Subject
+	}
+	addr := ""
+	if pr, ok := peer.FromContext(ctx); ok && pr.Addr != nil {
+		addr = pr.Addr.String()
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	return "ip:" + host
+}
+
+// first returns the first value of a metadata key, or "".
+func first(md metadata.MD, key string) string {
+	if v := md.Get(key); len(v) > 0 {
+		return strings.TrimSpace(v[0])
+	}
+	return ""
+}
+
+// grpcCodes maps the HTTP statuses of service errors to gRPC codes.
+var grpcCodes = map[int]codes.Code{
+	http.StatusBadRequest:          codes.InvalidArgument,
+	http.StatusUnauthorized:        codes.Unauthenticated,
+	http.StatusForbidden:           codes.PermissionDenied,
+	http.StatusNotFound:            codes.NotFound,
+	http.StatusConflict:            codes.FailedPrecondition,
+	http.StatusTooManyRequests:     codes.ResourceExhausted,
+	http.StatusServiceUnavailable:  codes.Unavailable,
+	http.StatusInternalServerError: codes.Internal,
+}
+
+// statusOf translates a service error to a gRPC status. The error code of
+// the HTTP API travels as the reason of an ErrorInfo, the Retry-After as a
+// RetryInfo.
+func statusOf(e *service.Error) error {
+	code, ok := grpcCodes[e.Status]
+	if !ok {
+		code = codes.Unknown
+	}
+	st := status.New(code, e.Message)
+	info := &errdetails.ErrorInfo{Reason: e.Code, Domain: errorDomain}
+	var err error
+	if e.RetryAfter > 0 {
+		st, err = st.WithDetails(info, &errdetails.RetryInfo{RetryDelay: durationpb.New(e.RetryAfter)})
+	} else {
+		st, err = st.WithDetails(info)
+	}
+	if err != nil {
+		// Only fails for an OK status
+		return status.Error(code, e.Message)
+	}
+	return st.Err()
+}
@@ -0,0 +1,128 @@
+// Package grpcapi serves the gRPC API of proto/taskapi/v1 on top of the
+// service layer, with the same permissions and error codes as the HTTP
+// API.
+package grpcapi
+
+import (
+	"context"
+	"task-api/internal/service"
+	"task-api/internal/tracing"
+	taskapiv1 "task-api/proto/taskapi/v1"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// Server implements taskapiv1.TaskServiceServer.
+type Server struct {
+	taskapiv1.UnimplementedTaskServiceServer
+	svc *service.Service
+}
+
+// NewServer returns a gRPC server with the task service registered. Every
+// call is authenticated like an HTTP request, from the "authorization" and
+// "x-namespace" metadata.
+func NewServer(svc *service.Service) *grpc.Server {
+	s := &Server{svc: svc}
+	gs := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(s.unaryInterceptor),
+		grpc.ChainStreamInterceptor(s.streamInterceptor),
+	)
+	taskapiv1.RegisterTaskServiceServer(gs, s)
+	return gs
+}
+
+// traced returns ctx carrying the trace context of the call metadata, if
+// there is one.
+func traced(ctx context.Context) context.Context {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if tp := first(md, "traceparent"); tp != "" {
+		return tracing.FromTraceparent(ctx, tp)
+	}
+	return ctx
+}
+
+func (s *Server) CreateTask(ctx context.Context, req *taskapiv1.CreateTaskRequest) (*taskapiv1.CreateTaskResponse, error) {
+	payload, err := toJSON(req.GetPayload())
+	if err != nil {
+		return nil, err
+	}
+	id, e := s.svc.CreateTask(traced(ctx), namespaceFrom(ctx), &service.NewTask{
+		Worker:         req.GetWorker(),
+		ParentID:       optional(req.GetParentId()),
+		Payload:        payload,
+		CallbackURL:    optional(req.GetCallbackUrl()),
+		CallbackEvents: req.GetCallbackEvents(),
+		Client:         clientKey(ctx),
+	})
+	if e != nil {
+		return nil, statusOf(e)
+	}
+	return &taskapiv1.CreateTaskResponse{Id: id}, nil
+}
+
+func (s *Server) CompleteTask(ctx context.Context, req *taskapiv1.CompleteTaskRequest) (*taskapiv1.CompleteTaskResponse, error) {
+	result, err := toJSON(req.GetResult())
+	if err != nil {
+		return nil, err
+	}
+	if e := s.svc.CompleteTask(traced(ctx), namespaceFrom(ctx), req.GetId(), result); e != nil {
+		return nil, statusOf(e)
+	}
+	return &taskapiv1.CompleteTaskResponse{}, nil
+}
+
+func (s *Server) FailTask(ctx context.Context, req *taskapiv1.FailTaskRequest) (*taskapiv1.FailTaskResponse, error) {
+	if e := s.svc.FailTask(traced(ctx), namespaceFrom(ctx), req.GetId(), req.GetError()); e != nil {
+		return nil, statusOf(e)
+	}
+	return &taskapiv1.FailTaskResponse{}, nil
+}
+
+func (s *Server) GetTask(ctx context.Context, req *taskapiv1.GetTaskRequest) (*taskapiv1.Task, error) {
+	t, e := s.svc.GetTask(ctx, namespaceFrom(ctx), req.GetId())
+	if e != nil {
+		return nil, statusOf(e)
+	}
+	return toTask(t), nil
+}
+
+func (s *Server) ListTasks(ctx context.Context, req *taskapiv1.ListTasksRequest) (*taskapiv1.ListTasksResponse, error) {
+	tasks, next, e := s.svc.ListTasks(ctx, namespaceFrom(ctx), &service.TaskQuery{
+		Worker:    req.GetWorker(),
+		Status:    fromStatus(req.GetStatus()),
+		ParentID:  req.GetParentId(),
+		PageSize:  int(req.GetPageSize()),
+		PageToken: req.GetPageToken(),
+	})
+	if e != nil {
+		return nil, statusOf(e)
+	}
+	resp := &taskapiv1.ListTasksResponse{NextPageToken: next}
+	for _, t := range tasks {
+		resp.Tasks = append(resp.Tasks, toTask(t))
+	}
+	return resp, nil
+}
+
+func (s *Server) CancelTask(ctx context.Context, req *taskapiv1.CancelTaskRequest) (*taskapiv1.CancelTaskResponse, error) {
+	ids, e := s.svc.CancelTask(traced(ctx), namespaceFrom(ctx), req.GetId(), req.GetReason())
+	if e != nil {
+		return nil, statusOf(e)
+	}
+	return &taskapiv1.CancelTaskResponse{CancelledIds: ids}, nil
+}
+
+func (s *Server) WatchTask(req *taskapiv1.WatchTaskRequest, stream grpc.ServerStreamingServer[taskapiv1.TaskEvent]) error {
+	ctx := stream.Context()
+	evs, e := s.svc.WatchTask(ctx, namespaceFrom(ctx), req.GetId(), req.GetSubtree())
+	if e != nil {
+		return statusOf(e)
+	}
+	for ev := range evs {
+		if err := stream.Send(toEvent(ev)); err != nil {
+			return err
+		}
+	}
+	return ctx.Err()
+}
@@ -0,0 +1,149 @@
+// Package metrics holds the Prometheus metrics exported on /metrics.
+package metrics
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"task-api/internal/logging"
+	"task-api/internal/storage"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	TasksCreated = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "task_api_tasks_created_total",
+		Help: "Tasks created, per namespace and worker.",
+	}, []string{"namespace", "worker"})
+
+	TasksCompleted = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "task_api_tasks_completed_total",
+		Help: "Tasks completed successfully, per namespace and worker.",
+	}, []string{"namespace", "worker"})
+
+	TasksFailed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "task_api_tasks_failed_total",
+		Help: "Tasks reported as failed, per namespace and worker.",
+	}, []string{"namespace", "worker"})
+
+	TasksCancelled = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "task_api_tasks_cancelled_total",
+		Help: "Tasks cancelled, with their descendants, per namespace and worker.",
+	}, []string{"namespace", "worker"})
+
+	Publishes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "task_api_queue_publish_total",
+		Help: "Messages published to the queue, per namespace, worker and result (success or failure).",
+	}, []string{"namespace", "worker", "result"})
+
+	TaskDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "task_api_task_duration_seconds",
+		Help:    "Time from task creation to completion, per namespace and worker.",
+		Buckets: prometheus.ExponentialBuckets(0.1, 4, 10), // 100ms .. ~7h
+	}, []string{"namespace", "worker"})
+
+	HTTPDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "task_api_http_request_duration_seconds",
+		Help:    "HTTP request latency, per route, method and status code.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"route", "method", "code"})
+
+	GRPCDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "task_api_grpc_request_duration_seconds",
+		Help:    "gRPC call latency, per method and status code; streams count until they end.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method", "code"})
+
+	PendingTasks = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "task_api_tasks_pending",
+		Help: "Incomplete tasks per namespace and worker, as last read from the database.",
+	}, []string{"namespace", "worker"})
+
+	CreateRejected = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "task_api_create_rejected_total",
+		Help: "Task creations refused by a rate limit, quota or tree limit, per namespace and reason.",
+	}, []string{"namespace", "reason"})
+
+	WebhookAttempts = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "task_api_webhook_attempts_total",
+		Help: "Webhook delivery attempts, per event and result (success, retry or failure).",
+	}, []string{"event", "result"})
+
+	WaitingParents = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "task_api_parents_waiting",
+		Help: "Incomplete tasks that still have incomplete children, as last read from the database.",
+	})
+
+	WorkerSockets = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "task_api_worker_sockets",
+		Help: "Workers connected over the WebSocket gateway.",
+	})
+)
+
+// ObservePublish records the outcome of a PublishTask call.
+func ObservePublish(namespace string, worker string, err error) {
+	result := "success"
+	if err != nil {
+		result = "failure"
+	}
+	Publishes.WithLabelValues(namespace, worker, result).Inc()
+}
+
+// Middleware records request latency labelled with the mux route template,
+// so ids in paths do not create new series.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		// Behind logging.Middleware this is the recorder it installed
+		rec := logging.NewStatusRecorder(w)
+		next.ServeHTTP(rec, r)
+
+		route := "unmatched"
+		if cur := mux.CurrentRoute(r); cur != nil {
+			if tpl, err := cur.GetPathTemplate(); err == nil {
+				route = tpl
+			}
+		}
+		HTTPDuration.WithLabelValues(route, r.Method, strconv.Itoa(rec.Status)).Observe(time.Since(start).Seconds())
+	})
+}
+
+// RunRefresher updates the gauges read from the database at the given
+// interval until ctx is cancelled.
+func RunRefresher(ctx context.Context, store *storage.Storage, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		refresh(store)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func refresh(store *storage.Storage) {
+	counts, err := store.PendingTaskCounts()
+	if err != nil {
+		slog.Error("Error reading pending task counts", "error", err)
+	} else {
+		PendingTasks.Reset()
+		for _, c := range counts {
+			PendingTasks.WithLabelValues(c.Namespace, c.Worker).Set(float64(c.Count))
+		}
+	}
+
+	waiting, err := store.WaitingParentCount()
+	if err != nil {
+		slog.Error("Error reading waiting parent count", "error", err)
+	} else {
+		WaitingParents.Set(float64(waiting))
+	}
+}
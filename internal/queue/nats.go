@@ -0,0 +1,221 @@
+package queue
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log/slog"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// NATS publishes tasks to JetStream. Every worker gets its own work-queue
+// stream bound to the subject "<prefix>.<queue name>", see StreamName and
+// Subject.
+type NATS struct {
+	nc        *nats.Conn
+	js        jetstream.JetStream
+	prefix    string
+	streams   sync.Map // worker name -> struct{}, streams already declared
+	consumers sync.Map // worker name -> jetstream.Consumer
+}
+
+// NATSConsumer is the durable pull consumer shared by workers and the API.
+// Work-queue streams reject a second consumer on the same subject, so every
+// reader of a worker's stream must bind to this one.
+const NATSConsumer = "workers"
+
+// NewNATS connects to the server at url. Extra options are passed to
+// nats.Connect, e.g. nats.InProcessServer to run against an embedded server.
+func NewNATS(url string, subjectPrefix string, opts ...nats.Option) (*NATS, error) {
+	nc, err := nats.Connect(url, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	js, err := jetstream.New(nc)
+	if err != nil {
+		nc.Close()
+		return nil, err
+	}
+
+	return &NATS{
+		nc:     nc,
+		js:     js,
+		prefix: subjectPrefix,
+	}, nil
+}
+
+func (q *NATS) Close() {
+	if q.nc != nil {
+		q.nc.Drain()
+	}
+}
+
+func (q *NATS) IsClosed() bool {
+	return !q.nc.IsConnected()
+}
+
+var (
+	invalidStreamChars = regexp.MustCompile(`[^A-Za-z0-9_-]`)
+	plainStreamName    = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+	plainSubject       = regexp.MustCompile(`^[A-Za-z0-9_-]+(\.[A-Za-z0-9_-]+)?$`)
+)
+
+// Subject returns the subject tasks for the given worker are published to:
+// "<prefix>.<queue name>", unless the worker name holds characters that
+// have a meaning in subjects, see escapeName.
+func (q *NATS) Subject(queueName string) string {
+	if plainSubject.MatchString(queueName) {
+		return q.prefix + "." + queueName
+	}
+	return q.prefix + "." + escapeName(queueName)
+}
+
+// StreamName returns the JetStream stream holding tasks for the given
+// worker: "<prefix>_<worker>" for plain names in the default namespace.
+// Other queue names, which include every namespaced one, are escaped, see
+// escapeName.
+func (q *NATS) StreamName(queueName string) string {
+	prefix := invalidStreamChars.ReplaceAllString(q.prefix, "_")
+	if plainStreamName.MatchString(queueName) {
+		return prefix + "_" + queueName
+	}
+	return prefix + "_" + escapeName(queueName)
+}
+
+// escapeName replaces the characters of a queue name that NATS does not
+// take in stream names with "_" and appends a digest of the name, so that
+// names differing only in those characters, like "team.a_b" and "team_a.b",
+// do not share a stream.
+func escapeName(queueName string) string {
+	sum := sha256.Sum256([]byte(queueName))
+	return invalidStreamChars.ReplaceAllString(queueName, "_") + "_" + hex.EncodeToString(sum[:8])
+}
+
+func (q *NATS) ensureStream(ctx context.Context, queueName string) error {
+	if _, ok := q.streams.Load(queueName); ok {
+		return nil
+	}
+	_, err := q.js.CreateOrUpdateStream(ctx, jetstream.StreamConfig{
+		Name:      q.StreamName(queueName),
+		Subjects:  []string{q.Subject(queueName)},
+		Retention: jetstream.WorkQueuePolicy,
+		Storage:   jetstream.FileStorage,
+	})
+	if err != nil {
+		return err
+	}
+	q.streams.Store(queueName, struct{}{})
+	return nil
+}
+
+func (q *NATS) PublishTask(ctx context.Context, queueName string, taskID string, payload json.RawMessage) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	// Ensure stream exists
+	if err := q.ensureStream(ctx, queueName); err != nil {
+		return err
+	}
+
+	body, err := encodeMessage(taskID, payload)
+	if err != nil {
+		return err
+	}
+
+	msg := nats.NewMsg(q.Subject(queueName))
+	msg.Data = body
+	for k, v := range traceHeaders(ctx) {
+		msg.Header.Set(k, v)
+	}
+
+	_, err = q.js.PublishMsg(ctx, msg, jetstream.WithMsgID(messageID(taskID, payload)))
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to publish message", "task_id", taskID, "subject", q.Subject(queueName), "error", err)
+		return err
+	}
+	slog.InfoContext(ctx, "Published task", "task_id", taskID, "subject", q.Subject(queueName))
+	return nil
+}
+
+// messageID is the Nats-Msg-Id used for deduplication. It is the task id,
+// except for parents re-queued with aggregated subtasks: those reuse the id
+// of a message that may still be inside the duplicate window and would be
+// dropped by the server, so the id is suffixed with a digest of the payload.
+func messageID(taskID string, payload json.RawMessage) string {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(payload, &fields); err == nil {
+		if _, ok := fields["subtasks"]; ok {
+			sum := sha256.Sum256(payload)
+			return taskID + "." + hex.EncodeToString(sum[:8])
+		}
+	}
+	return taskID
+}
+
+func (q *NATS) consumer(ctx context.Context, queueName string) (jetstream.Consumer, error) {
+	if c, ok := q.consumers.Load(queueName); ok {
+		return c.(jetstream.Consumer), nil
+	}
+	// Receive may be called with little time left to wait, which must not
+	// fail the set-up on first use
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+	defer cancel()
+	if err := q.ensureStream(ctx, queueName); err != nil {
+		return nil, err
+	}
+	c, err := q.js.CreateOrUpdateConsumer(ctx, q.StreamName(queueName), jetstream.ConsumerConfig{
+		Durable:   NATSConsumer,
+		AckPolicy: jetstream.AckExplicitPolicy,
+	})
+	if err != nil {
+		return nil, err
+	}
+	q.consumers.Store(queueName, c)
+	return c, nil
+}
+
+func (q *NATS) Receive(ctx context.Context, queueName string) (*Message, error) {
+	c, err := q.consumer(ctx, queueName)
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		var batch jetstream.MessageBatch
+		deadline, ok := ctx.Deadline()
+		if wait := time.Until(deadline); ok && wait > 100*time.Millisecond {
+			batch, err = c.Fetch(1, jetstream.FetchMaxWait(wait))
+		} else {
+			batch, err = c.FetchNoWait(1)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		for m := range batch.Messages() {
+			if err := m.Ack(); err != nil {
+				return nil, err
+			}
+			var msg Message
+			if err := json.Unmarshal(m.Data(), &msg); err != nil {
+				slog.WarnContext(ctx, "Dropping malformed message", "subject", m.Subject(), "error", err)
+				continue
+			}
+			return &msg, nil
+		}
+		if err := batch.Error(); err != nil {
+			return nil, err
+		}
+
+		if ctx.Err() != nil || !ok || time.Until(deadline) <= 100*time.Millisecond {
+			return nil, nil
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"task-api/internal/storage"
+	"time"
+)
+
+// Claimer is implemented by queues that workers pull from over HTTP
+// instead of consuming a broker directly.
+type Claimer interface {
+	Claim(queueName string, limit int) ([]Message, error)
+}
+
+// Postgres keeps the queue in the tasks table itself, for installs that run
+// without a broker. Publishing marks the task claimable, and workers claim
+// tasks with POST /workers/{name}/claim.
+type Postgres struct {
+	store *storage.Storage
+	lease time.Duration
+}
+
+// NewPostgres returns a queue backed by store. Claimed tasks are leased for
+// the given duration and become claimable again if not completed in time.
+func NewPostgres(store *storage.Storage, lease time.Duration) *Postgres {
+	return &Postgres{
+		store: store,
+		lease: lease,
+	}
+}
+
+func (q *Postgres) Close() {}
+
+func (q *Postgres) IsClosed() bool {
+	return q.store.Ping() != nil
+}
+
+// PublishTask marks the task claimable. The worker is already on the task
+// row, so queueName is only used for logging.
+func (q *Postgres) PublishTask(ctx context.Context, queueName string, taskID string, payload json.RawMessage) error {
+	if err := q.store.MarkQueued(taskID, payload); err != nil {
+		slog.ErrorContext(ctx, "Failed to queue task", "task_id", taskID, "worker", queueName, "error", err)
+		return err
+	}
+	slog.InfoContext(ctx, "Queued task", "task_id", taskID, "worker", queueName)
+	return nil
+}
+
+// Claim leases up to limit tasks queued for the worker the name stands
+// for.
+func (q *Postgres) Claim(queueName string, limit int) ([]Message, error) {
+	namespace, worker := splitName(queueName)
+	tasks, err := q.store.ClaimTasks(namespace, worker, limit, q.lease)
+	if err != nil {
+		return nil, err
+	}
+	msgs := make([]Message, 0, len(tasks))
+	for _, t := range tasks {
+		msgs = append(msgs, Message{
+			ID:      t.ID,
+			Payload: t.Payload,
+		})
+	}
+	return msgs, nil
+}
+
+func (q *Postgres) Receive(ctx context.Context, queueName string) (*Message, error) {
+	for {
+		msgs, err := q.Claim(queueName, 1)
+		if err != nil {
+			return nil, err
+		}
+		if len(msgs) > 0 {
+			return &msgs[0], nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, nil
+		case <-time.After(pollInterval):
+		}
+	}
+}
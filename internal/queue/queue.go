@@ -0,0 +1,74 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"task-api/internal/storage"
+	"task-api/pkg/client"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+// Queue is the broker the API publishes tasks to. Each worker name maps to
+// its own destination (a RabbitMQ queue, a NATS subject, ...), and every
+// backend delivers the same message envelope to workers. Backends that
+// support message headers carry the W3C trace context of ctx in them.
+type Queue interface {
+	PublishTask(ctx context.Context, queueName string, taskID string, payload json.RawMessage) error
+	IsClosed() bool
+	Close()
+}
+
+// Receiver is implemented by queues the API can take single messages from
+// on behalf of workers that pull over HTTP. Receive makes at least one
+// attempt and then waits until a message arrives or ctx is done, in which
+// case it returns a nil message and no error. A received message is removed
+// from the queue; redelivery is up to the caller.
+type Receiver interface {
+	Receive(ctx context.Context, queueName string) (*Message, error)
+}
+
+// Name returns the queue name of a worker in a namespace, as passed to
+// PublishTask and Receive. Workers in the default namespace keep their bare
+// name, so queues created before namespaces existed stay in use. Neither
+// namespaces nor worker names contain dots, so every queue name stands for
+// one worker. Workers built on pkg/worker use the same client.QueueName.
+func Name(namespace, worker string) string {
+	return client.QueueName(namespace, worker)
+}
+
+// splitName returns the namespace and worker a queue name from Name stands
+// for.
+func splitName(name string) (namespace, worker string) {
+	if ns, w, ok := strings.Cut(name, "."); ok {
+		return ns, w
+	}
+	return storage.DefaultNamespace, name
+}
+
+// pollInterval is how often backends without a blocking read retry.
+const pollInterval = 250 * time.Millisecond
+
+// Message is the envelope workers receive for every task.
+type Message struct {
+	ID      string          `json:"id"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+func encodeMessage(taskID string, payload json.RawMessage) ([]byte, error) {
+	return json.Marshal(Message{
+		ID:      taskID,
+		Payload: payload,
+	})
+}
+
+// traceHeaders returns the propagation headers (traceparent, tracestate,
+// baggage) for the trace context in ctx.
+func traceHeaders(ctx context.Context) map[string]string {
+	carrier := propagation.MapCarrier{}
+	otel.GetTextMapPropagator().Inject(ctx, carrier)
+	return carrier
+}
@@ -3,18 +3,19 @@ package queue
 import (
 	"context"
 	"encoding/json"
-	"log"
+	"log/slog"
 	"time"
 
 	amqp "github.com/rabbitmq/amqp091-go"
 )
 
-type Queue struct {
+// RabbitMQ publishes tasks to a durable queue named after the worker.
+type RabbitMQ struct {
 	conn *amqp.Connection
 	ch   *amqp.Channel
 }
 
-func New(url string) (*Queue, error) {
+func NewRabbitMQ(url string) (*RabbitMQ, error) {
 	conn, err := amqp.Dial(url)
 	if err != nil {
 		return nil, err
@@ -25,13 +26,13 @@ func New(url string) (*Queue, error) {
 		return nil, err
 	}
 
-	return &Queue{
+	return &RabbitMQ{
 		conn: conn,
 		ch:   ch,
 	}, nil
 }
 
-func (q *Queue) Close() {
+func (q *RabbitMQ) Close() {
 	if q.ch != nil {
 		q.ch.Close()
 	}
@@ -40,11 +41,11 @@ func (q *Queue) Close() {
 	}
 }
 
-func (q *Queue) IsClosed() bool {
+func (q *RabbitMQ) IsClosed() bool {
 	return q.conn.IsClosed()
 }
 
-func (q *Queue) PublishTask(queueName string, taskID string, payload json.RawMessage) error {
+func (q *RabbitMQ) PublishTask(ctx context.Context, queueName string, taskID string, payload json.RawMessage) error {
 	// Ensure queue exists
 	_, err := q.ch.QueueDeclare(
 		queueName, // name
@@ -58,15 +59,17 @@ func (q *Queue) PublishTask(queueName string, taskID string, payload json.RawMes
 		return err
 	}
 
-	body, err := json.Marshal(map[string]interface{}{
-		"id":      taskID,
-		"payload": payload,
-	})
+	body, err := encodeMessage(taskID, payload)
 	if err != nil {
 		return err
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	headers := amqp.Table{}
+	for k, v := range traceHeaders(ctx) {
+		headers[k] = v
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
 	err = q.ch.PublishWithContext(ctx,
@@ -76,12 +79,42 @@ func (q *Queue) PublishTask(queueName string, taskID string, payload json.RawMes
 		false,     // immediate
 		amqp.Publishing{
 			ContentType: "application/json",
+			Headers:     headers,
 			Body:        body,
 		})
 	if err != nil {
-		log.Printf("Failed to publish message: %v", err)
+		slog.ErrorContext(ctx, "Failed to publish message", "task_id", taskID, "queue", queueName, "error", err)
 		return err
 	}
-	log.Printf("Published task %s to queue %s", taskID, queueName)
+	slog.InfoContext(ctx, "Published task", "task_id", taskID, "queue", queueName)
 	return nil
 }
+
+func (q *RabbitMQ) Receive(ctx context.Context, queueName string) (*Message, error) {
+	// Getting from a missing queue closes the channel, so declare it first
+	_, err := q.ch.QueueDeclare(queueName, true, false, false, false, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		d, ok, err := q.ch.Get(queueName, true)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			var msg Message
+			if err := json.Unmarshal(d.Body, &msg); err != nil {
+				slog.WarnContext(ctx, "Dropping malformed message", "queue", queueName, "error", err)
+				continue
+			}
+			return &msg, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, nil
+		case <-time.After(pollInterval):
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Redis publishes tasks to Redis Streams. Every worker gets the stream
+// "<prefix>:<worker>" with a consumer group that workers read through.
+type Redis struct {
+	client *redis.Client
+	prefix string
+	group  string
+	maxLen int64
+	groups sync.Map // worker name -> struct{}, groups already created
+}
+
+// NewRedis connects to the server at url (redis://...). Streams are trimmed
+// to roughly maxLen entries on every XADD; zero disables trimming.
+func NewRedis(url string, streamPrefix string, group string, maxLen int64) (*Redis, error) {
+	opts, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, err
+	}
+	client := redis.NewClient(opts)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return nil, err
+	}
+
+	return &Redis{
+		client: client,
+		prefix: streamPrefix,
+		group:  group,
+		maxLen: maxLen,
+	}, nil
+}
+
+// redisConsumer is the consumer name the API reads with inside the group.
+const redisConsumer = "task-api"
+
+func (q *Redis) Close() {
+	if q.client != nil {
+		q.client.Close()
+	}
+}
+
+// IsClosed pings the server, so /readyz reflects whether Redis answers.
+func (q *Redis) IsClosed() bool {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	return q.client.Ping(ctx).Err() != nil
+}
+
+// Stream returns the stream key tasks for the given worker are added to.
+func (q *Redis) Stream(queueName string) string {
+	return q.prefix + ":" + queueName
+}
+
+func (q *Redis) ensureGroup(ctx context.Context, queueName string) error {
+	if _, ok := q.groups.Load(queueName); ok {
+		return nil
+	}
+	err := q.client.XGroupCreateMkStream(ctx, q.Stream(queueName), q.group, "0").Err()
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return err
+	}
+	q.groups.Store(queueName, struct{}{})
+	return nil
+}
+
+func (q *Redis) PublishTask(ctx context.Context, queueName string, taskID string, payload json.RawMessage) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	// Ensure stream and consumer group exist
+	if err := q.ensureGroup(ctx, queueName); err != nil {
+		return err
+	}
+
+	body, err := encodeMessage(taskID, payload)
+	if err != nil {
+		return err
+	}
+
+	// Trace context travels in extra fields next to the body
+	values := map[string]interface{}{"body": body}
+	for k, v := range traceHeaders(ctx) {
+		values[k] = v
+	}
+
+	err = q.client.XAdd(ctx, &redis.XAddArgs{
+		Stream: q.Stream(queueName),
+		MaxLen: q.maxLen,
+		Approx: true,
+		Values: values,
+	}).Err()
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to publish message", "task_id", taskID, "stream", q.Stream(queueName), "error", err)
+		return err
+	}
+	slog.InfoContext(ctx, "Published task", "task_id", taskID, "stream", q.Stream(queueName))
+	return nil
+}
+
+func (q *Redis) Receive(ctx context.Context, queueName string) (*Message, error) {
+	// Set-up must not fail because there is little time left to wait
+	setupCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+	err := q.ensureGroup(setupCtx, queueName)
+	cancel()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		block := time.Duration(-1) // no BLOCK argument, return at once
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) >= time.Millisecond {
+			block = time.Until(deadline)
+		}
+
+		streams, err := q.client.XReadGroup(ctx, &redis.XReadGroupArgs{
+			Group:    q.group,
+			Consumer: redisConsumer,
+			Streams:  []string{q.Stream(queueName), ">"},
+			Count:    1,
+			Block:    block,
+		}).Result()
+		if err == redis.Nil || ctx.Err() != nil {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		for _, stream := range streams {
+			for _, entry := range stream.Messages {
+				if err := q.client.XAck(ctx, stream.Stream, q.group, entry.ID).Err(); err != nil {
+					return nil, err
+				}
+				body, _ := entry.Values["body"].(string)
+				var msg Message
+				if err := json.Unmarshal([]byte(body), &msg); err != nil {
+					slog.WarnContext(ctx, "Dropping malformed entry", "entry_id", entry.ID, "stream", stream.Stream, "error", err)
+					continue
+				}
+				return &msg, nil
+			}
+		}
+		if block < 0 {
+			return nil, nil
+		}
+	}
+}
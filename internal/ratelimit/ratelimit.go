@@ -0,0 +1,119 @@
+// Package ratelimit keeps one token bucket per key, e.g. per worker or per
+// client, in memory. Each API replica limits on its own.
+package ratelimit
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Rate allows Count events per Per, in bursts of up to Count. The zero Rate
+// is unlimited.
+type Rate struct {
+	Count int
+	Per   time.Duration
+}
+
+// ParseRate reads "<count>/<duration>", e.g. "100/1s" or "6000/1m". A unit
+// alone means one of it, so "100/s" is "100/1s". An empty string or a count
+// of 0 is unlimited.
+func ParseRate(s string) (Rate, error) {
+	if s == "" {
+		return Rate{}, nil
+	}
+	count, per, ok := strings.Cut(s, "/")
+	if !ok {
+		return Rate{}, fmt.Errorf("rate %q is not <count>/<duration>", s)
+	}
+	n, err := strconv.Atoi(count)
+	if err != nil || n < 0 {
+		return Rate{}, fmt.Errorf("invalid count in rate %q", s)
+	}
+	if per != "" && (per[0] < '0' || per[0] > '9') {
+		per = "1" + per
+	}
+	d, err := time.ParseDuration(per)
+	if err != nil || d <= 0 {
+		return Rate{}, fmt.Errorf("invalid duration in rate %q", s)
+	}
+	return Rate{Count: n, Per: d}, nil
+}
+
+// Unlimited reports whether the rate lets everything through.
+func (r Rate) Unlimited() bool {
+	return r.Count == 0
+}
+
+func (r Rate) String() string {
+	return fmt.Sprintf("%d/%s", r.Count, r.Per)
+}
+
+// sweepInterval is how often idle buckets are dropped.
+const sweepInterval = time.Minute
+
+// Limiter is a set of token buckets that share one Rate.
+type Limiter struct {
+	limit rate.Limit
+	burst int
+
+	mu        sync.Mutex
+	buckets   map[string]*rate.Limiter
+	lastSweep time.Time
+}
+
+// New returns a limiter, or nil for an unlimited rate. A nil Limiter
+// allows everything.
+func New(r Rate) *Limiter {
+	if r.Unlimited() {
+		return nil
+	}
+	return &Limiter{
+		limit:     rate.Every(r.Per / time.Duration(r.Count)),
+		burst:     r.Count,
+		buckets:   map[string]*rate.Limiter{},
+		lastSweep: time.Now(),
+	}
+}
+
+// Allow takes a token from the bucket of key. If there is none it takes
+// nothing and returns how long until one is available.
+func (l *Limiter) Allow(key string) (bool, time.Duration) {
+	if l == nil {
+		return true, 0
+	}
+	now := time.Now()
+
+	l.mu.Lock()
+	b, ok := l.buckets[key]
+	if !ok {
+		b = rate.NewLimiter(l.limit, l.burst)
+		l.buckets[key] = b
+	}
+	if now.Sub(l.lastSweep) > sweepInterval {
+		l.sweep(now)
+	}
+	l.mu.Unlock()
+
+	res := b.ReserveN(now, 1)
+	if delay := res.DelayFrom(now); delay > 0 {
+		res.CancelAt(now)
+		return false, delay
+	}
+	return true, 0
+}
+
+// sweep drops buckets that have refilled completely, which behave exactly
+// like new ones. l.mu must be held.
+func (l *Limiter) sweep(now time.Time) {
+	for key, b := range l.buckets {
+		if b.TokensAt(now) >= float64(l.burst) {
+			delete(l.buckets, key)
+		}
+	}
+	l.lastSweep = now
+}
@@ -0,0 +1,173 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"net/http"
+	"task-api/internal/auth"
+	"task-api/internal/logging"
+	"task-api/internal/storage"
+)
+
+// anonymous is used for calls without credentials while authentication
+// has been turned off with AUTH_REQUIRED=false. It may do anything, but
+// only in the default namespace, so tenants stay isolated.
+var anonymous = &auth.Principal{Namespace: storage.DefaultNamespace, Admin: true}
+
+// Authenticate resolves the credentials of a call, the value of its
+// Authorization header, to a principal. Without credentials the call is
+// rejected, unless authentication was turned off, in which case it is made
+// as anonymous. Credentials that are sent are always checked.
+func (s *Service) Authenticate(ctx context.Context, authorization string) (*auth.Principal, *Error) {
+	if authorization == "" {
+		if s.opts.AuthRequired {
+			return nil, unauthorized("Missing API key or token")
+		}
+		return anonymous, nil
+	}
+
+	token, ok := auth.BearerToken(authorization)
+	if !ok {
+		return nil, unauthorized("Authorization must be a Bearer API key or token")
+	}
+
+	if s.opts.Tokens != nil && auth.IsJWT(token) {
+		p, err := s.opts.Tokens.Verify(token)
+		if err != nil {
+			slog.InfoContext(ctx, "Rejected token", "error", err)
+			return nil, unauthorized("Invalid token")
+		}
+		logging.Add(ctx, "subject", p.Subject)
+		if p.Namespace == "" && !p.Admin {
+			// Only admins may pick a namespace per call
+			p.Namespace = storage.DefaultNamespace
+		}
+		return p, nil
+	}
+
+	p, err := s.principal(token)
+	if err == sql.ErrNoRows {
+		return nil, unauthorized("Invalid API key")
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "Error looking up API key", "error", err)
+		return nil, ErrInternal
+	}
+	if p.KeyID != "" {
+		logging.Add(ctx, "key_id", p.KeyID)
+	}
+	return p, nil
+}
+
+// Namespace returns the namespace a call of p runs in: the one p is
+// limited to, else the requested one, else the default one.
+func Namespace(p *auth.Principal, requested string) (string, *Error) {
+	switch {
+	case p.Namespace != "":
+		if requested != "" && requested != p.Namespace {
+			return "", NewError(http.StatusForbidden, CodeForbidden, "Credentials are limited to namespace "+p.Namespace)
+		}
+		return p.Namespace, nil
+	case requested == "":
+		return storage.DefaultNamespace, nil
+	case !storage.ValidNamespace(requested):
+		return "", NewError(http.StatusBadRequest, CodeInvalidNamespace, "Invalid namespace")
+	}
+	return requested, nil
+}
+
+// principal returns who the key belongs to, or sql.ErrNoRows for unknown
+// and revoked keys.
+func (s *Service) principal(key string) (*auth.Principal, error) {
+	if s.opts.AdminKey != "" && auth.EqualKeys(key, s.opts.AdminKey) {
+		return &auth.Principal{Admin: true}, nil
+	}
+
+	k, err := s.store.GetAPIKeyByHash(auth.HashKey(key))
+	if err != nil {
+		return nil, err
+	}
+	var ns string
+	if k.Namespace != nil {
+		ns = *k.Namespace
+	}
+	if k.Admin {
+		return &auth.Principal{KeyID: k.ID, Namespace: ns, Admin: true}, nil
+	}
+	return auth.WorkerPrincipal(k.ID, ns, *k.Worker, k.Delegates), nil
+}
+
+func unauthorized(message string) *Error {
+	return NewError(http.StatusUnauthorized, CodeUnauthorized, message)
+}
+
+func forbidden(message string) *Error {
+	return NewError(http.StatusForbidden, CodeForbidden, message)
+}
+
+// CheckWorker checks that the caller may act as the given worker: claim
+// its tasks and finish them.
+func CheckWorker(ctx context.Context, worker string) *Error {
+	if !auth.FromContext(ctx).CanActAs(worker) {
+		return forbidden("Not allowed to act for this worker")
+	}
+	return nil
+}
+
+// checkTaskOwner checks that the caller may finish the task with the given
+// id.
+func (s *Service) checkTaskOwner(ctx context.Context, namespace string, id string) *Error {
+	p := auth.FromContext(ctx)
+	if p.Admin {
+		return nil
+	}
+
+	t, err := s.store.GetTask(namespace, id)
+	if err == sql.ErrNoRows || err == storage.ErrInvalidID {
+		return errTaskNotFound
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "Error fetching task", "error", err)
+		return ErrInternal
+	}
+	if !p.CanActAs(t.Worker) {
+		return forbidden("Not allowed to act for this worker")
+	}
+	return nil
+}
+
+// manageable loads a task the caller may cancel or retry: whoever may
+// finish it. Creating tasks for its worker is not enough, as cancelling
+// reaches into the whole subtree and retrying runs the task again.
+func (s *Service) manageable(ctx context.Context, namespace string, id string) (*storage.Task, *Error) {
+	t, err := s.store.GetTask(namespace, id)
+	if err == sql.ErrNoRows || err == storage.ErrInvalidID {
+		return nil, errTaskNotFound
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "Error fetching task", "error", err)
+		return nil, ErrInternal
+	}
+	if !auth.FromContext(ctx).CanActAs(t.Worker) {
+		return nil, forbidden("Not allowed to act for this worker")
+	}
+	return t, nil
+}
+
+// watchable loads a task the caller may follow: whoever may finish it or
+// create tasks for its worker.
+func (s *Service) watchable(ctx context.Context, namespace string, id string) (*storage.Task, *Error) {
+	t, err := s.store.GetTask(namespace, id)
+	if err == sql.ErrNoRows || err == storage.ErrInvalidID {
+		return nil, errTaskNotFound
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "Error fetching task", "error", err)
+		return nil, ErrInternal
+	}
+	if !auth.FromContext(ctx).CanWatch(t.Worker) {
+		return nil, forbidden("Not allowed to follow tasks of this worker")
+	}
+	return t, nil
+}
@@ -0,0 +1,88 @@
+package service
+
+import (
+	"net/http"
+	"time"
+)
+
+// Error codes returned in the "code" field of error responses. Clients
+// match on these; messages may change.
+const (
+	CodeInternal             = "internal_error"
+	CodeInvalidBody          = "invalid_body"
+	CodeNotFound             = "not_found"
+	CodeMethodNotAllowed     = "method_not_allowed"
+	CodeDatabaseUnavailable  = "database_unavailable"
+	CodeQueueUnavailable     = "queue_unavailable"
+	CodeUnauthorized         = "unauthorized"
+	CodeForbidden            = "forbidden"
+	CodeInvalidNamespace     = "invalid_namespace"
+	CodeQuotaExceeded        = "pending_quota_exceeded"
+	CodeWorkerPendingLimit   = "worker_pending_limit"
+	CodeRateLimited          = "rate_limited"
+	CodeWorkerNameRequired   = "worker_name_required"
+	CodeWorkerNotFound       = "worker_not_found"
+	CodeTaskNotFound         = "task_not_found"
+	CodeTaskAlreadyCompleted = "task_already_completed"
+	CodeTaskHasNoChildren    = "task_has_no_children"
+	CodeInvalidParentID      = "invalid_parent_id"
+	CodeParentNotFound       = "parent_not_found"
+	CodeParentCompleted      = "parent_completed"
+	CodeParentFailed         = "parent_failed"
+	CodeTreeDepthExceeded    = "tree_depth_exceeded"
+	CodeTreeSizeExceeded     = "tree_size_exceeded"
+	CodeInvalidCallbackURL   = "invalid_callback_url"
+	CodeInvalidCallbackEvent = "invalid_callback_event"
+	CodeInvalidStatus        = "invalid_status"
+	CodeInvalidPageToken     = "invalid_page_token"
+	CodeInvalidOrder         = "invalid_order"
+	CodeQueuePublishFailed   = "queue_publish_failed"
+	CodeErrorRequired        = "error_required"
+	CodeInvalidLimit         = "invalid_limit"
+	CodeInvalidWait          = "invalid_wait"
+	CodeInvalidPrefetch      = "invalid_prefetch"
+	CodeInvalidMessageType   = "invalid_message_type"
+	CodeClaimUnavailable     = "claim_unavailable"
+	CodePullUnavailable      = "pull_unavailable"
+	CodeEventsUnavailable    = "events_unavailable"
+	CodeWebhooksUnavailable  = "webhooks_unavailable"
+	CodeInvalidSubtree       = "invalid_subtree"
+	CodeProgressTooLarge     = "progress_too_large"
+	CodeInvalidFormat        = "invalid_format"
+	CodeInvalidDurations     = "invalid_durations"
+
+	// Idempotency-Key of task creates
+	CodeInvalidIdempotencyKey = "invalid_idempotency_key"
+	CodeIdempotencyKeyReused  = "idempotency_key_reused"
+
+	// Operator endpoints
+	CodeTaskNotRetryable  = "task_not_retryable"
+	CodeInvalidRootID     = "invalid_root_id"
+	CodeInvalidWorkerName = "invalid_worker_name"
+)
+
+// Error is a failed call, described the way the HTTP API answers it.
+// Every frontend translates it for its own protocol.
+type Error struct {
+	// Status is the HTTP status of the error.
+	Status  int
+	Code    string
+	Message string
+	// RetryAfter says when the call may succeed again, if known.
+	RetryAfter time.Duration
+}
+
+func (e *Error) Error() string {
+	return e.Code + ": " + e.Message
+}
+
+// NewError returns an Error with the given HTTP status and code.
+func NewError(status int, code string, message string) *Error {
+	return &Error{Status: status, Code: code, Message: message}
+}
+
+// ErrInternal is the generic 500. Details are logged where it is returned.
+var ErrInternal = NewError(http.StatusInternalServerError, CodeInternal, "Internal Server Error")
+
+// errTaskNotFound is returned for unknown tasks and ids that are not UUIDs.
+var errTaskNotFound = NewError(http.StatusNotFound, CodeTaskNotFound, "Task not found")
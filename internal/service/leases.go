@@ -0,0 +1,92 @@
+package service
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"task-api/internal/logging"
+	"task-api/internal/queue"
+	"task-api/internal/tracing"
+	"time"
+)
+
+// RunLeaseReaper queues again every task whose lease expired before it was
+// completed or created subtasks, checking at the given interval until ctx
+// is cancelled. Several replicas may run it at once; each expired lease is
+// re-queued only once.
+func (s *Service) RunLeaseReaper(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		tasks, err := s.store.ExpireLeases()
+		if err != nil {
+			slog.Error("Error expiring leases", "error", err)
+			continue
+		}
+		for _, t := range tasks {
+			slog.Info("Lease expired, re-queueing task", "task_id", t.ID, "namespace", t.Namespace, "worker", t.Worker)
+			taskCtx := tracing.FromTraceparent(ctx, t.TraceContext)
+			if err := s.publish(taskCtx, t.Namespace, t.Worker, t.ID, t.Payload); err != nil {
+				slog.Error("Error re-queueing task", "task_id", t.ID, "namespace", t.Namespace, "worker", t.Worker, "error", err)
+			}
+		}
+	}
+}
+
+// NextTask takes the next message off the queue of the worker and leases
+// its task for Options.Lease, so that it is queued again if the worker
+// does not finish it in time. It returns nil if ctx ends before a task
+// arrives. The caller checks that the principal may act as the worker.
+func (s *Service) NextTask(ctx context.Context, namespace string, worker string) (*queue.Message, *Error) {
+	receiver, ok := s.queue.(queue.Receiver)
+	if !ok {
+		return nil, NewError(http.StatusConflict, CodePullUnavailable, "Pulling is not supported by the configured broker")
+	}
+
+	for {
+		msg, err := receiver.Receive(ctx, queue.Name(namespace, worker))
+		if err != nil {
+			slog.ErrorContext(ctx, "Error receiving task", "error", err)
+			return nil, ErrInternal
+		}
+		if msg == nil {
+			return nil, nil
+		}
+
+		logging.Add(ctx, "task_id", msg.ID)
+		leased, err := s.store.LeaseTask(msg.ID, msg.Payload, s.opts.Lease)
+		if err != nil {
+			slog.ErrorContext(ctx, "Error leasing task", "error", err)
+			// Put it back, the message is already off the queue
+			if err := s.publish(ctx, namespace, worker, msg.ID, msg.Payload); err != nil {
+				slog.ErrorContext(ctx, "Error re-publishing task", "error", err)
+			}
+			return nil, ErrInternal
+		}
+		if !leased {
+			// Stale message for a task completed in the meantime
+			continue
+		}
+		return msg, nil
+	}
+}
+
+// RenewLeases extends the leases of the given tasks by Options.Lease and
+// returns the ids of those still leased.
+func (s *Service) RenewLeases(ids []string) ([]string, error) {
+	return s.store.RenewLeases(ids, s.opts.Lease)
+}
+
+// ReleaseLeases lets the leases of the given unfinished tasks run out, so
+// that the lease reaper queues them again without waiting for the full
+// lease.
+func (s *Service) ReleaseLeases(ids []string) error {
+	return s.store.ReleaseLeases(ids)
+}
@@ -0,0 +1,92 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"task-api/internal/metrics"
+	"task-api/internal/storage"
+	"time"
+)
+
+// pendingRetryAfter is the Retry-After sent when a pending task cap is
+// reached. Unlike a rate limit there is no way to know when a task will
+// complete, so it only paces the retries.
+const pendingRetryAfter = 10 * time.Second
+
+// tooManyRequests returns a 429 telling the client to retry after d, and
+// counts the rejection.
+func tooManyRequests(namespace string, reason string, d time.Duration, code string, message string) *Error {
+	metrics.CreateRejected.WithLabelValues(namespace, reason).Inc()
+	return &Error{Status: http.StatusTooManyRequests, Code: code, Message: message, RetryAfter: d}
+}
+
+// checkClientRate takes a token from the bucket of the client.
+func (s *Service) checkClientRate(namespace string, client string) *Error {
+	if ok, delay := s.clientLimiter.Allow(client); !ok {
+		return tooManyRequests(namespace, "client_rate", delay, CodeRateLimited, "Too many tasks created by this client")
+	}
+	return nil
+}
+
+// checkWorkerRate takes a token from the bucket of the target worker.
+func (s *Service) checkWorkerRate(namespace string, worker string) *Error {
+	if ok, delay := s.workerLimiter.Allow(namespace + "/" + worker); !ok {
+		return tooManyRequests(namespace, "worker_rate", delay, CodeRateLimited, "Too many tasks created for this worker")
+	}
+	return nil
+}
+
+// checkWorkerPending makes sure the worker may have another incomplete
+// task. Concurrent requests may overshoot the cap slightly.
+func (s *Service) checkWorkerPending(ctx context.Context, namespace string, worker string) *Error {
+	limit := s.opts.MaxPendingPerWorker
+	if limit <= 0 {
+		return nil
+	}
+	pending, err := s.store.WorkerPendingCount(namespace, worker)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error counting pending tasks", "error", err)
+		return ErrInternal
+	}
+	if pending >= limit {
+		return tooManyRequests(namespace, "worker_pending", pendingRetryAfter, CodeWorkerPendingLimit,
+			fmt.Sprintf("Worker has %d pending tasks, the limit is %d", pending, limit))
+	}
+	return nil
+}
+
+// checkTree makes sure a child of parent stays within the tree limits.
+// Concurrent requests may overshoot the size limit slightly.
+func (s *Service) checkTree(ctx context.Context, parent *storage.Task) *Error {
+	if max := s.opts.MaxTreeDepth; max > 0 && parent.Depth+1 > max {
+		metrics.CreateRejected.WithLabelValues(parent.Namespace, "tree_depth").Inc()
+		return NewError(http.StatusConflict, CodeTreeDepthExceeded,
+			fmt.Sprintf("Task would be at depth %d, the limit is %d", parent.Depth+1, max))
+	}
+
+	max := s.opts.MaxTreeSize
+	if max <= 0 {
+		return nil
+	}
+	size, err := s.store.TreeSize(rootOf(parent))
+	if err != nil {
+		slog.ErrorContext(ctx, "Error counting tree size", "error", err)
+		return ErrInternal
+	}
+	if size >= max {
+		metrics.CreateRejected.WithLabelValues(parent.Namespace, "tree_size").Inc()
+		return NewError(http.StatusConflict, CodeTreeSizeExceeded,
+			fmt.Sprintf("Task tree has %d tasks, the limit is %d", size, max))
+	}
+	return nil
+}
+
+// rootOf returns the id of the root of the task's tree.
+func rootOf(t *storage.Task) string {
+	if t.RootID != nil {
+		return *t.RootID
+	}
+	return t.ID
+}
@@ -0,0 +1,142 @@
+package service
+
+import (
+	"context"
+	"encoding/base64"
+	"log/slog"
+	"net/http"
+	"strings"
+	"task-api/internal/auth"
+	"task-api/internal/logging"
+	"task-api/internal/storage"
+	"time"
+)
+
+// GetTask returns the task with the given id, if the principal of ctx may
+// follow it.
+func (s *Service) GetTask(ctx context.Context, namespace string, id string) (*storage.Task, *Error) {
+	logging.Add(ctx, "task_id", id)
+	if !ValidID(id) {
+		return nil, errTaskNotFound
+	}
+	return s.watchable(ctx, namespace, id)
+}
+
+// Page sizes of ListTasks.
+const (
+	defaultPageSize = 50
+	maxPageSize     = 100
+)
+
+// TaskQuery selects the tasks ListTasks returns. Empty fields match any
+// task.
+type TaskQuery struct {
+	Worker   string
+	Status   string
+	ParentID string
+	// RootID selects the descendants of a root task.
+	RootID string
+	// Order is "oldest", the default, or "newest" first. A PageToken
+	// continues in the order it was returned for.
+	Order string
+	// PageSize is at most 100, by default 50.
+	PageSize int
+	// PageToken continues the listing that returned it.
+	PageToken string
+}
+
+// ListTasks returns the tasks of the namespace that match q in q.Order,
+// with the token of the next page, empty on the last one. Callers other
+// than admins must name a worker whose tasks they may follow.
+func (s *Service) ListTasks(ctx context.Context, namespace string, q *TaskQuery) ([]*storage.Task, string, *Error) {
+	if q.Worker == "" {
+		if !auth.FromContext(ctx).Admin {
+			return nil, "", forbidden("Only admins may list tasks of every worker")
+		}
+	} else if !auth.FromContext(ctx).CanWatch(q.Worker) {
+		return nil, "", forbidden("Not allowed to follow tasks of this worker")
+	}
+
+	switch q.Status {
+	case "", storage.StatusPending, storage.StatusCompleted, storage.StatusFailed, storage.StatusCancelled:
+	default:
+		return nil, "", NewError(http.StatusBadRequest, CodeInvalidStatus, "status must be pending, completed, failed or cancelled")
+	}
+	if q.ParentID != "" && !ValidID(q.ParentID) {
+		return nil, "", NewError(http.StatusBadRequest, CodeInvalidParentID, "parent_id is not a valid UUID")
+	}
+	if q.RootID != "" && !ValidID(q.RootID) {
+		return nil, "", NewError(http.StatusBadRequest, CodeInvalidRootID, "root_id is not a valid UUID")
+	}
+	if q.Order != "" && q.Order != "oldest" && q.Order != "newest" {
+		return nil, "", NewError(http.StatusBadRequest, CodeInvalidOrder, "order must be oldest or newest")
+	}
+	if q.PageSize < 0 || q.PageSize > maxPageSize {
+		return nil, "", NewError(http.StatusBadRequest, CodeInvalidLimit, "page_size must be between 1 and 100")
+	}
+
+	f := storage.TaskFilter{Worker: q.Worker, Status: q.Status, ParentID: q.ParentID, RootID: q.RootID, Newest: q.Order == "newest", Limit: q.PageSize}
+	if f.Limit == 0 {
+		f.Limit = defaultPageSize
+	}
+	if q.PageToken != "" {
+		var ok bool
+		if f.AfterCreatedAt, f.AfterID, ok = parsePageToken(q.PageToken); !ok {
+			return nil, "", NewError(http.StatusBadRequest, CodeInvalidPageToken, "Invalid page token")
+		}
+	}
+
+	// One more than asked tells whether there is a next page
+	f.Limit++
+	tasks, err := s.store.ListTasks(namespace, f)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error listing tasks", "error", err)
+		return nil, "", ErrInternal
+	}
+	if len(tasks) < f.Limit {
+		return tasks, "", nil
+	}
+	tasks = tasks[:f.Limit-1]
+	last := tasks[len(tasks)-1]
+	return tasks, pageToken(last.CreatedAt, last.ID), nil
+}
+
+// TaskTree returns the task with the given id and all its descendants,
+// parents before children, if the principal of ctx may follow the task.
+func (s *Service) TaskTree(ctx context.Context, namespace string, id string) ([]*storage.Task, *Error) {
+	logging.Add(ctx, "task_id", id)
+	if !ValidID(id) {
+		return nil, errTaskNotFound
+	}
+	if _, e := s.watchable(ctx, namespace, id); e != nil {
+		return nil, e
+	}
+	tasks, err := s.store.Subtree(namespace, id)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error fetching subtree", "error", err)
+		return nil, ErrInternal
+	}
+	return tasks, nil
+}
+
+// pageToken encodes where a listing stopped. Clients treat it as opaque.
+func pageToken(createdAt time.Time, id string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(createdAt.UTC().Format(time.RFC3339Nano) + "|" + id))
+}
+
+// parsePageToken decodes a token from pageToken.
+func parsePageToken(token string) (time.Time, string, bool) {
+	b, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return time.Time{}, "", false
+	}
+	at, id, ok := strings.Cut(string(b), "|")
+	if !ok || !ValidID(id) {
+		return time.Time{}, "", false
+	}
+	t, err := time.Parse(time.RFC3339Nano, at)
+	if err != nil {
+		return time.Time{}, "", false
+	}
+	return t, id, true
+}
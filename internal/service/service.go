@@ -0,0 +1,73 @@
+// Package service holds the task logic shared by the HTTP API, the
+// WebSocket gateway and the gRPC server: creating tasks within the limits,
+// finishing them and re-queueing parents with their children's results,
+// webhooks, leases and following tasks as they change. Frontends resolve
+// the caller with Authenticate and pass its namespace explicitly; the
+// principal travels in the context (see auth.NewContext).
+package service
+
+import (
+	"task-api/internal/auth"
+	"task-api/internal/events"
+	"task-api/internal/queue"
+	"task-api/internal/ratelimit"
+	"task-api/internal/storage"
+	"time"
+)
+
+// Options tune the service behaviour.
+type Options struct {
+	// Lease is how long a task handed out to a worker that does not
+	// consume the broker may stay incomplete before it is queued again.
+	Lease time.Duration
+	// ForbidCompletedParent rejects new children of completed tasks.
+	ForbidCompletedParent bool
+	// AuthRequired rejects calls without credentials. When false they are
+	// made as an admin of the default namespace.
+	AuthRequired bool
+	// AdminKey is an admin API key accepted without being stored.
+	AdminKey string
+	// Tokens verifies JWT bearer tokens. Nil accepts API keys only.
+	Tokens *auth.TokenVerifier
+	// MaxPending caps the incomplete tasks of a namespace that has no
+	// quota of its own. Zero means no cap.
+	MaxPending int
+	// WorkerRate limits task creation per target worker, ClientRate per
+	// caller. The zero Rate is unlimited.
+	WorkerRate ratelimit.Rate
+	ClientRate ratelimit.Rate
+	// MaxPendingPerWorker caps the incomplete tasks of each worker. Zero
+	// means no cap.
+	MaxPendingPerWorker int
+	// MaxTreeDepth and MaxTreeSize bound the trees built through
+	// parent_id: how far below its root a task may be, and how many tasks
+	// a tree may hold. Zero means no bound.
+	MaxTreeDepth int
+	MaxTreeSize  int
+	// Webhooks accepts callback URLs on creates. It is off while there is
+	// no secret to sign webhooks with.
+	Webhooks bool
+	// Events streams task events to watchers. Nil disables watching and
+	// waiting for results.
+	Events *events.Hub
+}
+
+type Service struct {
+	store *storage.Storage
+	queue queue.Queue
+	opts  Options
+
+	workerLimiter *ratelimit.Limiter
+	clientLimiter *ratelimit.Limiter
+}
+
+func New(store *storage.Storage, queue queue.Queue, opts Options) *Service {
+	return &Service{
+		store: store,
+		queue: queue,
+		opts:  opts,
+
+		workerLimiter: ratelimit.New(opts.WorkerRate),
+		clientLimiter: ratelimit.New(opts.ClientRate),
+	}
+}
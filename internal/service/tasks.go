@@ -0,0 +1,641 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"reflect"
+	"regexp"
+	"task-api/internal/auth"
+	"task-api/internal/logging"
+	"task-api/internal/metrics"
+	"task-api/internal/queue"
+	"task-api/internal/storage"
+	"task-api/internal/tracing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// UUIDPattern matches task ids, in routes and in request bodies.
+const UUIDPattern = `[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}`
+
+var uuidRegexp = regexp.MustCompile(`^` + UUIDPattern + `$`)
+
+// ValidID reports whether id has the shape of a task id.
+func ValidID(id string) bool {
+	return uuidRegexp.MatchString(id)
+}
+
+// NewTask describes a task to create.
+type NewTask struct {
+	Worker   string
+	ParentID *string
+	Payload  json.RawMessage
+	// CallbackURL receives a webhook for each of CallbackEvents, by
+	// default completed and failed.
+	CallbackURL    *string
+	CallbackEvents []string
+	// Client identifies the caller for the client rate limit.
+	Client string
+	// IdempotencyKey, if set, makes a repeated create with the same key
+	// return the task of the first one instead of creating another.
+	IdempotencyKey string
+}
+
+// maxIdempotencyKey bounds the length of idempotency keys.
+const maxIdempotencyKey = 255
+
+// CreateTask validates, stores and publishes a task in the namespace, if
+// the principal of ctx may create tasks for its worker. ctx carries the
+// caller's trace, if any.
+func (s *Service) CreateTask(ctx context.Context, namespace string, req *NewTask) (string, *Error) {
+	if req.Worker == "" {
+		return "", NewError(http.StatusBadRequest, CodeWorkerNameRequired, "Worker name is required")
+	}
+	logging.Add(ctx, "worker", req.Worker)
+	if !auth.FromContext(ctx).CanCreateFor(req.Worker) {
+		return "", forbidden("Missing scope " + auth.CreateScope(req.Worker))
+	}
+	if len(req.IdempotencyKey) > maxIdempotencyKey {
+		return "", NewError(http.StatusBadRequest, CodeInvalidIdempotencyKey, "Idempotency-Key must be at most 255 characters")
+	}
+	// A retry does not count against limits the first attempt passed
+	if req.IdempotencyKey != "" {
+		if id, e := s.idempotentTask(ctx, namespace, req); id != "" || e != nil {
+			return id, e
+		}
+	}
+
+	if e := s.checkClientRate(namespace, req.Client); e != nil {
+		return "", e
+	}
+	if e := s.CheckWorkerExists(ctx, namespace, req.Worker); e != nil {
+		return "", e
+	}
+	if e := s.checkWorkerRate(namespace, req.Worker); e != nil {
+		return "", e
+	}
+	if e := s.checkCallback(req); e != nil {
+		return "", e
+	}
+
+	var parent *storage.Task
+	if req.ParentID != nil {
+		var e *Error
+		if parent, e = s.checkParent(ctx, namespace, *req.ParentID); e != nil {
+			return "", e
+		}
+		if e := s.checkTree(ctx, parent); e != nil {
+			return "", e
+		}
+	}
+
+	if e := s.checkQuota(ctx, namespace); e != nil {
+		return "", e
+	}
+	if e := s.checkWorkerPending(ctx, namespace, req.Worker); e != nil {
+		return "", e
+	}
+
+	// Join the caller's trace, or the parent's when the caller sent none
+	if !tracing.HasSpan(ctx) && parent != nil && parent.TraceContext != "" {
+		ctx = tracing.FromTraceparent(ctx, parent.TraceContext)
+	}
+	ctx, span := tracing.Tracer.Start(ctx, "CreateTask", trace.WithAttributes(
+		attribute.String("task.worker", req.Worker),
+	))
+	defer span.End()
+	if req.ParentID != nil {
+		span.SetAttributes(attribute.String("task.parent_id", *req.ParentID))
+	}
+
+	task := &storage.Task{
+		Namespace:      namespace,
+		ParentID:       req.ParentID,
+		Worker:         req.Worker,
+		Payload:        req.Payload,
+		TraceContext:   tracing.Traceparent(ctx),
+		CallbackURL:    req.CallbackURL,
+		CallbackEvents: req.CallbackEvents,
+		IdempotencyKey: req.IdempotencyKey,
+	}
+	if parent != nil {
+		root := rootOf(parent)
+		task.RootID = &root
+		task.Depth = parent.Depth + 1
+	}
+
+	id, err := s.store.CreateTask(task)
+	if err == storage.ErrDuplicateIdempotencyKey {
+		// A concurrent attempt with the same key won
+		return s.idempotentTask(ctx, namespace, req)
+	}
+	if err == storage.ErrParentNotFound {
+		// Parent deleted since it was checked
+		return "", NewError(http.StatusNotFound, CodeParentNotFound, "Parent task does not exist")
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "Error creating task", "error", err)
+		recordError(span, err)
+		return "", ErrInternal
+	}
+	span.SetAttributes(attribute.String("task.id", id))
+	logging.Add(ctx, "task_id", id)
+
+	metrics.TasksCreated.WithLabelValues(namespace, req.Worker).Inc()
+
+	if err := s.publish(ctx, namespace, req.Worker, id, req.Payload); err != nil {
+		slog.ErrorContext(ctx, "Error publishing to queue", "error", err)
+		recordError(span, err)
+		// A retry with the same idempotency key queues it
+		if err := s.store.SetPublishFailed(id, true); err != nil {
+			slog.ErrorContext(ctx, "Error recording failed publish", "error", err)
+		}
+		return "", errPublishFailed
+	}
+	return id, nil
+}
+
+// errPublishFailed answers creates whose task was stored but not queued.
+var errPublishFailed = NewError(http.StatusInternalServerError, CodeQueuePublishFailed, "Task created but failed to queue")
+
+// errInvalidWorkerName answers worker names ValidWorker rejects.
+var errInvalidWorkerName = NewError(http.StatusBadRequest, CodeInvalidWorkerName, "Worker name must be 1 to 255 characters without dots")
+
+// CheckWorkerExists makes sure the worker is registered in the namespace.
+// Workers registered with a dot before names were checked are refused, as
+// their queue could be that of a worker in another namespace.
+func (s *Service) CheckWorkerExists(ctx context.Context, namespace string, worker string) *Error {
+	if !storage.ValidWorker(worker) {
+		return errInvalidWorkerName
+	}
+	exists, err := s.store.ValidateWorker(namespace, worker)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error validating worker", "error", err)
+		return ErrInternal
+	}
+	if !exists {
+		return NewError(http.StatusBadRequest, CodeWorkerNotFound, "Worker does not exist")
+	}
+	return nil
+}
+
+// checkParent loads the parent named in a create request and makes sure
+// children may still be attached to it.
+func (s *Service) checkParent(ctx context.Context, namespace string, parentID string) (*storage.Task, *Error) {
+	logging.Add(ctx, "parent_id", parentID)
+
+	if !ValidID(parentID) {
+		return nil, NewError(http.StatusBadRequest, CodeInvalidParentID, "parent_id is not a valid UUID")
+	}
+
+	parent, err := s.store.GetTask(namespace, parentID)
+	if err == sql.ErrNoRows {
+		return nil, NewError(http.StatusNotFound, CodeParentNotFound, "Parent task does not exist")
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "Error fetching parent", "error", err)
+		return nil, ErrInternal
+	}
+
+	switch parent.Status {
+	case storage.StatusFailed, storage.StatusCancelled:
+		return nil, NewError(http.StatusConflict, CodeParentFailed, "Parent task has "+parent.Status)
+	case storage.StatusCompleted:
+		if s.opts.ForbidCompletedParent {
+			return nil, NewError(http.StatusConflict, CodeParentCompleted, "Parent task is already completed")
+		}
+	}
+	return parent, nil
+}
+
+// checkQuota makes sure the namespace may have another incomplete task.
+// Concurrent requests may overshoot the quota slightly.
+func (s *Service) checkQuota(ctx context.Context, namespace string) *Error {
+	ns, err := s.store.GetNamespace(namespace)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error fetching namespace", "error", err)
+		return ErrInternal
+	}
+	limit := s.opts.MaxPending
+	if ns.MaxPending != nil {
+		limit = *ns.MaxPending
+	}
+	if limit <= 0 {
+		return nil
+	}
+
+	pending, err := s.store.NamespacePendingCount(namespace)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error counting pending tasks", "error", err)
+		return ErrInternal
+	}
+	if pending >= limit {
+		return tooManyRequests(namespace, "namespace_pending", pendingRetryAfter, CodeQuotaExceeded,
+			fmt.Sprintf("Namespace has %d pending tasks, the quota is %d", pending, limit))
+	}
+	return nil
+}
+
+// CompleteTask completes the task with the given id, if the principal of
+// ctx may, and re-queues its parent once all siblings are done.
+func (s *Service) CompleteTask(ctx context.Context, namespace string, id string, result json.RawMessage) *Error {
+	return s.finishTask(ctx, namespace, "CompleteTask", id, func() error {
+		return s.store.CompleteTask(namespace, id, result)
+	})
+}
+
+// FailTask marks a task as failed. The parent is re-queued the same way as
+// on completion, with the error in place of the child's result.
+func (s *Service) FailTask(ctx context.Context, namespace string, id string, message string) *Error {
+	if message == "" {
+		return NewError(http.StatusBadRequest, CodeErrorRequired, "error is required")
+	}
+	return s.finishTask(ctx, namespace, "FailTask", id, func() error {
+		return s.store.FailTask(namespace, id, message)
+	})
+}
+
+// idempotentTask returns the id of the task created earlier with the
+// idempotency key of req, or "" if there is none. The earlier task must be
+// for the same worker, parent and payload. If it could not be queued then,
+// it is queued now.
+func (s *Service) idempotentTask(ctx context.Context, namespace string, req *NewTask) (string, *Error) {
+	t, err := s.store.GetTaskByIdempotencyKey(namespace, req.IdempotencyKey)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "Error looking up idempotency key", "error", err)
+		return "", ErrInternal
+	}
+	sameParent := t.ParentID == nil && req.ParentID == nil ||
+		t.ParentID != nil && req.ParentID != nil && *t.ParentID == *req.ParentID
+	if t.Worker != req.Worker || !sameParent || !samePayload(t.Payload, req.Payload) {
+		return "", NewError(http.StatusConflict, CodeIdempotencyKeyReused, "Idempotency-Key was used for a different task")
+	}
+	if t.PublishFailed && !t.IsCompleted {
+		slog.InfoContext(ctx, "Queueing task of earlier create", "task_id", t.ID)
+		taskCtx := tracing.FromTraceparent(ctx, t.TraceContext)
+		if err := s.publish(taskCtx, namespace, t.Worker, t.ID, t.Payload); err != nil {
+			slog.ErrorContext(ctx, "Error publishing to queue", "task_id", t.ID, "error", err)
+			return "", errPublishFailed
+		}
+		if err := s.store.SetPublishFailed(t.ID, false); err != nil {
+			slog.ErrorContext(ctx, "Error recording publish", "task_id", t.ID, "error", err)
+		}
+		return t.ID, nil
+	}
+	slog.InfoContext(ctx, "Returning task of earlier create", "task_id", t.ID)
+	return t.ID, nil
+}
+
+// samePayload reports whether two payloads hold the same JSON value. The
+// stored one is JSONB, which does not keep spacing or key order.
+func samePayload(a, b json.RawMessage) bool {
+	var va, vb interface{}
+	if len(a) > 0 && json.Unmarshal(a, &va) != nil {
+		return false
+	}
+	if len(b) > 0 && json.Unmarshal(b, &vb) != nil {
+		return false
+	}
+	return reflect.DeepEqual(va, vb)
+}
+
+// finishTask runs finish, which moves the task to a terminal state, and then
+// takes care of the parent.
+func (s *Service) finishTask(ctx context.Context, namespace string, spanName string, id string, finish func() error) *Error {
+	logging.Add(ctx, "task_id", id)
+	if !ValidID(id) {
+		return errTaskNotFound
+	}
+	if e := s.checkTaskOwner(ctx, namespace, id); e != nil {
+		return e
+	}
+
+	// Join the caller's trace, or the one the task was created under
+	if !tracing.HasSpan(ctx) {
+		if tc, err := s.store.GetTraceContext(namespace, id); err == nil && tc != "" {
+			ctx = tracing.FromTraceparent(ctx, tc)
+		}
+	}
+	ctx, span := tracing.Tracer.Start(ctx, spanName, trace.WithAttributes(
+		attribute.String("task.id", id),
+	))
+	defer span.End()
+
+	// 1. Mark task as completed
+	err := finish()
+	if err != nil {
+		if e := finishError(err); e != nil {
+			return e
+		}
+		slog.ErrorContext(ctx, "Error completing task", "error", err)
+		recordError(span, err)
+		return ErrInternal
+	}
+
+	// 2. Check parent logic
+	t, err := s.store.GetTask(namespace, id)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error fetching task", "error", err)
+		return nil // Task completed, just couldn't fetch to check parent
+	}
+	observeFinished(t)
+	s.notify(ctx, t)
+	span.SetAttributes(attribute.String("task.worker", t.Worker))
+	logging.Add(ctx, "worker", t.Worker)
+	if t.ParentID != nil {
+		logging.Add(ctx, "parent_id", *t.ParentID)
+	}
+
+	s.requeueParentIfReady(ctx, t)
+	return nil
+}
+
+// finishError translates the storage errors of updates to incomplete
+// tasks, nil for any other error.
+func finishError(err error) *Error {
+	switch err {
+	case storage.ErrTaskAlreadyCompleted:
+		return NewError(http.StatusConflict, CodeTaskAlreadyCompleted, "Task already completed") // User requested error on duplicate
+	case storage.ErrTaskNotFound:
+		return errTaskNotFound
+	}
+	return nil
+}
+
+// defaultCancelReason is stored as the error of tasks cancelled without a
+// reason.
+const defaultCancelReason = "cancelled"
+
+// CancelTask cancels an incomplete task and all its incomplete
+// descendants, if the principal of ctx may finish the task. Cancelled
+// tasks count as finished, so the parent is re-queued as on failure. It
+// returns the ids of the cancelled tasks.
+func (s *Service) CancelTask(ctx context.Context, namespace string, id string, reason string) ([]string, *Error) {
+	logging.Add(ctx, "task_id", id)
+	if !ValidID(id) {
+		return nil, errTaskNotFound
+	}
+	t, e := s.manageable(ctx, namespace, id)
+	if e != nil {
+		return nil, e
+	}
+	if reason == "" {
+		reason = defaultCancelReason
+	}
+
+	// Join the caller's trace, or the one the task was created under
+	if !tracing.HasSpan(ctx) && t.TraceContext != "" {
+		ctx = tracing.FromTraceparent(ctx, t.TraceContext)
+	}
+	ctx, span := tracing.Tracer.Start(ctx, "CancelTask", trace.WithAttributes(
+		attribute.String("task.id", id),
+		attribute.String("task.worker", t.Worker),
+	))
+	defer span.End()
+
+	ids, err := s.store.CancelTask(namespace, id, reason)
+	if err != nil {
+		if e := finishError(err); e != nil {
+			return nil, e
+		}
+		slog.ErrorContext(ctx, "Error cancelling task", "error", err)
+		recordError(span, err)
+		return nil, ErrInternal
+	}
+	span.SetAttributes(attribute.Int("task.cancelled", len(ids)))
+
+	// Deepest first, so that every subtree is finished by the time its
+	// root is looked at
+	for _, cid := range ids {
+		c, err := s.store.GetTask(namespace, cid)
+		if err != nil {
+			slog.ErrorContext(ctx, "Error fetching cancelled task", "cancelled_id", cid, "error", err)
+			continue
+		}
+		observeFinished(c)
+		s.notify(ctx, c)
+		if cid == id {
+			t = c
+		}
+	}
+
+	s.requeueParentIfReady(ctx, t)
+	return ids, nil
+}
+
+// RetryTask creates a new task for the worker, parent, payload and
+// callbacks of a failed or cancelled task and returns its id, if the
+// principal of ctx may finish the task. The copy is created like any other
+// task, so req, which names the caller and the idempotency key, and the
+// principal of ctx must pass the same checks. A child is only retried while its parent is pending: once the parent is
+// finished the outcome of the child has been handed to it.
+func (s *Service) RetryTask(ctx context.Context, namespace string, id string, req *NewTask) (string, *Error) {
+	logging.Add(ctx, "task_id", id)
+	if !ValidID(id) {
+		return "", errTaskNotFound
+	}
+	t, e := s.manageable(ctx, namespace, id)
+	if e != nil {
+		return "", e
+	}
+	if t.Status != storage.StatusFailed && t.Status != storage.StatusCancelled {
+		return "", NewError(http.StatusConflict, CodeTaskNotRetryable, "Only failed or cancelled tasks can be retried")
+	}
+	if t.ParentID != nil {
+		parent, err := s.store.GetTask(namespace, *t.ParentID)
+		if err != nil {
+			slog.ErrorContext(ctx, "Error fetching parent", "error", err)
+			return "", ErrInternal
+		}
+		if parent.IsCompleted {
+			return "", NewError(http.StatusConflict, CodeTaskNotRetryable, "Parent task is already "+parent.Status+", retry the root instead")
+		}
+	}
+
+	req.Worker = t.Worker
+	req.ParentID = t.ParentID
+	req.Payload = t.Payload
+	req.CallbackURL = t.CallbackURL
+	req.CallbackEvents = t.CallbackEvents
+	return s.CreateTask(ctx, namespace, req)
+}
+
+// ReportProgress stores the progress of the task with the given id, if the
+// principal of ctx may finish the task. Followers of the task receive it
+// as a progress event.
+func (s *Service) ReportProgress(ctx context.Context, namespace string, id string, progress json.RawMessage) *Error {
+	logging.Add(ctx, "task_id", id)
+	if len(progress) > storage.MaxProgressSize {
+		return NewError(http.StatusBadRequest, CodeProgressTooLarge,
+			fmt.Sprintf("progress must be at most %d bytes", storage.MaxProgressSize))
+	}
+	if !ValidID(id) {
+		return errTaskNotFound
+	}
+	if e := s.checkTaskOwner(ctx, namespace, id); e != nil {
+		return e
+	}
+
+	err := s.store.ReportProgress(namespace, id, progress)
+	if err != nil {
+		if e := finishError(err); e != nil {
+			return e
+		}
+		slog.ErrorContext(ctx, "Error reporting progress", "error", err)
+		return ErrInternal
+	}
+	return nil
+}
+
+// WaitForChildren hands back a task that created children instead of
+// finishing it, if the principal of ctx may finish the task. Its lease is
+// dropped, so it is queued again only once the children are done, with
+// their results.
+func (s *Service) WaitForChildren(ctx context.Context, namespace string, id string) *Error {
+	logging.Add(ctx, "task_id", id)
+	if !ValidID(id) {
+		return errTaskNotFound
+	}
+	if e := s.checkTaskOwner(ctx, namespace, id); e != nil {
+		return e
+	}
+
+	err := s.store.WaitForChildren(namespace, id)
+	if err == storage.ErrNoChildren {
+		return NewError(http.StatusConflict, CodeTaskHasNoChildren, "Task has no subtasks to wait for")
+	}
+	if err != nil {
+		if e := finishError(err); e != nil {
+			return e
+		}
+		slog.ErrorContext(ctx, "Error releasing task", "error", err)
+		return ErrInternal
+	}
+	return nil
+}
+
+// requeueParentIfReady publishes the parent of a finished task again, with
+// the results of all its children attached as "subtasks", once none of
+// those children is still pending.
+func (s *Service) requeueParentIfReady(ctx context.Context, t *storage.Task) {
+	if t.ParentID == nil {
+		return
+	}
+	parentID := *t.ParentID
+
+	count, err := s.store.GetIncompleteChildCount(parentID)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error checking siblings", "error", err)
+		return
+	}
+	if count > 0 {
+		return
+	}
+
+	results, err := s.store.GetChildrenResults(parentID)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error getting child results", "error", err)
+		return
+	}
+	parent, err := s.store.GetTask(t.Namespace, parentID)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error fetching parent", "error", err)
+		return
+	}
+
+	// The message carries the parent's own payload plus the subtasks; the
+	// stored payload is left as created
+	combinedPayload := map[string]interface{}{}
+	if len(parent.Payload) > 0 {
+		json.Unmarshal(parent.Payload, &combinedPayload)
+	}
+	var resultObj []interface{}
+	for _, child := range results {
+		if child.Status == storage.StatusFailed || child.Status == storage.StatusCancelled {
+			resultObj = append(resultObj, map[string]interface{}{
+				"result":   nil,
+				"error":    child.Error,
+				"id":       child.ID,
+				"worker":   child.Worker,
+				"subtasks": []interface{}{},
+			})
+			continue
+		}
+
+		var rAny interface{}
+		if err := json.Unmarshal(child.Result, &rAny); err != nil {
+			slog.WarnContext(ctx, "Failed to unmarshal child result", "child_id", child.ID, "error", err)
+			continue
+		}
+
+		if rMap, ok := rAny.(map[string]interface{}); ok {
+			rMap["id"] = child.ID
+			rMap["worker"] = child.Worker
+			if _, exists := rMap["subtasks"]; !exists {
+				rMap["subtasks"] = []interface{}{}
+			}
+			resultObj = append(resultObj, rMap)
+		} else {
+			resultObj = append(resultObj, map[string]interface{}{
+				"result":   rAny,
+				"id":       child.ID,
+				"worker":   child.Worker,
+				"subtasks": []interface{}{},
+			})
+		}
+	}
+	combinedPayload["subtasks"] = resultObj
+
+	finalPayload, _ := json.Marshal(combinedPayload)
+
+	// The lease the parent was handed out with, before it created its
+	// children, must not queue its old payload again
+	if err := s.store.ClearLease(parent.ID); err != nil {
+		slog.ErrorContext(ctx, "Error clearing parent lease", "error", err)
+	}
+	if err := s.publish(ctx, parent.Namespace, parent.Worker, parent.ID, finalPayload); err != nil {
+		slog.ErrorContext(ctx, "Error publishing parent task", "error", err)
+	}
+}
+
+// publish sends a task message to the queue of the worker in the namespace,
+// under the trace in ctx, and records the outcome. It is not cut short by a
+// client going away.
+func (s *Service) publish(ctx context.Context, namespace string, workerName string, id string, payload json.RawMessage) error {
+	err := s.queue.PublishTask(context.WithoutCancel(ctx), queue.Name(namespace, workerName), id, payload)
+	metrics.ObservePublish(namespace, workerName, err)
+	if err == nil {
+		s.store.NotifyTaskEvent(&storage.TaskEvent{Type: storage.EventQueued, TaskID: id, Namespace: namespace, Worker: workerName})
+	}
+	return err
+}
+
+// recordError marks the span as failed.
+func recordError(span trace.Span, err error) {
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
+
+// observeFinished records a task that just reached a terminal state.
+func observeFinished(t *storage.Task) {
+	switch t.Status {
+	case storage.StatusCompleted:
+		metrics.TasksCompleted.WithLabelValues(t.Namespace, t.Worker).Inc()
+	case storage.StatusFailed:
+		metrics.TasksFailed.WithLabelValues(t.Namespace, t.Worker).Inc()
+	case storage.StatusCancelled:
+		metrics.TasksCancelled.WithLabelValues(t.Namespace, t.Worker).Inc()
+	}
+	if t.CompletedAt != nil {
+		metrics.TaskDuration.WithLabelValues(t.Namespace, t.Worker).Observe(t.CompletedAt.Sub(t.CreatedAt).Seconds())
+	}
+}
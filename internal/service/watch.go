@@ -0,0 +1,208 @@
+package service
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"task-api/internal/events"
+	"task-api/internal/storage"
+)
+
+var errEventsUnavailable = NewError(http.StatusServiceUnavailable, CodeEventsUnavailable, "Following tasks is not available")
+
+// WatchTask follows the task with the given id, and with subtree the tasks
+// below it, if the principal of ctx may. The events arrive on the returned
+// channel, which is closed once the task, or the whole subtree, is
+// finished, when ctx ends, or when events may have been lost; callers then
+// watch again. A task that is already over yields its final event only.
+func (s *Service) WatchTask(ctx context.Context, namespace string, id string, subtree bool) (<-chan *storage.TaskEvent, *Error) {
+	if s.opts.Events == nil {
+		return nil, errEventsUnavailable
+	}
+	if !ValidID(id) {
+		return nil, errTaskNotFound
+	}
+
+	// Subscribe before reading the state, so nothing falls in between
+	sub := s.opts.Events.Subscribe(namespace)
+	t, e := s.watchable(ctx, namespace, id)
+	if e != nil {
+		s.opts.Events.Unsubscribe(sub)
+		return nil, e
+	}
+	filter, err := s.newEventFilter(id, subtree)
+	if err != nil {
+		s.opts.Events.Unsubscribe(sub)
+		slog.ErrorContext(ctx, "Error listing subtree", "error", err)
+		return nil, ErrInternal
+	}
+
+	out := make(chan *storage.TaskEvent)
+	go func() {
+		defer close(out)
+		defer s.opts.Events.Unsubscribe(sub)
+		send := func(ev *storage.TaskEvent) bool {
+			select {
+			case out <- ev:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+
+		// Already over: say how it ended and stop
+		if done, err := s.watchDone(t, subtree); err != nil || done {
+			if t.IsCompleted {
+				send(&storage.TaskEvent{
+					Type: t.Status, TaskID: t.ID, Namespace: t.Namespace, ParentID: t.ParentID,
+					Worker: t.Worker, Status: t.Status, At: *t.CompletedAt,
+				})
+			}
+			return
+		}
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case ev, ok := <-sub.Events():
+				if !ok {
+					return
+				}
+				if !filter.Match(ev) {
+					continue
+				}
+				if !send(ev) {
+					return
+				}
+				if !IsTerminal(ev) {
+					continue
+				}
+				if ev.TaskID == id {
+					t.IsCompleted = true
+				}
+				if done, err := s.watchDone(t, subtree); err != nil || done {
+					return
+				}
+			}
+		}
+	}()
+	return out, nil
+}
+
+// eventFilter picks the events of one task and, for a subtree, of all its
+// descendants.
+type eventFilter struct {
+	subtree bool
+	members map[string]bool
+}
+
+// newEventFilter returns a filter for the task with the given id. For a
+// subtree it lists the current descendants, so it must be created after
+// subscribing to be sure to see every later one.
+func (s *Service) newEventFilter(id string, subtree bool) (*eventFilter, error) {
+	f := &eventFilter{subtree: subtree, members: map[string]bool{id: true}}
+	if subtree {
+		ids, err := s.store.SubtreeIDs(id)
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range ids {
+			f.members[m] = true
+		}
+	}
+	return f, nil
+}
+
+// Match reports whether the event concerns the filtered tasks, and starts
+// following new descendants.
+func (f *eventFilter) Match(ev *storage.TaskEvent) bool {
+	if f.subtree && ev.Type == storage.EventCreated && ev.ParentID != nil && f.members[*ev.ParentID] {
+		f.members[ev.TaskID] = true
+	}
+	return f.members[ev.TaskID]
+}
+
+// IsTerminal reports whether the event finishes its task.
+func IsTerminal(ev *storage.TaskEvent) bool {
+	return ev.Type == storage.EventCompleted || ev.Type == storage.EventFailed || ev.Type == storage.EventCancelled
+}
+
+// watchDone reports whether a watch of the task has nothing left to
+// report.
+func (s *Service) watchDone(t *storage.Task, subtree bool) (bool, error) {
+	if !subtree || !t.IsCompleted {
+		return t.IsCompleted, nil
+	}
+	done, err := s.store.SubtreeFinished(t.ID)
+	if err != nil {
+		slog.Error("Error checking subtree", "task_id", t.ID, "error", err)
+	}
+	return done, err
+}
+
+// Subscribe starts receiving the task events of the namespace, for Await.
+func (s *Service) Subscribe(namespace string) (*events.Subscription, *Error) {
+	if s.opts.Events == nil {
+		return nil, errEventsUnavailable
+	}
+	return s.opts.Events.Subscribe(namespace), nil
+}
+
+// Unsubscribe stops a subscription from Subscribe. It is safe to call more
+// than once.
+func (s *Service) Unsubscribe(sub *events.Subscription) {
+	s.opts.Events.Unsubscribe(sub)
+}
+
+// Await waits until the task and all its descendants are finished, so
+// that a parent re-queued with its children's results counts only once it
+// is done with them. It returns the finished task, or nil if ctx ends
+// first. It takes over sub, which must have been taken before the task
+// could finish. Waiters only touch the database when one of the tasks they
+// wait for finishes.
+func (s *Service) Await(ctx context.Context, sub *events.Subscription, namespace string, id string) (*storage.Task, error) {
+	hub := s.opts.Events
+	defer func() { hub.Unsubscribe(sub) }()
+
+	for {
+		filter, err := s.newEventFilter(id, true)
+		if err != nil {
+			return nil, err
+		}
+		t, err := s.store.GetTask(namespace, id)
+		if err != nil {
+			return nil, err
+		}
+		if t.IsCompleted {
+			done, err := s.store.SubtreeFinished(id)
+			if err != nil {
+				return nil, err
+			}
+			if done {
+				return t, nil
+			}
+		}
+
+	wait:
+		for {
+			select {
+			case <-ctx.Done():
+				return nil, nil
+			case ev, ok := <-sub.Events():
+				if !ok {
+					if hub.Stopped() {
+						// Shutting down
+						return nil, nil
+					}
+					// Events may have been lost, start over
+					sub = hub.Subscribe(namespace)
+					break wait
+				}
+				if filter.Match(ev) && IsTerminal(ev) {
+					break wait
+				}
+			}
+		}
+	}
+}
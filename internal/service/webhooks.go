@@ -0,0 +1,80 @@
+package service
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"task-api/internal/storage"
+	"task-api/internal/webhook"
+)
+
+// checkCallback validates the callback of a create request and fills in
+// the default events.
+func (s *Service) checkCallback(req *NewTask) *Error {
+	if req.CallbackURL == nil {
+		if len(req.CallbackEvents) > 0 {
+			return NewError(http.StatusBadRequest, CodeInvalidCallbackURL, "callback_events requires a callback_url")
+		}
+		return nil
+	}
+	if !s.opts.Webhooks {
+		return NewError(http.StatusConflict, CodeWebhooksUnavailable, "Webhooks are not enabled on this server")
+	}
+	if !webhook.ValidURL(*req.CallbackURL) {
+		return NewError(http.StatusBadRequest, CodeInvalidCallbackURL, "callback_url must be an absolute http or https URL")
+	}
+	if len(req.CallbackEvents) == 0 {
+		req.CallbackEvents = webhook.DefaultEvents
+	}
+	for _, e := range req.CallbackEvents {
+		if !webhook.ValidEvent(e) {
+			return NewError(http.StatusBadRequest, CodeInvalidCallbackEvent,
+				"Unknown callback event "+e+", expected completed, failed, cancelled or subtree_completed")
+		}
+	}
+	return nil
+}
+
+// notify schedules the webhooks due now that t is finished: its own
+// completed, failed or cancelled event, and subtree_completed for t and
+// every ancestor whose subtree t was the last to finish.
+func (s *Service) notify(ctx context.Context, t *storage.Task) {
+	// Terminal statuses and their events share names
+	s.enqueueWebhook(ctx, t, t.Status)
+
+	for cur := t; cur.IsCompleted; {
+		finished, err := s.store.SubtreeFinished(cur.ID)
+		if err != nil {
+			slog.ErrorContext(ctx, "Error checking subtree", "task_id", cur.ID, "error", err)
+			return
+		}
+		if !finished {
+			return
+		}
+		s.enqueueWebhook(ctx, cur, webhook.EventSubtreeCompleted)
+
+		if cur.ParentID == nil {
+			return
+		}
+		parentID := *cur.ParentID
+		if cur, err = s.store.GetTask(cur.Namespace, parentID); err != nil {
+			slog.ErrorContext(ctx, "Error fetching parent", "parent_id", parentID, "error", err)
+			return
+		}
+	}
+}
+
+// enqueueWebhook stores a delivery of event for the task if it has a
+// callback for it.
+func (s *Service) enqueueWebhook(ctx context.Context, t *storage.Task, event string) {
+	if !webhook.Wants(t, event) {
+		return
+	}
+	body, err := webhook.Body(event, t)
+	if err == nil {
+		err = s.store.CreateDelivery(t.ID, event, *t.CallbackURL, body)
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "Error scheduling webhook", "task_id", t.ID, "event", event, "error", err)
+	}
+}
@@ -0,0 +1,55 @@
+package service
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"task-api/internal/auth"
+	"task-api/internal/logging"
+	"task-api/internal/storage"
+)
+
+// Worker is a registered worker with its number of incomplete tasks.
+type Worker struct {
+	Name    string `json:"name"`
+	Pending int    `json:"pending"`
+}
+
+// ListWorkers returns the workers registered in the namespace, if the
+// principal of ctx is an admin.
+func (s *Service) ListWorkers(ctx context.Context, namespace string) ([]Worker, *Error) {
+	if !auth.FromContext(ctx).Admin {
+		return nil, forbidden("Only admins may list workers")
+	}
+	counts, err := s.store.ListWorkers(namespace)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error listing workers", "error", err)
+		return nil, ErrInternal
+	}
+	workers := make([]Worker, 0, len(counts))
+	for _, c := range counts {
+		workers = append(workers, Worker{Name: c.Worker, Pending: c.Count})
+	}
+	return workers, nil
+}
+
+// RegisterWorker registers a worker in the namespace, if the principal of
+// ctx is an admin. Registering an existing worker is not an error.
+func (s *Service) RegisterWorker(ctx context.Context, namespace string, name string) *Error {
+	logging.Add(ctx, "worker", name)
+	if !auth.FromContext(ctx).Admin {
+		return forbidden("Only admins may register workers")
+	}
+	if !storage.ValidWorker(name) {
+		return errInvalidWorkerName
+	}
+	err := s.store.CreateWorker(namespace, name)
+	if err == storage.ErrNamespaceNotFound {
+		return NewError(http.StatusBadRequest, CodeInvalidNamespace, "Namespace "+namespace+" does not exist")
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "Error registering worker", "error", err)
+		return ErrInternal
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package storage
+
+import (
+	"errors"
+
+	"github.com/lib/pq"
+)
+
+var (
+	ErrInvalidID      = errors.New("invalid id")
+	ErrParentNotFound = errors.New("parent task not found")
+	ErrWorkerNotFound = errors.New("worker not found")
+	// ErrDuplicateIdempotencyKey is returned by CreateTask when the
+	// namespace already has a task with the same idempotency key.
+	ErrDuplicateIdempotencyKey = errors.New("duplicate idempotency key")
+)
+
+// Postgres error codes and constraint names mapped to storage errors.
+const (
+	pqForeignKeyViolation       = "23503"
+	pqUniqueViolation           = "23505"
+	pqInvalidTextRepresentation = "22P02"
+
+	constraintTaskParent      = "tasks_parent_id_fkey"
+	constraintTaskWorker      = "tasks_worker_fkey"
+	constraintKeyWorker       = "api_keys_worker_fkey"
+	constraintWorkerNamespace = "workers_namespace_fkey"
+	constraintKeyNamespace    = "api_keys_namespace_fkey"
+	constraintIdempotencyKey  = "idx_tasks_namespace_idempotency_key"
+)
+
+// translateError maps Postgres errors callers can act on to the errors
+// above, and returns any other error unchanged.
+func translateError(err error) error {
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) {
+		return err
+	}
+	switch pqErr.Code {
+	case pqForeignKeyViolation:
+		switch pqErr.Constraint {
+		case constraintTaskParent:
+			return ErrParentNotFound
+		case constraintTaskWorker, constraintKeyWorker:
+			return ErrWorkerNotFound
+		case constraintWorkerNamespace, constraintKeyNamespace:
+			return ErrNamespaceNotFound
+		}
+	case pqUniqueViolation:
+		if pqErr.Constraint == constraintIdempotencyKey {
+			return ErrDuplicateIdempotencyKey
+		}
+	case pqInvalidTextRepresentation:
+		return ErrInvalidID
+	}
+	return err
+}
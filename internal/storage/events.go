@@ -0,0 +1,53 @@
+package storage
+
+import (
+	"encoding/json"
+	"log/slog"
+	"time"
+)
+
+// EventsChannel is the Postgres NOTIFY channel task events are sent on.
+const EventsChannel = "task_events"
+
+// Task event types.
+const (
+	EventCreated   = "created"
+	EventQueued    = "queued"
+	EventProgress  = "progress"
+	EventCompleted = "completed"
+	EventFailed    = "failed"
+	EventCancelled = "cancelled"
+)
+
+// MaxProgressSize bounds a progress report, so that every event fits into
+// a NOTIFY payload (8000 bytes).
+const MaxProgressSize = 4096
+
+// TaskEvent is a change of a task, sent to every API replica listening on
+// EventsChannel.
+type TaskEvent struct {
+	Type      string          `json:"event"`
+	TaskID    string          `json:"task_id"`
+	Namespace string          `json:"namespace"`
+	ParentID  *string         `json:"parent_id,omitempty"`
+	Worker    string          `json:"worker"`
+	Status    string          `json:"status,omitempty"`
+	Progress  json.RawMessage `json:"progress,omitempty"`
+	At        time.Time       `json:"at"`
+}
+
+// NotifyTaskEvent sends the event to all listeners. Events are best
+// effort: failures are logged, not returned, since the change itself has
+// already been stored.
+func (s *Storage) NotifyTaskEvent(ev *TaskEvent) {
+	if ev.At.IsZero() {
+		ev.At = time.Now().UTC()
+	}
+	payload, err := json.Marshal(ev)
+	if err == nil {
+		_, err = s.db.Exec(`SELECT pg_notify($1, $2)`, EventsChannel, string(payload))
+	}
+	if err != nil {
+		slog.Error("Error sending task event", "task_id", ev.TaskID, "event", ev.Type, "error", err)
+	}
+}
@@ -0,0 +1,121 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+var ErrAPIKeyNotFound = errors.New("api key not found")
+
+// APIKey is a stored API credential. The key itself is never stored, only
+// its hash.
+type APIKey struct {
+	ID string `json:"id"`
+	// Namespace is nil only for global admin keys.
+	Namespace *string    `json:"namespace,omitempty"`
+	Worker    *string    `json:"worker,omitempty"`
+	Admin     bool       `json:"admin"`
+	Delegates []string   `json:"delegates"`
+	CreatedAt time.Time  `json:"created_at"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+}
+
+// CreateAPIKey stores a key by its hash and returns the new key id.
+func (s *Storage) CreateAPIKey(key *APIKey, hash string) (string, error) {
+	var id string
+	query := `
+		INSERT INTO api_keys (key_hash, namespace, worker, is_admin, delegates)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id
+	`
+	delegates := key.Delegates
+	if delegates == nil {
+		delegates = []string{}
+	}
+	err := s.db.QueryRow(query, hash, key.Namespace, key.Worker, key.Admin, pq.Array(delegates)).Scan(&id)
+	if err != nil {
+		return "", translateError(err)
+	}
+	return id, nil
+}
+
+// GetAPIKeyByHash returns the unrevoked key with the given hash, or
+// sql.ErrNoRows.
+func (s *Storage) GetAPIKeyByHash(hash string) (*APIKey, error) {
+	query := `
+		SELECT id, namespace, worker, is_admin, delegates, created_at
+		FROM api_keys
+		WHERE key_hash = $1 AND revoked_at IS NULL
+	`
+	k := &APIKey{}
+	var namespace, worker sql.NullString
+	err := s.db.QueryRow(query, hash).Scan(&k.ID, &namespace, &worker, &k.Admin, pq.Array(&k.Delegates), &k.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if namespace.Valid {
+		k.Namespace = &namespace.String
+	}
+	if worker.Valid {
+		k.Worker = &worker.String
+	}
+	return k, nil
+}
+
+// ListAPIKeys returns every key, revoked ones included, oldest first.
+func (s *Storage) ListAPIKeys() ([]*APIKey, error) {
+	query := `
+		SELECT id, namespace, worker, is_admin, delegates, created_at, revoked_at
+		FROM api_keys
+		ORDER BY created_at
+	`
+	rows, err := s.db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []*APIKey
+	for rows.Next() {
+		k := &APIKey{}
+		var namespace, worker sql.NullString
+		var revokedAt sql.NullTime
+		if err := rows.Scan(&k.ID, &namespace, &worker, &k.Admin, pq.Array(&k.Delegates), &k.CreatedAt, &revokedAt); err != nil {
+			return nil, err
+		}
+		if namespace.Valid {
+			k.Namespace = &namespace.String
+		}
+		if worker.Valid {
+			k.Worker = &worker.String
+		}
+		if revokedAt.Valid {
+			k.RevokedAt = &revokedAt.Time
+		}
+		keys = append(keys, k)
+	}
+	return keys, rows.Err()
+}
+
+// RevokeAPIKey disables a key. Revoking a revoked key is not an error.
+func (s *Storage) RevokeAPIKey(id string) error {
+	query := `UPDATE api_keys SET revoked_at = COALESCE(revoked_at, NOW()) WHERE id = $1`
+	res, err := s.db.Exec(query, id)
+	if err != nil {
+		if translateError(err) == ErrInvalidID {
+			return ErrAPIKeyNotFound
+		}
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrAPIKeyNotFound
+	}
+	return nil
+}
@@ -0,0 +1,158 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"regexp"
+	"strings"
+	"time"
+)
+
+var (
+	ErrNamespaceExists   = errors.New("namespace already exists")
+	ErrNamespaceNotFound = errors.New("namespace not found")
+)
+
+var namespaceName = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,62}$`)
+
+// ValidNamespace reports whether name may be used as a namespace. Names
+// cannot contain dots, which separate namespace and worker in queue names.
+func ValidNamespace(name string) bool {
+	return namespaceName.MatchString(name)
+}
+
+// ValidWorker reports whether name may be used as a worker name: 1 to 255
+// characters without dots, so that a queue name stands for exactly one
+// namespace and worker.
+func ValidWorker(name string) bool {
+	return name != "" && len(name) <= 255 && !strings.Contains(name, ".")
+}
+
+// Namespace is a tenant. Workers, tasks and worker keys belong to exactly
+// one namespace.
+type Namespace struct {
+	Name string `json:"name"`
+	// MaxPending caps the incomplete tasks of the namespace. Nil means the
+	// server-wide default applies.
+	MaxPending *int      `json:"max_pending,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// CreateNamespace adds a namespace without workers.
+func (s *Storage) CreateNamespace(ns *Namespace) error {
+	query := `INSERT INTO namespaces (name, max_pending) VALUES ($1, $2) ON CONFLICT DO NOTHING`
+	res, err := s.db.Exec(query, ns.Name, ns.MaxPending)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNamespaceExists
+	}
+	return nil
+}
+
+// GetNamespace returns the namespace with the given name, or sql.ErrNoRows.
+func (s *Storage) GetNamespace(name string) (*Namespace, error) {
+	ns := &Namespace{}
+	var maxPending sql.NullInt64
+	err := s.db.QueryRow(`SELECT name, max_pending, created_at FROM namespaces WHERE name = $1`, name).
+		Scan(&ns.Name, &maxPending, &ns.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if maxPending.Valid {
+		n := int(maxPending.Int64)
+		ns.MaxPending = &n
+	}
+	return ns, nil
+}
+
+// ListNamespaces returns every namespace by name.
+func (s *Storage) ListNamespaces() ([]*Namespace, error) {
+	rows, err := s.db.Query(`SELECT name, max_pending, created_at FROM namespaces ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var list []*Namespace
+	for rows.Next() {
+		ns := &Namespace{}
+		var maxPending sql.NullInt64
+		if err := rows.Scan(&ns.Name, &maxPending, &ns.CreatedAt); err != nil {
+			return nil, err
+		}
+		if maxPending.Valid {
+			n := int(maxPending.Int64)
+			ns.MaxPending = &n
+		}
+		list = append(list, ns)
+	}
+	return list, rows.Err()
+}
+
+// SetNamespaceQuota sets the pending task quota of a namespace. Nil goes
+// back to the server-wide default.
+func (s *Storage) SetNamespaceQuota(name string, maxPending *int) error {
+	res, err := s.db.Exec(`UPDATE namespaces SET max_pending = $1 WHERE name = $2`, maxPending, name)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNamespaceNotFound
+	}
+	return nil
+}
+
+// CreateWorker registers a worker in the namespace. Registering an existing
+// worker is not an error.
+func (s *Storage) CreateWorker(namespace string, name string) error {
+	query := `INSERT INTO workers (namespace, name) VALUES ($1, $2) ON CONFLICT DO NOTHING`
+	_, err := s.db.Exec(query, namespace, name)
+	return translateError(err)
+}
+
+// ListWorkers returns the workers of the namespace by name, with their
+// number of incomplete tasks.
+func (s *Storage) ListWorkers(namespace string) ([]WorkerCount, error) {
+	query := `
+		SELECT w.namespace, w.name, COUNT(t.id)
+		FROM workers w
+		LEFT JOIN tasks t ON t.namespace = w.namespace AND t.worker = w.name AND t.is_completed = FALSE
+		WHERE w.namespace = $1
+		GROUP BY w.namespace, w.name
+		ORDER BY w.name
+	`
+	rows, err := s.db.Query(query, namespace)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var counts []WorkerCount
+	for rows.Next() {
+		var c WorkerCount
+		if err := rows.Scan(&c.Namespace, &c.Worker, &c.Count); err != nil {
+			return nil, err
+		}
+		counts = append(counts, c)
+	}
+	return counts, rows.Err()
+}
+
+// NamespacePendingCount returns the number of incomplete tasks in the
+// namespace.
+func (s *Storage) NamespacePendingCount(namespace string) (int, error) {
+	var count int
+	query := `SELECT COUNT(*) FROM tasks WHERE namespace = $1 AND is_completed = FALSE`
+	err := s.db.QueryRow(query, namespace).Scan(&count)
+	return count, err
+}
@@ -4,18 +4,53 @@ import (
 	"database/sql"
 	"encoding/json"
 	"errors"
-	"log"
+	"fmt"
+	"log/slog"
+	"time"
 
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
+)
+
+// DefaultNamespace holds everything created without a namespace, including
+// all data from before namespaces existed.
+const DefaultNamespace = "default"
+
+// Task statuses. Every status but pending has IsCompleted set.
+const (
+	StatusPending   = "pending"
+	StatusCompleted = "completed"
+	StatusFailed    = "failed"
+	StatusCancelled = "cancelled"
 )
 
 type Task struct {
 	ID          string          `json:"id"`
+	Namespace   string          `json:"namespace"`
 	ParentID    *string         `json:"parent_id,omitempty"`
+	RootID      *string         `json:"root_id,omitempty"` // root of the tree, nil for roots
+	Depth       int             `json:"depth"`             // distance from the root
 	Worker      string          `json:"worker"`
 	Payload     json.RawMessage `json:"payload"`
 	Result      json.RawMessage `json:"result,omitempty"`
 	IsCompleted bool            `json:"is_completed"`
+	Status      string          `json:"status"`
+	Error       *string         `json:"error,omitempty"`
+	CreatedAt   time.Time       `json:"created_at"`
+	CompletedAt *time.Time      `json:"completed_at,omitempty"`
+	// Progress is the latest progress report of the worker.
+	Progress   json.RawMessage `json:"progress,omitempty"`
+	ProgressAt *time.Time      `json:"progress_at,omitempty"`
+	// CallbackURL receives a webhook for each of CallbackEvents.
+	CallbackURL    *string  `json:"callback_url,omitempty"`
+	CallbackEvents []string `json:"callback_events,omitempty"`
+	// TraceContext is the W3C traceparent the task was created under.
+	TraceContext string `json:"-"`
+	// IdempotencyKey is the Idempotency-Key the task was created with. It
+	// is only set by CreateTask.
+	IdempotencyKey string `json:"-"`
+	// PublishFailed is set while the task could not be queued after it was
+	// created.
+	PublishFailed bool `json:"-"`
 }
 
 type Storage struct {
@@ -33,12 +68,6 @@ func New(postgresURL string) (*Storage, error) {
 	return &Storage{db: db}, nil
 }
 
-func (s *Storage) InitDB(schemaPath string) error {
-	// For simplicity, we assume schema is managed externally or via a simple exec.
-	// But here I'll just leave it as we are using schema.sql manually or via tests.
-	return nil
-}
-
 func (s *Storage) Ping() error {
 	return s.db.Ping()
 }
@@ -46,69 +75,357 @@ func (s *Storage) Ping() error {
 func (s *Storage) CreateTask(task *Task) (string, error) {
 	var id string
 	query := `
-		INSERT INTO tasks (parent_id, worker, payload)
-		VALUES ($1, $2, $3)
+		INSERT INTO tasks (namespace, parent_id, root_id, depth, worker, payload, trace_context,
+			callback_url, callback_events, idempotency_key)
+		VALUES ($1, $2, $3, $4, $5, $6, NULLIF($7, ''), $8, $9, NULLIF($10, ''))
 		RETURNING id
 	`
-	err := s.db.QueryRow(query, task.ParentID, task.Worker, task.Payload).Scan(&id)
+	var events interface{} // NULL rather than an empty array without a callback
+	if task.CallbackEvents != nil {
+		events = pq.Array(task.CallbackEvents)
+	}
+	err := s.db.QueryRow(query, task.Namespace, task.ParentID, task.RootID, task.Depth, task.Worker, task.Payload,
+		task.TraceContext, task.CallbackURL, events, task.IdempotencyKey).Scan(&id)
 	if err != nil {
-		return "", err
+		return "", translateError(err)
 	}
+	s.NotifyTaskEvent(&TaskEvent{
+		Type:      EventCreated,
+		TaskID:    id,
+		Namespace: task.Namespace,
+		ParentID:  task.ParentID,
+		Worker:    task.Worker,
+		Status:    StatusPending,
+	})
 	return id, nil
 }
 
-func (s *Storage) GetTask(id string) (*Task, error) {
-	query := `SELECT id, parent_id, worker, payload, result, is_completed FROM tasks WHERE id = $1`
-	row := s.db.QueryRow(query, id)
+// taskColumns are the columns scanTask reads, in order.
+const taskColumns = `id, namespace, parent_id, root_id, depth, worker, payload, result, is_completed, status, error,
+	created_at, completed_at, COALESCE(trace_context, ''), callback_url, callback_events, progress, progress_at, publish_failed`
 
+// scanTask reads a row of taskColumns.
+func scanTask(row interface{ Scan(...any) error }) (*Task, error) {
 	t := &Task{}
-	var parentID sql.NullString
+	var parentID, rootID, callbackURL sql.NullString
 	var result []byte
+	var taskErr sql.NullString
+	var completedAt, progressAt sql.NullTime
+	var progress []byte
 
-	err := row.Scan(&t.ID, &parentID, &t.Worker, &t.Payload, &result, &t.IsCompleted)
+	err := row.Scan(&t.ID, &t.Namespace, &parentID, &rootID, &t.Depth, &t.Worker, &t.Payload, &result, &t.IsCompleted,
+		&t.Status, &taskErr, &t.CreatedAt, &completedAt, &t.TraceContext,
+		&callbackURL, pq.Array(&t.CallbackEvents), &progress, &progressAt, &t.PublishFailed)
 	if err != nil {
-		return nil, err
+		return nil, translateError(err)
 	}
 
 	if parentID.Valid {
 		t.ParentID = &parentID.String
 	}
+	if rootID.Valid {
+		t.RootID = &rootID.String
+	}
 	if result != nil {
 		t.Result = result
 	}
+	if taskErr.Valid {
+		t.Error = &taskErr.String
+	}
+	if completedAt.Valid {
+		t.CompletedAt = &completedAt.Time
+	}
+	if callbackURL.Valid {
+		t.CallbackURL = &callbackURL.String
+	}
+	if progress != nil {
+		t.Progress = progress
+	}
+	if progressAt.Valid {
+		t.ProgressAt = &progressAt.Time
+	}
 
 	return t, nil
 }
 
-var ErrTaskAlreadyCompleted = errors.New("task already completed")
+// GetTask returns the task with the given id in the namespace, or
+// sql.ErrNoRows if the namespace has no such task.
+func (s *Storage) GetTask(namespace string, id string) (*Task, error) {
+	query := `SELECT ` + taskColumns + ` FROM tasks WHERE id = $1 AND namespace = $2`
+	return scanTask(s.db.QueryRow(query, id, namespace))
+}
+
+// GetTaskByIdempotencyKey returns the task of the namespace created with
+// the given idempotency key, or sql.ErrNoRows.
+func (s *Storage) GetTaskByIdempotencyKey(namespace string, key string) (*Task, error) {
+	query := `SELECT ` + taskColumns + ` FROM tasks WHERE namespace = $1 AND idempotency_key = $2`
+	return scanTask(s.db.QueryRow(query, namespace, key))
+}
 
-func (s *Storage) CompleteTask(id string, result json.RawMessage) error {
+// SetPublishFailed records whether the task failed to be queued after it
+// was created.
+func (s *Storage) SetPublishFailed(id string, failed bool) error {
+	_, err := s.db.Exec(`UPDATE tasks SET publish_failed = $1 WHERE id = $2`, failed, id)
+	return err
+}
+
+// TaskFilter selects the tasks ListTasks returns. Empty fields match any
+// task.
+type TaskFilter struct {
+	Worker   string
+	Status   string
+	ParentID string
+	RootID   string
+	// Newest lists the newest tasks first.
+	Newest bool
+	// After continues a listing after the task with this creation time
+	// and id.
+	AfterCreatedAt time.Time
+	AfterID        string
+	Limit          int
+}
+
+// ListTasks returns up to f.Limit tasks of the namespace that match the
+// filter, oldest first unless f.Newest is set.
+func (s *Storage) ListTasks(namespace string, f TaskFilter) ([]*Task, error) {
+	query := `SELECT ` + taskColumns + ` FROM tasks WHERE namespace = $1`
+	args := []interface{}{namespace}
+	add := func(cond string, arg interface{}) {
+		args = append(args, arg)
+		query += fmt.Sprintf(" AND "+cond, len(args))
+	}
+	if f.Worker != "" {
+		add("worker = $%d", f.Worker)
+	}
+	if f.Status != "" {
+		add("status = $%d", f.Status)
+	}
+	if f.ParentID != "" {
+		add("parent_id = $%d", f.ParentID)
+	}
+	if f.RootID != "" {
+		add("root_id = $%d", f.RootID)
+	}
+	after, order := ">", "created_at, id"
+	if f.Newest {
+		after, order = "<", "created_at DESC, id DESC"
+	}
+	if f.AfterID != "" {
+		args = append(args, f.AfterCreatedAt)
+		query += fmt.Sprintf(" AND (created_at, id) %s ($%d, $%d)", after, len(args), len(args)+1)
+		args = append(args, f.AfterID)
+	}
+	args = append(args, f.Limit)
+	query += fmt.Sprintf(" ORDER BY %s LIMIT $%d", order, len(args))
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, translateError(err)
+	}
+	defer rows.Close()
+
+	var tasks []*Task
+	for rows.Next() {
+		t, err := scanTask(rows)
+		if err != nil {
+			return nil, err
+		}
+		tasks = append(tasks, t)
+	}
+	return tasks, rows.Err()
+}
+
+// GetTraceContext returns the traceparent the task was created under, or an
+// empty string if it has none.
+func (s *Storage) GetTraceContext(namespace string, id string) (string, error) {
+	var tc sql.NullString
+	err := s.db.QueryRow(`SELECT trace_context FROM tasks WHERE id = $1 AND namespace = $2`, id, namespace).Scan(&tc)
+	return tc.String, err
+}
+
+var (
+	ErrTaskAlreadyCompleted = errors.New("task already completed")
+	ErrTaskNotFound         = errors.New("task not found")
+	ErrNoChildren           = errors.New("task has no children")
+)
+
+func (s *Storage) CompleteTask(namespace string, id string, result json.RawMessage) error {
+	return s.finishTask(namespace, id, StatusCompleted, result, nil)
+}
+
+// FailTask marks the task as failed with the given error message. Like a
+// completed task it counts as done for its parent.
+func (s *Storage) FailTask(namespace string, id string, message string) error {
+	return s.finishTask(namespace, id, StatusFailed, nil, &message)
+}
+
+func (s *Storage) finishTask(namespace string, id string, status string, result json.RawMessage, message *string) error {
 	// Check if already completed to prevent double submission
 	// We can do this in the UPDATE with a WHERE clause and checking affected rows,
 	// or separate check. Affected rows is safer for concurrency.
-	query := `UPDATE tasks SET result = $1, is_completed = TRUE WHERE id = $2 AND is_completed = FALSE`
-	res, err := s.db.Exec(query, result, id)
+	query := `
+		UPDATE tasks SET result = $1, error = $2, status = $3, is_completed = TRUE, completed_at = NOW(),
+			queued_at = NULL, queued_payload = NULL, leased_until = NULL
+		WHERE id = $4 AND namespace = $5 AND is_completed = FALSE
+		RETURNING parent_id, worker
+	`
+	var resultArg interface{} // a nil RawMessage would be sent as '' rather than NULL
+	if result != nil {
+		resultArg = []byte(result)
+	}
+	ev := &TaskEvent{Type: status, TaskID: id, Namespace: namespace, Status: status}
+	err := s.db.QueryRow(query, resultArg, message, status, id, namespace).Scan(&ev.ParentID, &ev.Worker)
+	if err == sql.ErrNoRows {
+		return s.notUpdatable(namespace, id)
+	}
 	if err != nil {
 		return err
 	}
-	rows, err := res.RowsAffected()
+	s.NotifyTaskEvent(ev)
+	return nil
+}
+
+// notUpdatable explains why an update of an incomplete task in the
+// namespace matched no row.
+func (s *Storage) notUpdatable(namespace string, id string) error {
+	// Check if it exists but is completed
+	t, err := s.GetTask(namespace, id)
+	if err == sql.ErrNoRows || err == ErrInvalidID {
+		return ErrTaskNotFound
+	}
 	if err != nil {
 		return err
 	}
-	if rows == 0 {
-		// Check if it exists but is completed
-		t, err := s.GetTask(id)
-		if err != nil {
-			return err // or ErrNotFound
-		}
-		if t.IsCompleted {
-			return ErrTaskAlreadyCompleted
+	if t.IsCompleted {
+		return ErrTaskAlreadyCompleted
+	}
+	return ErrTaskNotFound
+}
+
+// CancelTask cancels an incomplete task together with its incomplete
+// descendants, recording reason as their error. It returns the ids of the
+// cancelled tasks, deepest first, or ErrTaskNotFound or
+// ErrTaskAlreadyCompleted like CompleteTask.
+func (s *Storage) CancelTask(namespace string, id string, reason string) ([]string, error) {
+	query := `
+		WITH RECURSIVE subtree AS (
+			SELECT id, 0 AS depth FROM tasks WHERE id = $1 AND namespace = $2 AND is_completed = FALSE
+			UNION ALL
+			SELECT t.id, s.depth + 1 FROM tasks t JOIN subtree s ON t.parent_id = s.id
+		), cancelled AS (
+			UPDATE tasks SET error = $3, status = $4, is_completed = TRUE, completed_at = NOW(),
+				queued_at = NULL, queued_payload = NULL, leased_until = NULL
+			WHERE id IN (SELECT id FROM subtree) AND is_completed = FALSE
+			RETURNING id, parent_id, worker
+		)
+		SELECT c.id, c.parent_id, c.worker FROM cancelled c JOIN subtree s ON s.id = c.id
+		ORDER BY s.depth DESC
+	`
+	rows, err := s.db.Query(query, id, namespace, reason, StatusCancelled)
+	if err != nil {
+		return nil, translateError(err)
+	}
+	defer rows.Close()
+
+	var ids []string
+	var evs []*TaskEvent
+	for rows.Next() {
+		ev := &TaskEvent{Type: EventCancelled, Namespace: namespace, Status: StatusCancelled}
+		if err := rows.Scan(&ev.TaskID, &ev.ParentID, &ev.Worker); err != nil {
+			return nil, err
 		}
-		return errors.New("task not found")
+		ids = append(ids, ev.TaskID)
+		evs = append(evs, ev)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(ids) == 0 {
+		return nil, s.notUpdatable(namespace, id)
+	}
+	for _, ev := range evs {
+		s.NotifyTaskEvent(ev)
+	}
+	return ids, nil
+}
+
+// ReportProgress stores the latest progress of an incomplete task and
+// announces it. It returns ErrTaskNotFound or ErrTaskAlreadyCompleted like
+// CompleteTask.
+func (s *Storage) ReportProgress(namespace string, id string, progress json.RawMessage) error {
+	query := `
+		UPDATE tasks SET progress = $1, progress_at = NOW()
+		WHERE id = $2 AND namespace = $3 AND is_completed = FALSE
+		RETURNING parent_id, worker
+	`
+	ev := &TaskEvent{Type: EventProgress, TaskID: id, Namespace: namespace, Status: StatusPending, Progress: progress}
+	err := s.db.QueryRow(query, []byte(progress), id, namespace).Scan(&ev.ParentID, &ev.Worker)
+	if err == sql.ErrNoRows {
+		return s.notUpdatable(namespace, id)
 	}
+	if err != nil {
+		return translateError(err)
+	}
+	s.NotifyTaskEvent(ev)
 	return nil
 }
 
+// SubtreeIDs returns the ids of the task and all its descendants.
+func (s *Storage) SubtreeIDs(id string) ([]string, error) {
+	query := `
+		WITH RECURSIVE subtree AS (
+			SELECT id FROM tasks WHERE id = $1
+			UNION ALL
+			SELECT t.id FROM tasks t JOIN subtree ON t.parent_id = subtree.id
+		)
+		SELECT id FROM subtree
+	`
+	rows, err := s.db.Query(query, id)
+	if err != nil {
+		return nil, translateError(err)
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// Subtree returns the task and all its descendants, oldest first, so that
+// parents come before their children.
+func (s *Storage) Subtree(namespace string, id string) ([]*Task, error) {
+	query := `
+		WITH RECURSIVE subtree AS (
+			SELECT id FROM tasks WHERE id = $1 AND namespace = $2
+			UNION ALL
+			SELECT t.id FROM tasks t JOIN subtree ON t.parent_id = subtree.id
+		)
+		SELECT ` + taskColumns + ` FROM tasks WHERE id IN (SELECT id FROM subtree)
+		ORDER BY created_at, id
+	`
+	rows, err := s.db.Query(query, id, namespace)
+	if err != nil {
+		return nil, translateError(err)
+	}
+	defer rows.Close()
+
+	var tasks []*Task
+	for rows.Next() {
+		t, err := scanTask(rows)
+		if err != nil {
+			return nil, err
+		}
+		tasks = append(tasks, t)
+	}
+	return tasks, rows.Err()
+}
+
 func (s *Storage) GetIncompleteChildCount(parentID string) (int, error) {
 	query := `SELECT COUNT(*) FROM tasks WHERE parent_id = $1 AND is_completed = FALSE`
 	var count int
@@ -117,7 +434,7 @@ func (s *Storage) GetIncompleteChildCount(parentID string) (int, error) {
 }
 
 func (s *Storage) GetChildrenResults(parentID string) ([]*Task, error) {
-	query := `SELECT id, worker, result FROM tasks WHERE parent_id = $1`
+	query := `SELECT id, worker, result, status, error FROM tasks WHERE parent_id = $1`
 	rows, err := s.db.Query(query, parentID)
 	if err != nil {
 		return nil, err
@@ -129,22 +446,262 @@ func (s *Storage) GetChildrenResults(parentID string) ([]*Task, error) {
 		var id string
 		var worker string
 		var r []byte
-		if err := rows.Scan(&id, &worker, &r); err != nil {
-			log.Printf("Failed to scan child result: %v", err)
+		var status string
+		var taskErr sql.NullString
+		if err := rows.Scan(&id, &worker, &r, &status, &taskErr); err != nil {
+			slog.Warn("Failed to scan child result", "parent_id", parentID, "error", err)
 			continue
 		}
-		tasks = append(tasks, &Task{
+		t := &Task{
 			ID:     id,
 			Worker: worker,
 			Result: json.RawMessage(r),
-		})
+			Status: status,
+		}
+		if taskErr.Valid {
+			t.Error = &taskErr.String
+		}
+		tasks = append(tasks, t)
 	}
 	return tasks, nil
 }
 
-func (s *Storage) ValidateWorker(name string) (bool, error) {
+// MarkQueued makes the task claimable by its worker with the given message
+// payload. Any outstanding lease is dropped.
+func (s *Storage) MarkQueued(id string, payload json.RawMessage) error {
+	query := `UPDATE tasks SET queued_at = NOW(), queued_payload = $1, leased_until = NULL WHERE id = $2`
+	_, err := s.db.Exec(query, payload, id)
+	return err
+}
+
+// WaitForChildren drops the lease of an incomplete task that created
+// children, so that it is queued again only once they are done, with their
+// results. It returns ErrNoChildren if the task has none, or
+// ErrTaskNotFound or ErrTaskAlreadyCompleted like CompleteTask.
+func (s *Storage) WaitForChildren(namespace string, id string) error {
+	query := `
+		UPDATE tasks SET leased_until = NULL
+		WHERE id = $1 AND namespace = $2 AND is_completed = FALSE
+			AND EXISTS (SELECT 1 FROM tasks c WHERE c.parent_id = tasks.id)
+	`
+	res, err := s.db.Exec(query, id, namespace)
+	if err != nil {
+		return translateError(err)
+	}
+	if n, err := res.RowsAffected(); err != nil || n > 0 {
+		return err
+	}
+	t, err := s.GetTask(namespace, id)
+	switch {
+	case err == sql.ErrNoRows || err == ErrInvalidID:
+		return ErrTaskNotFound
+	case err != nil:
+		return err
+	case t.IsCompleted:
+		return ErrTaskAlreadyCompleted
+	}
+	return ErrNoChildren
+}
+
+// ClearLease drops the lease of a task, so that ExpireLeases leaves it
+// alone.
+func (s *Storage) ClearLease(id string) error {
+	_, err := s.db.Exec(`UPDATE tasks SET leased_until = NULL WHERE id = $1`, id)
+	return err
+}
+
+// ClaimTasks leases up to limit queued tasks of the worker in the namespace,
+// oldest first.
+// Rows locked by a concurrent claim are skipped, and tasks whose lease has
+// expired are handed out again, unless they have incomplete children: such
+// a parent is waiting for them and is queued again with their results once
+// they are done. The returned tasks carry the queued payload.
+func (s *Storage) ClaimTasks(namespace string, worker string, limit int, lease time.Duration) ([]*Task, error) {
+	query := `
+		UPDATE tasks SET leased_until = NOW() + make_interval(secs => $3)
+		WHERE id IN (
+			SELECT id FROM tasks
+			WHERE namespace = $4 AND worker = $1 AND is_completed = FALSE AND queued_at IS NOT NULL
+				AND (leased_until IS NULL OR leased_until < NOW())
+				AND NOT EXISTS (SELECT 1 FROM tasks c WHERE c.parent_id = tasks.id AND NOT c.is_completed)
+			ORDER BY queued_at
+			LIMIT $2
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, namespace, worker, queued_payload
+	`
+	rows, err := s.db.Query(query, worker, limit, lease.Seconds(), namespace)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tasks []*Task
+	for rows.Next() {
+		t := &Task{}
+		var payload []byte
+		if err := rows.Scan(&t.ID, &t.Namespace, &t.Worker, &payload); err != nil {
+			return nil, err
+		}
+		t.Payload = payload
+		tasks = append(tasks, t)
+	}
+	return tasks, rows.Err()
+}
+
+// LeaseTask records that the message for an incomplete task was handed to a
+// worker and must be completed before the lease runs out. The payload is
+// kept so the message can be published again once the lease expires. It
+// reports false if the task is unknown or already completed.
+func (s *Storage) LeaseTask(id string, payload json.RawMessage, lease time.Duration) (bool, error) {
+	query := `
+		UPDATE tasks SET queued_payload = $1, leased_until = NOW() + make_interval(secs => $2)
+		WHERE id = $3 AND is_completed = FALSE
+	`
+	res, err := s.db.Exec(query, payload, lease.Seconds(), id)
+	if err != nil {
+		return false, err
+	}
+	rows, err := res.RowsAffected()
+	return rows > 0, err
+}
+
+// RenewLeases pushes the leases of the given tasks out to lease from now.
+// It returns the ids whose lease is still held, leaving out tasks that
+// were completed or whose lease already expired.
+func (s *Storage) RenewLeases(ids []string, lease time.Duration) ([]string, error) {
+	query := `
+		UPDATE tasks SET leased_until = NOW() + make_interval(secs => $1)
+		WHERE id = ANY($2::uuid[]) AND is_completed = FALSE AND leased_until IS NOT NULL
+		RETURNING id
+	`
+	rows, err := s.db.Query(query, lease.Seconds(), pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var held []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		held = append(held, id)
+	}
+	return held, rows.Err()
+}
+
+// ReleaseLeases lets the leases of the given incomplete tasks run out now,
+// so that the next ExpireLeases queues them again.
+func (s *Storage) ReleaseLeases(ids []string) error {
+	query := `
+		UPDATE tasks SET leased_until = NOW()
+		WHERE id = ANY($1::uuid[]) AND is_completed = FALSE AND leased_until IS NOT NULL
+	`
+	_, err := s.db.Exec(query, pq.Array(ids))
+	return err
+}
+
+// ExpireLeases clears every lease that ran out before its task completed and
+// returns those tasks with the payload they were leased with, so they can be
+// queued again. Each expired lease is returned to exactly one caller. Tasks
+// with incomplete children are left alone: they are waiting for them and
+// are queued again with their results once they are done.
+func (s *Storage) ExpireLeases() ([]*Task, error) {
+	query := `
+		UPDATE tasks SET leased_until = NULL
+		WHERE leased_until < NOW() AND is_completed = FALSE
+			AND NOT EXISTS (SELECT 1 FROM tasks c WHERE c.parent_id = tasks.id AND NOT c.is_completed)
+		RETURNING id, namespace, worker, queued_payload, COALESCE(trace_context, '')
+	`
+	rows, err := s.db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tasks []*Task
+	for rows.Next() {
+		t := &Task{}
+		var payload []byte
+		if err := rows.Scan(&t.ID, &t.Namespace, &t.Worker, &payload, &t.TraceContext); err != nil {
+			return nil, err
+		}
+		t.Payload = payload
+		tasks = append(tasks, t)
+	}
+	return tasks, rows.Err()
+}
+
+// WorkerCount is the number of tasks of one worker.
+type WorkerCount struct {
+	Namespace string
+	Worker    string
+	Count     int
+}
+
+// PendingTaskCounts returns the number of incomplete tasks per worker,
+// including workers that have none.
+func (s *Storage) PendingTaskCounts() ([]WorkerCount, error) {
+	query := `
+		SELECT w.namespace, w.name, COUNT(t.id)
+		FROM workers w
+		LEFT JOIN tasks t ON t.namespace = w.namespace AND t.worker = w.name AND t.is_completed = FALSE
+		GROUP BY w.namespace, w.name
+	`
+	rows, err := s.db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var counts []WorkerCount
+	for rows.Next() {
+		var c WorkerCount
+		if err := rows.Scan(&c.Namespace, &c.Worker, &c.Count); err != nil {
+			return nil, err
+		}
+		counts = append(counts, c)
+	}
+	return counts, rows.Err()
+}
+
+// WaitingParentCount returns how many incomplete tasks still have at least
+// one incomplete child.
+func (s *Storage) WaitingParentCount() (int, error) {
+	query := `
+		SELECT COUNT(DISTINCT c.parent_id)
+		FROM tasks c
+		JOIN tasks p ON p.id = c.parent_id
+		WHERE c.is_completed = FALSE AND p.is_completed = FALSE
+	`
+	var count int
+	err := s.db.QueryRow(query).Scan(&count)
+	return count, err
+}
+
+// WorkerPendingCount returns the number of incomplete tasks of the worker
+// in the namespace.
+func (s *Storage) WorkerPendingCount(namespace string, worker string) (int, error) {
+	var count int
+	query := `SELECT COUNT(*) FROM tasks WHERE namespace = $1 AND worker = $2 AND is_completed = FALSE`
+	err := s.db.QueryRow(query, namespace, worker).Scan(&count)
+	return count, err
+}
+
+// TreeSize returns the number of tasks in the tree of the given root,
+// including the root.
+func (s *Storage) TreeSize(rootID string) (int, error) {
+	var count int
+	query := `SELECT COUNT(*) + 1 FROM tasks WHERE root_id = $1`
+	err := s.db.QueryRow(query, rootID).Scan(&count)
+	return count, err
+}
+
+func (s *Storage) ValidateWorker(namespace string, name string) (bool, error) {
 	var exists bool
-	query := `SELECT EXISTS(SELECT 1 FROM workers WHERE name = $1)`
-	err := s.db.QueryRow(query, name).Scan(&exists)
+	query := `SELECT EXISTS(SELECT 1 FROM workers WHERE namespace = $1 AND name = $2)`
+	err := s.db.QueryRow(query, namespace, name).Scan(&exists)
 	return exists, err
 }
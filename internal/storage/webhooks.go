@@ -0,0 +1,141 @@
+package storage
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+)
+
+// Delivery is a webhook notification waiting to be sent.
+type Delivery struct {
+	ID     string
+	TaskID string
+	Event  string
+	URL    string
+	Body   json.RawMessage
+	// Attempts is the number of attempts made before this one.
+	Attempts int
+}
+
+// DeliveryAttempt is the outcome of one attempt to send a delivery.
+type DeliveryAttempt struct {
+	DeliveryID string
+	// StatusCode is the response status, 0 if there was no response.
+	StatusCode int
+	Error      string
+	Duration   time.Duration
+}
+
+// CreateDelivery schedules a notification for the event of the task to be
+// sent right away. A task's event is only ever notified once, later calls
+// for the same event are ignored.
+func (s *Storage) CreateDelivery(taskID string, event string, url string, body json.RawMessage) error {
+	query := `
+		INSERT INTO webhook_deliveries (task_id, event, url, body, next_attempt_at)
+		VALUES ($1, $2, $3, $4, NOW())
+		ON CONFLICT (task_id, event) DO NOTHING
+	`
+	_, err := s.db.Exec(query, taskID, event, url, []byte(body))
+	return err
+}
+
+// ClaimDeliveries returns up to limit deliveries that are due, oldest
+// first, and holds them back for lease so that no other caller sends them
+// meanwhile. Each attempt must be recorded before the lease runs out, or
+// the delivery is handed out again.
+func (s *Storage) ClaimDeliveries(limit int, lease time.Duration) ([]*Delivery, error) {
+	query := `
+		UPDATE webhook_deliveries SET next_attempt_at = NOW() + make_interval(secs => $2)
+		WHERE id IN (
+			SELECT id FROM webhook_deliveries
+			WHERE next_attempt_at <= NOW()
+			ORDER BY next_attempt_at
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, task_id, event, url, body, attempts
+	`
+	rows, err := s.db.Query(query, limit, lease.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var deliveries []*Delivery
+	for rows.Next() {
+		d := &Delivery{}
+		var body []byte
+		if err := rows.Scan(&d.ID, &d.TaskID, &d.Event, &d.URL, &body, &d.Attempts); err != nil {
+			return nil, err
+		}
+		d.Body = body
+		deliveries = append(deliveries, d)
+	}
+	return deliveries, rows.Err()
+}
+
+// RecordDelivered stores a successful attempt and marks its delivery done.
+func (s *Storage) RecordDelivered(a *DeliveryAttempt) error {
+	return s.recordAttempt(a, `
+		UPDATE webhook_deliveries SET attempts = attempts + 1, delivered_at = NOW(), next_attempt_at = NULL
+		WHERE id = $1
+	`)
+}
+
+// RecordFailedAttempt stores a failed attempt. The delivery is tried again
+// at retryAt, or given up for good if retryAt is nil.
+func (s *Storage) RecordFailedAttempt(a *DeliveryAttempt, retryAt *time.Time) error {
+	if retryAt == nil {
+		return s.recordAttempt(a, `
+			UPDATE webhook_deliveries SET attempts = attempts + 1, failed_at = NOW(), next_attempt_at = NULL
+			WHERE id = $1
+		`)
+	}
+	return s.recordAttempt(a, `
+		UPDATE webhook_deliveries SET attempts = attempts + 1, next_attempt_at = $2
+		WHERE id = $1
+	`, *retryAt)
+}
+
+// recordAttempt inserts the attempt and applies update, which receives the
+// delivery id as $1, in one transaction.
+func (s *Storage) recordAttempt(a *DeliveryAttempt, update string, args ...interface{}) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		INSERT INTO webhook_attempts (delivery_id, status_code, error, duration_ms)
+		VALUES ($1, NULLIF($2, 0), NULLIF($3, ''), $4)
+	`
+	if _, err := tx.Exec(query, a.DeliveryID, a.StatusCode, a.Error, a.Duration.Milliseconds()); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(update, append([]interface{}{a.DeliveryID}, args...)...); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// SubtreeFinished reports whether the task and all its descendants are
+// completed or failed. It returns sql.ErrNoRows if there is no such task.
+func (s *Storage) SubtreeFinished(id string) (bool, error) {
+	query := `
+		WITH RECURSIVE subtree AS (
+			SELECT id, is_completed FROM tasks WHERE id = $1
+			UNION ALL
+			SELECT t.id, t.is_completed FROM tasks t JOIN subtree ON t.parent_id = subtree.id
+		)
+		SELECT bool_and(is_completed) FROM subtree
+	`
+	var finished sql.NullBool
+	if err := s.db.QueryRow(query, id).Scan(&finished); err != nil {
+		return false, translateError(err)
+	}
+	if !finished.Valid {
+		return false, sql.ErrNoRows
+	}
+	return finished.Bool, nil
+}
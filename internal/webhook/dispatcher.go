@@ -0,0 +1,210 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"sync"
+	"syscall"
+	"task-api/internal/metrics"
+	"task-api/internal/storage"
+	"time"
+)
+
+// Headers sent with every webhook.
+const (
+	HeaderID        = "X-Webhook-Id"
+	HeaderEvent     = "X-Webhook-Event"
+	HeaderTimestamp = "X-Webhook-Timestamp"
+	HeaderSignature = "X-Webhook-Signature"
+)
+
+const (
+	// batchSize is how many due deliveries are sent at once.
+	batchSize = 20
+	// firstRetry is the delay after the first failed attempt. It doubles
+	// with every further failure, up to maxRetry.
+	firstRetry = 5 * time.Second
+	maxRetry   = time.Hour
+)
+
+// Options tune the dispatcher.
+type Options struct {
+	// Secret signs every webhook.
+	Secret string
+	// AllowNetworks are loopback, link-local or private networks webhooks
+	// may be sent to anyway. Other addresses of that kind are refused.
+	AllowNetworks []netip.Prefix
+	// MaxAttempts is how often a delivery is tried before it is given up.
+	MaxAttempts int
+	// Timeout bounds a single attempt.
+	Timeout time.Duration
+}
+
+// Dispatcher sends the deliveries stored in the database. Several API
+// replicas may run one at the same time; each delivery is sent by one of
+// them at a time.
+type Dispatcher struct {
+	store  *storage.Storage
+	client *http.Client
+	opts   Options
+}
+
+func NewDispatcher(store *storage.Storage, opts Options) *Dispatcher {
+	d := &Dispatcher{store: store, opts: opts}
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control:   d.checkAddress,
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// A proxy would connect to the receiver past checkAddress
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	d.client = &http.Client{Timeout: opts.Timeout, Transport: transport}
+	return d
+}
+
+// errAddressNotAllowed fails attempts to reach internal addresses.
+var errAddressNotAllowed = errors.New("receiver address not allowed")
+
+// checkAddress refuses connections to loopback, link-local, private and
+// unspecified addresses outside Options.AllowNetworks, so that callers
+// cannot make the API reach internal services. It runs on the resolved
+// address of every connection, redirects included.
+func (d *Dispatcher) checkAddress(network, address string, _ syscall.RawConn) error {
+	ap, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return err
+	}
+	ip := ap.Addr().Unmap()
+	for _, p := range d.opts.AllowNetworks {
+		if p.Contains(ip) {
+			return nil
+		}
+	}
+	if ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsPrivate() || ip.IsUnspecified() {
+		return fmt.Errorf("%w: %s", errAddressNotAllowed, ip)
+	}
+	return nil
+}
+
+// Run sends due deliveries, checking at the given interval until ctx is
+// cancelled.
+func (d *Dispatcher) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		// Keep going while full batches come back, there may be more due
+		for {
+			// Held back long enough for the slowest attempt to be recorded
+			deliveries, err := d.store.ClaimDeliveries(batchSize, d.opts.Timeout+time.Minute)
+			if err != nil {
+				slog.Error("Error claiming webhook deliveries", "error", err)
+				break
+			}
+
+			var wg sync.WaitGroup
+			for _, del := range deliveries {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					d.deliver(ctx, del)
+				}()
+			}
+			wg.Wait()
+
+			if len(deliveries) < batchSize || ctx.Err() != nil {
+				break
+			}
+		}
+	}
+}
+
+// deliver makes one attempt to send the delivery and records the outcome.
+func (d *Dispatcher) deliver(ctx context.Context, del *storage.Delivery) {
+	start := time.Now()
+	status, err := d.send(ctx, del)
+	attempt := &storage.DeliveryAttempt{
+		DeliveryID: del.ID,
+		StatusCode: status,
+		Duration:   time.Since(start),
+	}
+	log := slog.With("delivery_id", del.ID, "task_id", del.TaskID, "event", del.Event)
+
+	if err == nil {
+		metrics.WebhookAttempts.WithLabelValues(del.Event, "success").Inc()
+		if err := d.store.RecordDelivered(attempt); err != nil {
+			log.Error("Error recording webhook delivery", "error", err)
+		}
+		return
+	}
+
+	attempt.Error = err.Error()
+	var retryAt *time.Time
+	if attempts := del.Attempts + 1; attempts < d.opts.MaxAttempts {
+		t := time.Now().Add(backoff(attempts))
+		retryAt = &t
+		metrics.WebhookAttempts.WithLabelValues(del.Event, "retry").Inc()
+		log.Warn("Webhook delivery failed, retrying", "attempts", attempts, "retry_at", t, "error", err)
+	} else {
+		metrics.WebhookAttempts.WithLabelValues(del.Event, "failure").Inc()
+		log.Error("Webhook delivery failed, giving up", "attempts", attempts, "error", err)
+	}
+	if err := d.store.RecordFailedAttempt(attempt, retryAt); err != nil {
+		log.Error("Error recording webhook attempt", "error", err)
+	}
+}
+
+// send POSTs the delivery and returns the response status. Any status
+// other than 2xx is an error.
+func (d *Dispatcher) send(ctx context.Context, del *storage.Delivery) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, del.URL, bytes.NewReader(del.Body))
+	if err != nil {
+		return 0, err
+	}
+	now := time.Now()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "task-api-webhook")
+	req.Header.Set(HeaderID, del.ID)
+	req.Header.Set(HeaderEvent, del.Event)
+	req.Header.Set(HeaderTimestamp, strconv.FormatInt(now.Unix(), 10))
+	req.Header.Set(HeaderSignature, Sign(d.opts.Secret, now, del.Body))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("receiver answered %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// backoff returns the delay before the next attempt after the given number
+// of failed ones.
+func backoff(attempts int) time.Duration {
+	delay := firstRetry
+	for i := 1; i < attempts && delay < maxRetry; i++ {
+		delay *= 2
+	}
+	return min(delay, maxRetry)
+}
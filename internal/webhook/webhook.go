@@ -0,0 +1,111 @@
+// Package webhook notifies the submitters of tasks about task events by
+// POSTing signed JSON to the callback URL of the task.
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"task-api/internal/storage"
+	"time"
+)
+
+// Events a callback can be registered for.
+const (
+	EventCompleted = "completed"
+	EventFailed    = "failed"
+	EventCancelled = "cancelled"
+	// EventSubtreeCompleted fires once the task and all its descendants
+	// are finished.
+	EventSubtreeCompleted = "subtree_completed"
+)
+
+// DefaultEvents are notified when a task has a callback URL but names no
+// events.
+var DefaultEvents = []string{EventCompleted, EventFailed}
+
+// ValidEvent reports whether a callback can be registered for event.
+func ValidEvent(event string) bool {
+	switch event {
+	case EventCompleted, EventFailed, EventCancelled, EventSubtreeCompleted:
+		return true
+	}
+	return false
+}
+
+// ValidURL reports whether u can receive webhooks: an absolute http or
+// https URL.
+func ValidURL(u string) bool {
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return false
+	}
+	return (parsed.Scheme == "http" || parsed.Scheme == "https") && parsed.Host != ""
+}
+
+// Wants reports whether the task has a callback for event.
+func Wants(t *storage.Task, event string) bool {
+	if t.CallbackURL == nil {
+		return false
+	}
+	for _, e := range t.CallbackEvents {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// Notification is the body of every webhook.
+type Notification struct {
+	Event string    `json:"event"`
+	Task  TaskState `json:"task"`
+}
+
+// TaskState is the task as reported in a notification, without its payload.
+type TaskState struct {
+	ID          string          `json:"id"`
+	Namespace   string          `json:"namespace"`
+	ParentID    *string         `json:"parent_id,omitempty"`
+	RootID      *string         `json:"root_id,omitempty"`
+	Worker      string          `json:"worker"`
+	Status      string          `json:"status"`
+	Result      json.RawMessage `json:"result,omitempty"`
+	Error       *string         `json:"error,omitempty"`
+	CreatedAt   time.Time       `json:"created_at"`
+	CompletedAt *time.Time      `json:"completed_at,omitempty"`
+}
+
+// Body returns the notification of event for the task.
+func Body(event string, t *storage.Task) (json.RawMessage, error) {
+	return json.Marshal(Notification{
+		Event: event,
+		Task: TaskState{
+			ID:          t.ID,
+			Namespace:   t.Namespace,
+			ParentID:    t.ParentID,
+			RootID:      t.RootID,
+			Worker:      t.Worker,
+			Status:      t.Status,
+			Result:      t.Result,
+			Error:       t.Error,
+			CreatedAt:   t.CreatedAt,
+			CompletedAt: t.CompletedAt,
+		},
+	})
+}
+
+// Sign returns the signature sent in the X-Webhook-Signature header:
+// "sha256=" and the hex HMAC-SHA256 of "<timestamp>.<body>" under secret.
+// Receivers recompute it to check that a webhook is genuine, and reject
+// stale timestamps to prevent replays.
+func Sign(secret string, timestamp time.Time, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%s.", strconv.FormatInt(timestamp.Unix(), 10))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS idx_tasks_worker_queued_at;
+
+ALTER TABLE tasks DROP COLUMN IF EXISTS leased_until;
+ALTER TABLE tasks DROP COLUMN IF EXISTS queued_payload;
+ALTER TABLE tasks DROP COLUMN IF EXISTS queued_at;
@@ -0,0 +1,6 @@
+-- Pull mode (BROKER=postgres) and long-poll: message body waiting to be claimed and its lease
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS queued_at TIMESTAMPTZ;
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS queued_payload JSONB;
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS leased_until TIMESTAMPTZ;
+
+CREATE INDEX IF NOT EXISTS idx_tasks_worker_queued_at ON tasks(worker, queued_at) WHERE queued_at IS NOT NULL AND is_completed = FALSE;
@@ -0,0 +1,3 @@
+ALTER TABLE tasks DROP COLUMN IF EXISTS completed_at;
+ALTER TABLE tasks DROP COLUMN IF EXISTS error;
+ALTER TABLE tasks DROP COLUMN IF EXISTS status;
@@ -0,0 +1,7 @@
+-- Terminal state of a task. is_completed stays TRUE for every finished task
+-- (completed or failed) so sibling counting does not change.
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS status VARCHAR(16) NOT NULL DEFAULT 'pending';
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS error TEXT;
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS completed_at TIMESTAMPTZ;
+
+UPDATE tasks SET status = 'completed' WHERE is_completed = TRUE AND status = 'pending';
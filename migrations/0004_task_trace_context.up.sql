@@ -0,0 +1,3 @@
+-- W3C traceparent of the span that created the task, so children and
+-- completions without incoming trace headers join the same trace.
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS trace_context TEXT;
@@ -0,0 +1,13 @@
+-- Credentials for the HTTP API. Only the SHA-256 of each key is stored.
+-- Worker keys belong to one worker and may create tasks for the workers
+-- listed in delegates; admin keys belong to no worker.
+CREATE TABLE IF NOT EXISTS api_keys (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    key_hash CHAR(64) NOT NULL UNIQUE,
+    worker VARCHAR(255) REFERENCES workers(name) ON DELETE CASCADE,
+    is_admin BOOLEAN NOT NULL DEFAULT FALSE,
+    delegates TEXT[] NOT NULL DEFAULT '{}',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    revoked_at TIMESTAMPTZ,
+    CHECK (is_admin = (worker IS NULL))
+);
@@ -0,0 +1,21 @@
+-- Fails if a worker name is used in more than one namespace
+DROP INDEX IF EXISTS idx_tasks_namespace_worker_queued_at;
+CREATE INDEX IF NOT EXISTS idx_tasks_worker_queued_at ON tasks(worker, queued_at) WHERE queued_at IS NOT NULL AND is_completed = FALSE;
+
+DROP INDEX IF EXISTS idx_tasks_namespace_worker_is_completed;
+CREATE INDEX IF NOT EXISTS idx_tasks_worker_is_completed ON tasks(worker, is_completed);
+
+ALTER TABLE api_keys DROP CONSTRAINT IF EXISTS api_keys_worker_fkey;
+ALTER TABLE api_keys DROP CONSTRAINT IF EXISTS api_keys_namespace_check;
+ALTER TABLE api_keys DROP COLUMN IF EXISTS namespace;
+ALTER TABLE tasks DROP CONSTRAINT IF EXISTS tasks_worker_fkey;
+ALTER TABLE tasks DROP COLUMN IF EXISTS namespace;
+
+ALTER TABLE workers DROP CONSTRAINT IF EXISTS workers_pkey;
+ALTER TABLE workers DROP COLUMN IF EXISTS namespace;
+ALTER TABLE workers ADD PRIMARY KEY (name);
+
+ALTER TABLE tasks ADD CONSTRAINT tasks_worker_fkey FOREIGN KEY (worker) REFERENCES workers(name);
+ALTER TABLE api_keys ADD CONSTRAINT api_keys_worker_fkey FOREIGN KEY (worker) REFERENCES workers(name) ON DELETE CASCADE;
+
+DROP TABLE IF EXISTS namespaces;
@@ -0,0 +1,35 @@
+-- Tenants. Worker names are unique within a namespace, and tasks and
+-- worker keys belong to the namespace of their worker. Everything that
+-- existed before moves to 'default'.
+CREATE TABLE IF NOT EXISTS namespaces (
+    name VARCHAR(63) PRIMARY KEY CHECK (name ~ '^[a-z0-9][a-z0-9_-]{0,62}$'),
+    -- Maximum incomplete tasks, NULL for the server-wide default
+    max_pending INT CHECK (max_pending >= 0),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+INSERT INTO namespaces (name) VALUES ('default') ON CONFLICT DO NOTHING;
+
+ALTER TABLE tasks DROP CONSTRAINT IF EXISTS tasks_worker_fkey;
+ALTER TABLE api_keys DROP CONSTRAINT IF EXISTS api_keys_worker_fkey;
+
+ALTER TABLE workers ADD COLUMN IF NOT EXISTS namespace VARCHAR(63) NOT NULL DEFAULT 'default' REFERENCES namespaces(name);
+ALTER TABLE workers DROP CONSTRAINT IF EXISTS workers_pkey;
+ALTER TABLE workers ADD PRIMARY KEY (namespace, name);
+
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS namespace VARCHAR(63) NOT NULL DEFAULT 'default';
+ALTER TABLE tasks ADD CONSTRAINT tasks_worker_fkey
+    FOREIGN KEY (namespace, worker) REFERENCES workers(namespace, name);
+
+-- Admin keys without a namespace are global; worker keys always have one
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS namespace VARCHAR(63) DEFAULT 'default' REFERENCES namespaces(name);
+UPDATE api_keys SET namespace = NULL WHERE is_admin;
+ALTER TABLE api_keys ADD CONSTRAINT api_keys_namespace_check CHECK (is_admin OR namespace IS NOT NULL);
+ALTER TABLE api_keys ADD CONSTRAINT api_keys_worker_fkey
+    FOREIGN KEY (namespace, worker) REFERENCES workers(namespace, name) ON DELETE CASCADE;
+
+DROP INDEX IF EXISTS idx_tasks_worker_is_completed;
+CREATE INDEX IF NOT EXISTS idx_tasks_namespace_worker_is_completed ON tasks(namespace, worker, is_completed);
+
+DROP INDEX IF EXISTS idx_tasks_worker_queued_at;
+CREATE INDEX IF NOT EXISTS idx_tasks_namespace_worker_queued_at ON tasks(namespace, worker, queued_at) WHERE queued_at IS NOT NULL AND is_completed = FALSE;
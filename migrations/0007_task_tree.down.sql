@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_tasks_root_id;
+ALTER TABLE tasks DROP COLUMN IF EXISTS depth;
+ALTER TABLE tasks DROP COLUMN IF EXISTS root_id;
@@ -0,0 +1,16 @@
+-- Position of a task in its tree, so tree limits need no recursive query.
+-- root_id is NULL for roots, depth is 0 for roots.
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS root_id UUID;
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS depth INT NOT NULL DEFAULT 0;
+
+WITH RECURSIVE tree AS (
+    SELECT id, id AS root_id, 0 AS depth FROM tasks WHERE parent_id IS NULL
+    UNION ALL
+    SELECT t.id, tree.root_id, tree.depth + 1
+    FROM tasks t JOIN tree ON t.parent_id = tree.id
+)
+UPDATE tasks SET root_id = tree.root_id, depth = tree.depth
+FROM tree
+WHERE tasks.id = tree.id AND tree.depth > 0;
+
+CREATE INDEX IF NOT EXISTS idx_tasks_root_id ON tasks(root_id) WHERE root_id IS NOT NULL;
@@ -0,0 +1,33 @@
+-- Where and for which events the submitter of a task wants to be notified
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS callback_url TEXT;
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS callback_events TEXT[];
+
+-- One notification per task event, retried until delivered or given up
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    task_id UUID NOT NULL REFERENCES tasks(id) ON DELETE CASCADE,
+    event VARCHAR(32) NOT NULL,
+    url TEXT NOT NULL,
+    body JSONB NOT NULL,
+    attempts INT NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMPTZ,
+    delivered_at TIMESTAMPTZ,
+    failed_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    UNIQUE (task_id, event)
+);
+
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_next_attempt_at
+    ON webhook_deliveries(next_attempt_at) WHERE next_attempt_at IS NOT NULL;
+
+-- Every delivery attempt with its outcome
+CREATE TABLE IF NOT EXISTS webhook_attempts (
+    id BIGSERIAL PRIMARY KEY,
+    delivery_id UUID NOT NULL REFERENCES webhook_deliveries(id) ON DELETE CASCADE,
+    status_code INT,
+    error TEXT,
+    duration_ms INT NOT NULL,
+    attempted_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_webhook_attempts_delivery_id ON webhook_attempts(delivery_id);
@@ -0,0 +1,3 @@
+-- Latest progress report of a running task
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS progress JSONB;
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS progress_at TIMESTAMPTZ;
@@ -0,0 +1 @@
+DROP INDEX IF EXISTS idx_tasks_namespace_created_at_id;
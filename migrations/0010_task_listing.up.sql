@@ -0,0 +1,2 @@
+-- Listing tasks of a namespace oldest first, page by page
+CREATE INDEX IF NOT EXISTS idx_tasks_namespace_created_at_id ON tasks(namespace, created_at, id);
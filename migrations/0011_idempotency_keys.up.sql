@@ -0,0 +1,4 @@
+-- Key of the create request, so that a retried create returns the same task
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS idempotency_key TEXT;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_tasks_namespace_idempotency_key ON tasks(namespace, idempotency_key)
+    WHERE idempotency_key IS NOT NULL;
@@ -0,0 +1,2 @@
+-- Tasks stored but not queued, so that a retried create queues them
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS publish_failed BOOLEAN NOT NULL DEFAULT FALSE;
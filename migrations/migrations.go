@@ -0,0 +1,9 @@
+// Package migrations embeds the numbered schema migrations applied by
+// storage.MigrateUp. Files are named <version>_<name>.up.sql with a matching
+// <version>_<name>.down.sql.
+package migrations
+
+import "embed"
+
+//go:embed *.sql
+var FS embed.FS
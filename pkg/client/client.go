@@ -0,0 +1,235 @@
+// Package client is a typed Go client for the Task API. Every endpoint is
+// a method of Client taking a context; error responses are returned as
+// *Error with the API's error code.
+//
+// Calls that are safe to repeat are retried while the API is overloaded or
+// unreachable, see Options.MaxRetries. Creates are made safe to repeat with
+// an Idempotency-Key, which CreateTask generates unless one is given.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Options configures a Client.
+type Options struct {
+	// APIKey is sent as a Bearer token if set: an API key or a JWT.
+	APIKey string
+	// Namespace is sent as X-Namespace if set, for credentials that are not
+	// tied to one.
+	Namespace string
+	// HTTPClient makes the requests, by default http.DefaultClient.
+	HTTPClient *http.Client
+	// MaxRetries is how often a failed call is made again, by default 3.
+	// A negative value disables retries.
+	MaxRetries int
+	// Backoff is the wait before the first retry, by default 500ms. It
+	// doubles with every retry, with jitter, and a longer Retry-After of
+	// the API takes precedence.
+	Backoff time.Duration
+}
+
+// Client calls the API at one base URL. It is safe for concurrent use.
+type Client struct {
+	baseURL string
+	opts    Options
+	// header is sent with every request
+	header http.Header
+}
+
+// New returns a client for the API at baseURL, e.g. http://localhost:8080.
+func New(baseURL string, opts Options) *Client {
+	if opts.HTTPClient == nil {
+		opts.HTTPClient = http.DefaultClient
+	}
+	if opts.MaxRetries == 0 {
+		opts.MaxRetries = 3
+	}
+	if opts.MaxRetries < 0 {
+		opts.MaxRetries = 0
+	}
+	if opts.Backoff <= 0 {
+		opts.Backoff = 500 * time.Millisecond
+	}
+	return &Client{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		opts:    opts,
+		header:  http.Header{},
+	}
+}
+
+// With returns a copy of the client that sends the header with every
+// request, or no longer sends it if value is empty.
+func (c *Client) With(header, value string) *Client {
+	cp := *c
+	cp.header = c.header.Clone()
+	if value == "" {
+		cp.header.Del(header)
+	} else {
+		cp.header.Set(header, value)
+	}
+	return &cp
+}
+
+// WithKey returns a copy of the client that authenticates with key.
+func (c *Client) WithKey(key string) *Client {
+	cp := *c
+	cp.opts.APIKey = key
+	return &cp
+}
+
+// WithNamespace returns a copy of the client for the namespace.
+func (c *Client) WithNamespace(namespace string) *Client {
+	cp := *c
+	cp.opts.Namespace = namespace
+	return &cp
+}
+
+// request is one API call.
+type request struct {
+	method string
+	path   string
+	query  url.Values
+	// body is sent as JSON if not nil
+	body   any
+	header http.Header
+	// idempotent calls are retried after network errors and 502, 503 and
+	// 504 as well, all others only after 429.
+	idempotent bool
+}
+
+// setHeaders adds the headers every request carries.
+func (c *Client) setHeaders(h http.Header) {
+	for k, v := range c.header {
+		h[k] = v
+	}
+	if c.opts.APIKey != "" {
+		h.Set("Authorization", "Bearer "+c.opts.APIKey)
+	}
+	if c.opts.Namespace != "" {
+		h.Set("X-Namespace", c.opts.Namespace)
+	}
+}
+
+// do makes the call, retrying as the options allow. It returns the
+// response of a successful call, whose body the caller closes, or an
+// *Error for an error response.
+func (c *Client) do(ctx context.Context, r *request) (*http.Response, error) {
+	var body []byte
+	if r.body != nil {
+		b, err := json.Marshal(r.body)
+		if err != nil {
+			return nil, err
+		}
+		body = b
+	}
+
+	for attempt := 0; ; attempt++ {
+		resp, err := c.send(ctx, r, body)
+		if err == nil {
+			if resp.StatusCode < 300 {
+				return resp, nil
+			}
+			err = readError(resp)
+		}
+		if attempt == c.opts.MaxRetries || !retryable(err, r.idempotent) || ctx.Err() != nil {
+			return nil, err
+		}
+
+		wait := c.opts.Backoff << attempt
+		wait = wait/2 + rand.N(wait)
+		var e *Error
+		if errors.As(err, &e) && e.RetryAfter > wait {
+			wait = e.RetryAfter
+		}
+		t := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return nil, err
+		case <-t.C:
+		}
+	}
+}
+
+// send makes one attempt of the call.
+func (c *Client) send(ctx context.Context, r *request, body []byte) (*http.Response, error) {
+	u := c.baseURL + r.path
+	if len(r.query) > 0 {
+		u += "?" + r.query.Encode()
+	}
+	var b io.Reader
+	if body != nil {
+		b = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, r.method, u, b)
+	if err != nil {
+		return nil, err
+	}
+	c.setHeaders(req.Header)
+	for k, v := range r.header {
+		req.Header[k] = v
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return c.opts.HTTPClient.Do(req)
+}
+
+// retryable reports whether a call that failed with err may succeed if it
+// is made again.
+func retryable(err error, idempotent bool) bool {
+	var e *Error
+	if !errors.As(err, &e) {
+		// The request may or may not have been handled
+		return idempotent
+	}
+	switch e.Status {
+	case http.StatusTooManyRequests:
+		// Rejected before anything was done
+		return true
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return idempotent
+	}
+	return false
+}
+
+// call makes the call and decodes a JSON answer into out, if given. It
+// returns the status of the answer.
+func (c *Client) call(ctx context.Context, r *request, out any) (int, error) {
+	resp, err := c.do(ctx, r)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if out == nil || resp.StatusCode == http.StatusNoContent {
+		io.Copy(io.Discard, resp.Body)
+		return resp.StatusCode, nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return 0, err
+	}
+	return resp.StatusCode, nil
+}
+
+// Healthz checks that the API process is up.
+func (c *Client) Healthz(ctx context.Context) error {
+	_, err := c.call(ctx, &request{method: http.MethodGet, path: "/healthz", idempotent: true}, nil)
+	return err
+}
+
+// Readyz checks that the API can reach its database and broker. It is not
+// retried, an unready API answers 503.
+func (c *Client) Readyz(ctx context.Context) error {
+	_, err := c.call(ctx, &request{method: http.MethodGet, path: "/readyz"}, nil)
+	return err
+}
@@ -0,0 +1,118 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Error codes of the API, in the "code" field of its error responses. They
+// are stable; AGENT_GUIDE.md explains each one.
+const (
+	CodeInternal             = "internal_error"
+	CodeInvalidBody          = "invalid_body"
+	CodeNotFound             = "not_found"
+	CodeMethodNotAllowed     = "method_not_allowed"
+	CodeDatabaseUnavailable  = "database_unavailable"
+	CodeQueueUnavailable     = "queue_unavailable"
+	CodeUnauthorized         = "unauthorized"
+	CodeForbidden            = "forbidden"
+	CodeInvalidNamespace     = "invalid_namespace"
+	CodeQuotaExceeded        = "pending_quota_exceeded"
+	CodeWorkerPendingLimit   = "worker_pending_limit"
+	CodeRateLimited          = "rate_limited"
+	CodeWorkerNameRequired   = "worker_name_required"
+	CodeWorkerNotFound       = "worker_not_found"
+	CodeTaskNotFound         = "task_not_found"
+	CodeTaskAlreadyCompleted = "task_already_completed"
+	CodeTaskHasNoChildren    = "task_has_no_children"
+	CodeInvalidParentID      = "invalid_parent_id"
+	CodeParentNotFound       = "parent_not_found"
+	CodeParentCompleted      = "parent_completed"
+	CodeParentFailed         = "parent_failed"
+	CodeTreeDepthExceeded    = "tree_depth_exceeded"
+	CodeTreeSizeExceeded     = "tree_size_exceeded"
+	CodeInvalidCallbackURL   = "invalid_callback_url"
+	CodeInvalidCallbackEvent = "invalid_callback_event"
+	CodeInvalidStatus        = "invalid_status"
+	CodeInvalidPageToken     = "invalid_page_token"
+	CodeInvalidOrder         = "invalid_order"
+	CodeQueuePublishFailed   = "queue_publish_failed"
+	CodeErrorRequired        = "error_required"
+	CodeInvalidLimit         = "invalid_limit"
+	CodeInvalidWait          = "invalid_wait"
+	CodeInvalidPrefetch      = "invalid_prefetch"
+	CodeInvalidMessageType   = "invalid_message_type"
+	CodeClaimUnavailable     = "claim_unavailable"
+	CodePullUnavailable      = "pull_unavailable"
+	CodeEventsUnavailable    = "events_unavailable"
+	CodeWebhooksUnavailable  = "webhooks_unavailable"
+	CodeInvalidSubtree       = "invalid_subtree"
+	CodeProgressTooLarge     = "progress_too_large"
+	CodeInvalidFormat        = "invalid_format"
+	CodeInvalidDurations     = "invalid_durations"
+
+	// Idempotency-Key of task creates
+	CodeInvalidIdempotencyKey = "invalid_idempotency_key"
+	CodeIdempotencyKeyReused  = "idempotency_key_reused"
+
+	// Operator endpoints
+	CodeTaskNotRetryable  = "task_not_retryable"
+	CodeInvalidRootID     = "invalid_root_id"
+	CodeInvalidWorkerName = "invalid_worker_name"
+)
+
+// Error is an error response of the API.
+type Error struct {
+	// Status is the HTTP status of the response.
+	Status int
+	// Code is one of the Code constants, empty if the response had no
+	// JSON error body, as from a proxy.
+	Code    string
+	Message string
+	// RetryAfter is the Retry-After of the response, if any.
+	RetryAfter time.Duration
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("task api: %d %s: %s", e.Status, e.Code, e.Message)
+}
+
+// IsCode reports whether err is an *Error with the given code.
+func IsCode(err error, code string) bool {
+	var e *Error
+	return errors.As(err, &e) && e.Code == code
+}
+
+// maxErrorBody bounds how much of an error response is read.
+const maxErrorBody = 64 << 10
+
+// readError turns an error response into an *Error and closes its body.
+func readError(resp *http.Response) *Error {
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+
+	e := &Error{Status: resp.StatusCode}
+	var r struct {
+		Error struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if json.Unmarshal(data, &r) == nil && r.Error.Code != "" {
+		e.Code, e.Message = r.Error.Code, r.Error.Message
+	} else if msg := strings.TrimSpace(string(data)); msg != "" {
+		e.Message = msg
+	} else {
+		e.Message = http.StatusText(resp.StatusCode)
+	}
+	if s, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+		e.RetryAfter = time.Duration(s) * time.Second
+	}
+	return e
+}
@@ -0,0 +1,79 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// TaskEvent is a change of a task.
+type TaskEvent struct {
+	// Type is created, queued, progress, completed, failed or cancelled.
+	Type      string `json:"event"`
+	TaskID    string `json:"task_id"`
+	Namespace string `json:"namespace"`
+	ParentID  string `json:"parent_id,omitempty"`
+	Worker    string `json:"worker"`
+	Status    string `json:"status,omitempty"`
+	// Progress is set for progress events.
+	Progress json.RawMessage `json:"progress,omitempty"`
+	At       time.Time       `json:"at"`
+}
+
+// EventStream is an open stream of task events.
+type EventStream struct {
+	body    io.ReadCloser
+	scanner *bufio.Scanner
+}
+
+// Events follows the events of a task, and with subtree those of all its
+// descendants. The stream is open once Events returns, so no later change
+// is missed.
+func (c *Client) Events(ctx context.Context, id string, subtree bool) (*EventStream, error) {
+	r := &request{
+		method:     http.MethodGet,
+		path:       "/task/" + url.PathEscape(id) + "/events",
+		header:     http.Header{"Accept": {"text/event-stream"}},
+		idempotent: true,
+	}
+	if subtree {
+		r.query = url.Values{"subtree": {"true"}}
+	}
+	resp, err := c.do(ctx, r)
+	if err != nil {
+		return nil, err
+	}
+	return &EventStream{body: resp.Body, scanner: bufio.NewScanner(resp.Body)}, nil
+}
+
+// Next returns the next event. It returns io.EOF once the task, or the
+// whole subtree, is finished. The API also ends streams if events may have
+// been lost; read the task again before following it anew.
+func (s *EventStream) Next() (*TaskEvent, error) {
+	for s.scanner.Scan() {
+		// Only data is read, it repeats the event type
+		data, ok := strings.CutPrefix(s.scanner.Text(), "data: ")
+		if !ok {
+			continue
+		}
+		var ev TaskEvent
+		if err := json.Unmarshal([]byte(data), &ev); err != nil {
+			return nil, err
+		}
+		return &ev, nil
+	}
+	if err := s.scanner.Err(); err != nil {
+		return nil, err
+	}
+	return nil, io.EOF
+}
+
+// Close closes the stream.
+func (s *EventStream) Close() error {
+	return s.body.Close()
+}
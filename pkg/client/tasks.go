@@ -0,0 +1,318 @@
+package client
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Task statuses.
+const (
+	StatusPending   = "pending"
+	StatusCompleted = "completed"
+	StatusFailed    = "failed"
+	StatusCancelled = "cancelled"
+)
+
+// Diagram formats of TaskTree.
+const (
+	DiagramDOT     = "dot"
+	DiagramMermaid = "mermaid"
+)
+
+// Task is a task as stored by the API.
+type Task struct {
+	ID        string `json:"id"`
+	Namespace string `json:"namespace"`
+	// ParentID and RootID are empty for roots.
+	ParentID string `json:"parent_id,omitempty"`
+	RootID   string `json:"root_id,omitempty"`
+	// Depth is the distance from the root.
+	Depth       int             `json:"depth"`
+	Worker      string          `json:"worker"`
+	Payload     json.RawMessage `json:"payload"`
+	Result      json.RawMessage `json:"result,omitempty"`
+	IsCompleted bool            `json:"is_completed"`
+	Status      string          `json:"status"`
+	Error       string          `json:"error,omitempty"`
+	CreatedAt   time.Time       `json:"created_at"`
+	CompletedAt *time.Time      `json:"completed_at,omitempty"`
+	// Progress is the latest progress report of the worker.
+	Progress       json.RawMessage `json:"progress,omitempty"`
+	ProgressAt     *time.Time      `json:"progress_at,omitempty"`
+	CallbackURL    string          `json:"callback_url,omitempty"`
+	CallbackEvents []string        `json:"callback_events,omitempty"`
+}
+
+// CreateTaskRequest describes a task to create.
+type CreateTaskRequest struct {
+	// ParentID makes the task a child of a pending task.
+	ParentID string `json:"parent_id,omitempty"`
+	// Payload is encoded as JSON, a json.RawMessage as is.
+	Payload any `json:"payload"`
+	// CallbackURL receives a webhook for each of CallbackEvents, by default
+	// completed and failed.
+	CallbackURL    string   `json:"callback_url,omitempty"`
+	CallbackEvents []string `json:"callback_events,omitempty"`
+	// IdempotencyKey makes creates with the same key in the namespace
+	// return the task of the first one. CreateTask generates one if empty,
+	// so that its own retries never create a task twice; set it to make
+	// retries of your own safe, e.g. across restarts.
+	IdempotencyKey string `json:"-"`
+}
+
+// TaskResult is the outcome of a task. Status is StatusPending if the task
+// was not finished within the wait.
+type TaskResult struct {
+	ID          string          `json:"id"`
+	Status      string          `json:"status"`
+	Result      json.RawMessage `json:"result,omitempty"`
+	Error       string          `json:"error,omitempty"`
+	CompletedAt *time.Time      `json:"completed_at,omitempty"`
+}
+
+// Decode decodes the result into v.
+func (r *TaskResult) Decode(v any) error {
+	return json.Unmarshal(r.Result, v)
+}
+
+// ListTasksRequest filters and pages ListTasks. All fields are optional.
+type ListTasksRequest struct {
+	Worker   string
+	Status   string
+	ParentID string
+	// RootID lists the descendants of a root task, not the root itself.
+	RootID string
+	// NewestFirst lists the newest tasks first. Keep it when paging.
+	NewestFirst bool
+	// PageSize is 50 by default, at most 100.
+	PageSize int
+	// PageToken is the NextPageToken of the previous page.
+	PageToken string
+}
+
+// ListTasksResponse is a page of tasks, oldest first unless NewestFirst was
+// set.
+type ListTasksResponse struct {
+	Tasks []Task `json:"tasks"`
+	// NextPageToken is empty on the last page.
+	NextPageToken string `json:"next_page_token,omitempty"`
+}
+
+// create makes a create call, with wait if it is positive.
+func (c *Client) create(ctx context.Context, worker string, req CreateTaskRequest, wait time.Duration, out any) (int, error) {
+	key := req.IdempotencyKey
+	if key == "" {
+		key = rand.Text()
+	}
+	r := &request{
+		method:     http.MethodPost,
+		path:       "/task/" + url.PathEscape(worker),
+		body:       req,
+		header:     http.Header{"Idempotency-Key": {key}},
+		idempotent: true,
+	}
+	if wait > 0 {
+		r.query = url.Values{"wait": {wait.String()}}
+	}
+	return c.call(ctx, r, out)
+}
+
+// CreateTask creates and queues a task for the worker and returns its id.
+func (c *Client) CreateTask(ctx context.Context, worker string, req CreateTaskRequest) (string, error) {
+	var created struct {
+		ID string `json:"id"`
+	}
+	if _, err := c.create(ctx, worker, req, 0, &created); err != nil {
+		return "", err
+	}
+	return created.ID, nil
+}
+
+// CreateTaskAndWait creates a task like CreateTask and waits up to wait,
+// at most 60s, for it and its descendants to finish.
+func (c *Client) CreateTaskAndWait(ctx context.Context, worker string, req CreateTaskRequest, wait time.Duration) (*TaskResult, error) {
+	var res TaskResult
+	if _, err := c.create(ctx, worker, req, wait, &res); err != nil {
+		return nil, err
+	}
+	if res.Status == "" {
+		// Created without a wait
+		res.Status = StatusPending
+	}
+	return &res, nil
+}
+
+// CompleteTask completes a task with result, encoded as JSON. A task is
+// completed only once; again it fails with CodeTaskAlreadyCompleted.
+func (c *Client) CompleteTask(ctx context.Context, id string, result any) error {
+	_, err := c.call(ctx, &request{
+		method:     http.MethodPost,
+		path:       "/task/" + url.PathEscape(id),
+		body:       map[string]any{"result": result},
+		idempotent: true,
+	}, nil)
+	return err
+}
+
+// FailTask marks a task as failed with the message.
+func (c *Client) FailTask(ctx context.Context, id string, message string) error {
+	_, err := c.call(ctx, &request{
+		method:     http.MethodPost,
+		path:       "/task/" + url.PathEscape(id) + "/fail",
+		body:       map[string]string{"error": message},
+		idempotent: true,
+	}, nil)
+	return err
+}
+
+// WaitForChildren hands back a task that created children instead of
+// finishing it. The API queues it again with their results once they are
+// done, and answers CodeTaskHasNoChildren for a task without any.
+func (c *Client) WaitForChildren(ctx context.Context, id string) error {
+	_, err := c.call(ctx, &request{
+		method:     http.MethodPost,
+		path:       "/task/" + url.PathEscape(id) + "/wait",
+		idempotent: true,
+	}, nil)
+	return err
+}
+
+// ReportProgress records how far the worker got with a task, as any JSON
+// value up to 4 KB.
+func (c *Client) ReportProgress(ctx context.Context, id string, progress any) error {
+	_, err := c.call(ctx, &request{
+		method:     http.MethodPost,
+		path:       "/task/" + url.PathEscape(id) + "/progress",
+		body:       map[string]any{"progress": progress},
+		idempotent: true,
+	}, nil)
+	return err
+}
+
+// CancelTask cancels a task and its unfinished descendants, with reason as
+// their error, "cancelled" if empty. It returns the cancelled ids, deepest
+// first.
+func (c *Client) CancelTask(ctx context.Context, id string, reason string) ([]string, error) {
+	var res struct {
+		Cancelled []string `json:"cancelled"`
+	}
+	_, err := c.call(ctx, &request{
+		method:     http.MethodPost,
+		path:       "/task/" + url.PathEscape(id) + "/cancel",
+		body:       map[string]string{"reason": reason},
+		idempotent: true,
+	}, &res)
+	if err != nil {
+		return nil, err
+	}
+	return res.Cancelled, nil
+}
+
+// RetryTask creates a new task with the worker, parent, payload and
+// callbacks of a failed or cancelled task and returns its id. Like
+// CreateTask it generates idempotencyKey if empty; pass one to make
+// retries of your own safe.
+func (c *Client) RetryTask(ctx context.Context, id string, idempotencyKey string) (string, error) {
+	if idempotencyKey == "" {
+		idempotencyKey = rand.Text()
+	}
+	var created struct {
+		ID string `json:"id"`
+	}
+	_, err := c.call(ctx, &request{
+		method:     http.MethodPost,
+		path:       "/task/" + url.PathEscape(id) + "/retry",
+		header:     http.Header{"Idempotency-Key": {idempotencyKey}},
+		idempotent: true,
+	}, &created)
+	if err != nil {
+		return "", err
+	}
+	return created.ID, nil
+}
+
+// GetTask returns a task.
+func (c *Client) GetTask(ctx context.Context, id string) (*Task, error) {
+	var t Task
+	_, err := c.call(ctx, &request{
+		method:     http.MethodGet,
+		path:       "/task/" + url.PathEscape(id),
+		idempotent: true,
+	}, &t)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// ListTasks returns a page of the tasks of the namespace.
+func (c *Client) ListTasks(ctx context.Context, req ListTasksRequest) (*ListTasksResponse, error) {
+	q := url.Values{}
+	for k, v := range map[string]string{"worker": req.Worker, "status": req.Status, "parent_id": req.ParentID, "root_id": req.RootID, "page_token": req.PageToken} {
+		if v != "" {
+			q.Set(k, v)
+		}
+	}
+	if req.NewestFirst {
+		q.Set("order", "newest")
+	}
+	if req.PageSize > 0 {
+		q.Set("page_size", strconv.Itoa(req.PageSize))
+	}
+	var res ListTasksResponse
+	_, err := c.call(ctx, &request{method: http.MethodGet, path: "/tasks", query: q, idempotent: true}, &res)
+	if err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// TaskTree renders a task and its descendants as a Graphviz DOT graph or a
+// Mermaid flowchart, format DiagramDOT or DiagramMermaid. With durations
+// each node shows how long its task ran.
+func (c *Client) TaskTree(ctx context.Context, id string, format string, durations bool) (string, error) {
+	q := url.Values{"format": {format}}
+	if durations {
+		q.Set("durations", "true")
+	}
+	resp, err := c.do(ctx, &request{
+		method:     http.MethodGet,
+		path:       "/task/" + url.PathEscape(id) + "/tree",
+		query:      q,
+		idempotent: true,
+	})
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// AwaitResult returns the outcome of a task once it and its descendants
+// are finished, waiting up to wait, at most 60s. With no wait it returns
+// right away.
+func (c *Client) AwaitResult(ctx context.Context, id string, wait time.Duration) (*TaskResult, error) {
+	r := &request{
+		method:     http.MethodGet,
+		path:       "/task/" + url.PathEscape(id) + "/result",
+		idempotent: true,
+	}
+	if wait > 0 {
+		r.query = url.Values{"wait": {wait.String()}}
+	}
+	var res TaskResult
+	if _, err := c.call(ctx, r, &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
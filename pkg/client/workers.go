@@ -0,0 +1,124 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Message is a task as workers receive it. A parent queued again has the
+// results of its children in the "subtasks" of its payload.
+type Message struct {
+	ID      string          `json:"id"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+// DefaultNamespace is the namespace of calls that name none.
+const DefaultNamespace = "default"
+
+// QueueName returns the broker queue the API publishes the tasks of a
+// worker in a namespace to. Workers in the default namespace, or in none,
+// keep their bare name.
+func QueueName(namespace, worker string) string {
+	if namespace == "" || namespace == DefaultNamespace {
+		return worker
+	}
+	return namespace + "." + worker
+}
+
+// Worker is a registered worker.
+type Worker struct {
+	Name string `json:"name"`
+	// Pending is the number of its incomplete tasks.
+	Pending int `json:"pending"`
+}
+
+// ListWorkers returns the workers of the namespace by name. Only admins may
+// list them.
+func (c *Client) ListWorkers(ctx context.Context) ([]Worker, error) {
+	var res struct {
+		Workers []Worker `json:"workers"`
+	}
+	_, err := c.call(ctx, &request{method: http.MethodGet, path: "/workers", idempotent: true}, &res)
+	if err != nil {
+		return nil, err
+	}
+	return res.Workers, nil
+}
+
+// RegisterWorker registers a worker in the namespace, so that tasks can be
+// created for it. Registering it again is not an error. Only admins may
+// register workers.
+func (c *Client) RegisterWorker(ctx context.Context, name string) error {
+	_, err := c.call(ctx, &request{
+		method:     http.MethodPut,
+		path:       "/workers/" + url.PathEscape(name),
+		idempotent: true,
+	}, nil)
+	return err
+}
+
+// ClaimTasks takes up to limit queued tasks of the worker, when the API
+// runs without a broker. It is only retried after 429: claimed tasks are
+// gone from the queue even if the answer is lost.
+func (c *Client) ClaimTasks(ctx context.Context, worker string, limit int) ([]Message, error) {
+	var res struct {
+		Tasks []Message `json:"tasks"`
+	}
+	_, err := c.call(ctx, &request{
+		method: http.MethodPost,
+		path:   "/workers/" + url.PathEscape(worker) + "/claim",
+		body:   map[string]int{"limit": limit},
+	}, &res)
+	if err != nil {
+		return nil, err
+	}
+	return res.Tasks, nil
+}
+
+// NextTask leases the next queued task of the worker, waiting up to wait,
+// at most 60s, for one to arrive. It returns nil if none did. The task is
+// queued again unless it is finished before its lease expires.
+func (c *Client) NextTask(ctx context.Context, worker string, wait time.Duration) (*Message, error) {
+	r := &request{
+		method: http.MethodGet,
+		path:   "/workers/" + url.PathEscape(worker) + "/next",
+		// A lost lease only delays the task
+		idempotent: true,
+	}
+	if wait > 0 {
+		r.query = url.Values{"wait": {wait.String()}}
+	}
+	var msg Message
+	status, err := c.call(ctx, r, &msg)
+	if err != nil || status == http.StatusNoContent {
+		return nil, err
+	}
+	return &msg, nil
+}
+
+// WorkerSocket connects to the WebSocket gateway as the worker, with up to
+// prefetch unfinished tasks at a time, 1 if not positive. AGENT_GUIDE.md
+// describes the messages.
+func (c *Client) WorkerSocket(ctx context.Context, worker string, prefetch int) (*websocket.Conn, error) {
+	u := "ws" + strings.TrimPrefix(c.baseURL, "http") + "/workers/" + url.PathEscape(worker) + "/ws"
+	if prefetch > 0 {
+		u += "?prefetch=" + strconv.Itoa(prefetch)
+	}
+	header := http.Header{}
+	c.setHeaders(header)
+	conn, resp, err := websocket.DefaultDialer.DialContext(ctx, u, header)
+	if err != nil {
+		if resp != nil && resp.StatusCode >= 300 {
+			return nil, readError(resp)
+		}
+		return nil, err
+	}
+	return conn, nil
+}
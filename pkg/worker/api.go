@@ -0,0 +1,45 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"task-api/pkg/client"
+)
+
+// api returns the client for calls made for the task, under its trace
+// context.
+func (w *Worker) api(t Task) *client.Client {
+	return w.client.With("traceparent", t.traceparent)
+}
+
+// report completes or fails a task, or hands it back to wait for its
+// children, with the client's retries. A task that is already finished, or
+// gone, counts as reported.
+func (w *Worker) report(ctx context.Context, t Task, err error, result Result) error {
+	switch {
+	case err != nil:
+		message := err.Error()
+		if message == "" {
+			message = "failed"
+		}
+		err = w.api(t).FailTask(ctx, t.ID, message)
+	case result.wait:
+		err = w.api(t).WaitForChildren(ctx, t.ID)
+		if client.IsCode(err, client.CodeTaskHasNoChildren) {
+			// Nothing would ever queue the task again
+			w.opts.Logger.WarnContext(ctx, "Task waits without subtasks, failing it", "task_id", t.ID)
+			err = w.api(t).FailTask(ctx, t.ID, errWaitWithoutChildren.Error())
+		}
+	default:
+		err = w.api(t).CompleteTask(ctx, t.ID, result.value)
+	}
+	if client.IsCode(err, client.CodeTaskAlreadyCompleted) || client.IsCode(err, client.CodeTaskNotFound) {
+		w.opts.Logger.WarnContext(ctx, "Task was finished elsewhere", "task_id", t.ID, "error", err)
+		return nil
+	}
+	return err
+}
+
+// errWaitWithoutChildren fails tasks whose handler returned Wait without
+// spawning any children.
+var errWaitWithoutChildren = errors.New("handler returned Wait without spawning subtasks")
@@ -0,0 +1,66 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"task-api/pkg/client"
+)
+
+type contextKey struct{}
+
+// taskContext is what a handler's context knows about its task.
+type taskContext struct {
+	w *Worker
+	t Task
+}
+
+func newContext(ctx context.Context, w *Worker, t Task) context.Context {
+	return context.WithValue(ctx, contextKey{}, &taskContext{w: w, t: t})
+}
+
+// errNoTask is returned by the helpers outside of a handler.
+var errNoTask = errors.New("worker: not called with a handler's context")
+
+// SpawnOptions are the optional fields of a child.
+type SpawnOptions struct {
+	// CallbackURL receives a webhook for each of CallbackEvents, by default
+	// completed and failed.
+	CallbackURL    string
+	CallbackEvents []string
+}
+
+// Spawn creates a child of the task the handler runs for, for the given
+// worker, and returns its id. The payload is encoded as JSON. Return Wait
+// from the handler after spawning: the task then runs again with the
+// results of its children in Subtasks once all of them are finished.
+//
+// Spawn is retried like client.Client.CreateTask. A *client.Error with code
+// tree_depth_exceeded or tree_size_exceeded means the work should be done
+// without delegating.
+func Spawn(ctx context.Context, worker string, payload any) (string, error) {
+	return SpawnWith(ctx, worker, payload, SpawnOptions{})
+}
+
+// SpawnWith is Spawn with callbacks.
+func SpawnWith(ctx context.Context, worker string, payload any, opts SpawnOptions) (string, error) {
+	r, ok := ctx.Value(contextKey{}).(*taskContext)
+	if !ok {
+		return "", errNoTask
+	}
+	return r.w.api(r.t).CreateTask(ctx, worker, client.CreateTaskRequest{
+		ParentID:       r.t.ID,
+		Payload:        payload,
+		CallbackURL:    opts.CallbackURL,
+		CallbackEvents: opts.CallbackEvents,
+	})
+}
+
+// Progress reports how far the handler got with its task, as any JSON
+// value up to 4 KB. Followers of the task receive it as an event.
+func Progress(ctx context.Context, progress any) error {
+	r, ok := ctx.Value(contextKey{}).(*taskContext)
+	if !ok {
+		return errNoTask
+	}
+	return r.w.api(r.t).ReportProgress(ctx, r.t.ID, progress)
+}
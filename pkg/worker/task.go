@@ -0,0 +1,189 @@
+package worker
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Task is a task as the worker receives it from its queue.
+type Task struct {
+	ID string
+	// Payload is the payload the task was created with. A task queued
+	// again after its children finished also has their results in it, as
+	// "subtasks".
+	Payload json.RawMessage
+	// Subtasks are the results of the children of a task queued again
+	// after all of them finished, nil on the first run.
+	Subtasks []Subtask
+
+	// traceparent is the trace context the task was published under
+	traceparent string
+}
+
+// Requeued reports whether the task was queued again because its children
+// finished, that is whether Subtasks holds their results.
+func (t Task) Requeued() bool {
+	return t.Subtasks != nil
+}
+
+// Decode decodes the payload into v.
+func (t Task) Decode(v any) error {
+	return json.Unmarshal(t.Payload, v)
+}
+
+// Subtask is the outcome of one child, as the API hands it to the parent.
+type Subtask struct {
+	ID     string
+	Worker string
+	// Error is set when the child failed or was cancelled.
+	Error string
+	// Result is the result the child was completed with. The API merges
+	// "id", "worker" and "subtasks" into results that are JSON objects, so
+	// they appear in it as well.
+	Result json.RawMessage
+	// Subtasks are the outcomes of the child's own children, if it passed
+	// them on in its result.
+	Subtasks []Subtask
+}
+
+// Failed reports whether the child failed or was cancelled.
+func (s Subtask) Failed() bool {
+	return s.Error != ""
+}
+
+// Decode decodes the result of the child into v.
+func (s Subtask) Decode(v any) error {
+	if s.Failed() {
+		return fmt.Errorf("worker: subtask %s failed: %s", s.ID, s.Error)
+	}
+	return json.Unmarshal(s.Result, v)
+}
+
+// mergedFields are the fields the API adds to the result of a child. A
+// result that is not an object is wrapped in "result" next to them.
+var mergedFields = map[string]bool{"id": true, "worker": true, "subtasks": true, "result": true, "error": true}
+
+// parseSubtask reads one entry of a "subtasks" list.
+func parseSubtask(raw json.RawMessage) (Subtask, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return Subtask{}, err
+	}
+
+	var s Subtask
+	json.Unmarshal(fields["id"], &s.ID)
+	json.Unmarshal(fields["worker"], &s.Worker)
+	json.Unmarshal(fields["error"], &s.Error)
+	if list, ok := fields["subtasks"]; ok {
+		subtasks, err := parseSubtasks(list)
+		if err != nil {
+			return Subtask{}, err
+		}
+		s.Subtasks = subtasks
+	}
+	if s.Failed() {
+		return s, nil
+	}
+
+	s.Result = raw
+	if result, ok := fields["result"]; ok {
+		wrapped := true
+		for k := range fields {
+			wrapped = wrapped && mergedFields[k]
+		}
+		if wrapped {
+			s.Result = result
+		}
+	}
+	return s, nil
+}
+
+func parseSubtasks(raw json.RawMessage) ([]Subtask, error) {
+	var list []json.RawMessage
+	if err := json.Unmarshal(raw, &list); err != nil {
+		return nil, err
+	}
+	subtasks := make([]Subtask, 0, len(list))
+	for _, item := range list {
+		s, err := parseSubtask(item)
+		if err != nil {
+			return nil, err
+		}
+		subtasks = append(subtasks, s)
+	}
+	return subtasks, nil
+}
+
+// parseTask reads the message the API publishes for every task.
+func parseTask(body []byte, traceparent string) (Task, error) {
+	var msg struct {
+		ID      string          `json:"id"`
+		Payload json.RawMessage `json:"payload"`
+	}
+	if err := json.Unmarshal(body, &msg); err != nil {
+		return Task{}, err
+	}
+	if msg.ID == "" {
+		return Task{}, fmt.Errorf("message has no id")
+	}
+
+	t := Task{ID: msg.ID, Payload: msg.Payload, traceparent: traceparent}
+	var fields map[string]json.RawMessage
+	if json.Unmarshal(msg.Payload, &fields) == nil {
+		if list, ok := fields["subtasks"]; ok {
+			subtasks, err := parseSubtasks(list)
+			if err != nil {
+				return Task{}, fmt.Errorf("invalid subtasks: %w", err)
+			}
+			t.Subtasks = subtasks
+		}
+	}
+	return t, nil
+}
+
+// Child is the outcome of a child with its result decoded.
+type Child[T any] struct {
+	ID     string
+	Worker string
+	// Result is the zero value for failed children.
+	Result T
+	// Error is set when the child failed or was cancelled.
+	Error string
+}
+
+// Children decodes the results of the children of a re-queued task into
+// T, in the order the API lists them.
+func Children[T any](t Task) ([]Child[T], error) {
+	children := make([]Child[T], 0, len(t.Subtasks))
+	for _, s := range t.Subtasks {
+		c := Child[T]{ID: s.ID, Worker: s.Worker, Error: s.Error}
+		if !s.Failed() {
+			if err := s.Decode(&c.Result); err != nil {
+				return nil, fmt.Errorf("worker: decoding result of subtask %s: %w", s.ID, err)
+			}
+		}
+		children = append(children, c)
+	}
+	return children, nil
+}
+
+// Result is how a handler finished a task. The zero Result completes the
+// task without a result.
+type Result struct {
+	value any
+	wait  bool
+}
+
+// Complete completes the task with v, encoded as JSON, as its result.
+func Complete(v any) Result {
+	return Result{value: v}
+}
+
+// Wait leaves the task open after the handler spawned children. The
+// message is acknowledged without completing the task; once all children
+// are finished, the API queues the task again with their results and the
+// handler runs again to complete it. A task without children is failed
+// instead, as it would never be queued again.
+func Wait() Result {
+	return Result{wait: true}
+}
@@ -0,0 +1,259 @@
+// Package worker runs Task API workers that consume their RabbitMQ queue.
+// It implements the protocol of AGENT_GUIDE.md: a Handler receives each
+// task, may spawn children with Spawn and return Wait, and runs again with
+// the children's results once they are finished. Its Result completes the
+// task, an error fails it.
+//
+// Tasks are acknowledged once they are reported to the API, so the broker
+// hands the tasks of a worker that dies, or loses its connection, to
+// another one. Handlers should therefore tolerate running twice for a task.
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"task-api/pkg/client"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// Handler processes one task.
+type Handler func(ctx context.Context, t Task) (Result, error)
+
+// Options configures a Worker.
+type Options struct {
+	// Name is the worker the tasks are for. It must be registered with the
+	// API.
+	Name string
+	// Namespace is the namespace of the worker, "default" if empty. It is
+	// only sent to the API if set, worker keys already determine it.
+	Namespace string
+	// AMQPURL is the RabbitMQ the API publishes to.
+	AMQPURL string
+	// APIURL is the base URL of the API, e.g. http://localhost:8080.
+	APIURL string
+	// APIKey is sent as a Bearer token if set.
+	APIKey string
+
+	// Concurrency is how many tasks are handled at once, by default 1.
+	Concurrency int
+	// Heartbeat is the AMQP heartbeat interval, by default 10s. The broker
+	// hands out the unacknowledged tasks of a worker again once it misses
+	// its heartbeats.
+	Heartbeat time.Duration
+	// ShutdownTimeout is how long Run lets running handlers finish once
+	// its context ends, by default 30s. After that their contexts are
+	// cancelled, and their tasks go back to the queue.
+	ShutdownTimeout time.Duration
+	// HTTPClient makes the API calls, by default http.DefaultClient. The
+	// calls are retried as client.Options describes.
+	HTTPClient *http.Client
+	// Logger receives the worker's logs, by default slog.Default().
+	Logger *slog.Logger
+}
+
+// Worker consumes the queue of one worker and runs a Handler for every
+// task.
+type Worker struct {
+	opts    Options
+	handler Handler
+	client  *client.Client
+}
+
+// New returns a Worker running h for the tasks of opts.Name.
+func New(opts Options, h Handler) (*Worker, error) {
+	if opts.Name == "" {
+		return nil, errors.New("worker: Name is required")
+	}
+	if opts.AMQPURL == "" || opts.APIURL == "" {
+		return nil, errors.New("worker: AMQPURL and APIURL are required")
+	}
+	if h == nil {
+		return nil, errors.New("worker: handler is required")
+	}
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = 1
+	}
+	if opts.Heartbeat <= 0 {
+		opts.Heartbeat = 10 * time.Second
+	}
+	if opts.ShutdownTimeout <= 0 {
+		opts.ShutdownTimeout = 30 * time.Second
+	}
+	if opts.Logger == nil {
+		opts.Logger = slog.Default()
+	}
+	opts.Logger = opts.Logger.With("worker", opts.Name)
+	c := client.New(opts.APIURL, client.Options{
+		APIKey:     opts.APIKey,
+		Namespace:  opts.Namespace,
+		HTTPClient: opts.HTTPClient,
+	})
+	return &Worker{opts: opts, handler: h, client: c}, nil
+}
+
+// queueName is the queue the API publishes the worker's tasks to.
+func (w *Worker) queueName() string {
+	return client.QueueName(w.opts.Namespace, w.opts.Name)
+}
+
+// Reconnect backoff after the broker connection fails.
+const (
+	minReconnect = time.Second
+	maxReconnect = 30 * time.Second
+)
+
+// Run handles tasks until ctx ends, reconnecting whenever the broker
+// connection fails. It then stops taking tasks and waits for the running
+// handlers, see Options.ShutdownTimeout, before it returns nil.
+func (w *Worker) Run(ctx context.Context) error {
+	// Handlers outlive ctx by up to the shutdown timeout
+	handlerCtx, cancelHandlers := context.WithCancel(context.WithoutCancel(ctx))
+	defer cancelHandlers()
+	var running sync.WaitGroup
+	drain := func() {
+		w.drain(&running, cancelHandlers)
+	}
+
+	backoff := minReconnect
+	for ctx.Err() == nil {
+		err := w.consume(ctx, handlerCtx, &running, drain)
+		if err == nil {
+			break
+		}
+		w.opts.Logger.ErrorContext(ctx, "Consuming failed, reconnecting", "error", err, "backoff", backoff.String())
+		select {
+		case <-ctx.Done():
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, maxReconnect)
+	}
+
+	// Handlers of a lost connection may still run
+	drain()
+	return nil
+}
+
+// drain waits for the running handlers, and cancels them once the
+// shutdown timeout has passed.
+func (w *Worker) drain(running *sync.WaitGroup, cancelHandlers context.CancelFunc) {
+	done := make(chan struct{})
+	go func() {
+		running.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(w.opts.ShutdownTimeout):
+		w.opts.Logger.Warn("Shutdown timeout, cancelling running tasks")
+		cancelHandlers()
+		<-done
+	}
+}
+
+// consume runs one broker connection. It returns an error if the
+// connection fails. Once ctx ends it stops consuming and drains the
+// running handlers, so that they can still settle their messages, and
+// returns nil.
+func (w *Worker) consume(ctx context.Context, handlerCtx context.Context, running *sync.WaitGroup, drain func()) error {
+	conn, err := amqp.DialConfig(w.opts.AMQPURL, amqp.Config{Heartbeat: w.opts.Heartbeat})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	ch, err := conn.Channel()
+	if err != nil {
+		return err
+	}
+	// Unacknowledged tasks are the running ones
+	if err := ch.Qos(w.opts.Concurrency, 0, false); err != nil {
+		return err
+	}
+	// Declared like the API does
+	if _, err := ch.QueueDeclare(w.queueName(), true, false, false, false, nil); err != nil {
+		return err
+	}
+	tag := fmt.Sprintf("%s-%p", w.opts.Name, w)
+	deliveries, err := ch.Consume(w.queueName(), tag, false, false, false, false, nil)
+	if err != nil {
+		return err
+	}
+	w.opts.Logger.InfoContext(ctx, "Consuming tasks", "queue", w.queueName(), "concurrency", w.opts.Concurrency)
+
+	for {
+		select {
+		case <-ctx.Done():
+			// Messages received but not handled go back to the queue
+			// with the channel
+			ch.Cancel(tag, false)
+			drain()
+			return nil
+		case d, ok := <-deliveries:
+			if !ok {
+				return errors.New("worker: broker connection closed")
+			}
+			running.Add(1)
+			go func() {
+				defer running.Done()
+				w.handle(handlerCtx, d)
+			}()
+		}
+	}
+}
+
+// handle runs the handler for one message and settles it.
+func (w *Worker) handle(ctx context.Context, d amqp.Delivery) {
+	traceparent, _ := d.Headers["traceparent"].(string)
+	t, err := parseTask(d.Body, traceparent)
+	if err != nil {
+		w.opts.Logger.WarnContext(ctx, "Dropping malformed message", "error", err)
+		d.Nack(false, false)
+		return
+	}
+	log := w.opts.Logger.With("task_id", t.ID)
+
+	result, err := w.run(ctx, t)
+	if err != nil && ctx.Err() != nil {
+		// Cut short by the shutdown, another worker takes over
+		log.WarnContext(ctx, "Task interrupted, requeueing", "error", err)
+		d.Nack(false, true)
+		return
+	}
+
+	switch {
+	case err != nil:
+		log.InfoContext(ctx, "Task failed", "error", err)
+		err = w.report(ctx, t, err, result)
+	case result.wait:
+		log.InfoContext(ctx, "Task waits for its children")
+		err = w.report(ctx, t, nil, result)
+	default:
+		err = w.report(ctx, t, nil, result)
+	}
+	if err != nil {
+		log.ErrorContext(ctx, "Reporting task failed, requeueing", "error", err)
+		d.Nack(false, true)
+		return
+	}
+	if err := d.Ack(false); err != nil {
+		// Delivered again on another connection, and then reported again
+		log.WarnContext(ctx, "Acknowledging task failed", "error", err)
+	}
+}
+
+// run calls the handler with the task in its context. A panic fails the
+// task.
+func (w *Worker) run(ctx context.Context, t Task) (result Result, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			w.opts.Logger.ErrorContext(ctx, "Handler panicked", "task_id", t.ID, "panic", r)
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return w.handler(newContext(ctx, w, t), t)
+}